@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"golang-webserver/webserver"
+	"os"
+	"os/signal"
 	"regexp"
+	"syscall"
+	"time"
 )
 
 type Person struct {
@@ -15,8 +21,22 @@ type Person struct {
 func main() {
 	ws := webserver.NewWebServer()
 
-	// Add a handler with a `StringPath`
-	ws.AddHandler(webserver.NewHandler(webserver.MethodGet, webserver.StringPath("/api/person"), func(request webserver.Request) webserver.Response {
+	// Compress responses for clients that support it
+	ws.Use(webserver.NewCompressionMiddleware(webserver.DefaultCompressionOptions()))
+
+	apiPath := webserver.RegexPath(regexp.MustCompile("^/api"))
+
+	// Let browser apps on other origins call the API. This runs before the rate limiter so that preflights are answered
+	// without counting against it.
+	corsOptions := webserver.DefaultCORSOptions()
+	corsOptions.AllowedOrigins = []string{"http://localhost:3000", "https://*.example.com"}
+	ws.Use(webserver.ForPath(apiPath, webserver.NewCORSMiddleware(corsOptions)))
+
+	// Protect the API from clients making too many requests
+	ws.Use(webserver.ForPath(apiPath, webserver.NewRateLimitMiddleware(webserver.DefaultRateLimitOptions())))
+
+	// Add a handler with a `StringPath` that can return an error
+	ws.AddHandler(webserver.NewHandlerWithError(webserver.MethodGet, webserver.StringPath("/api/person"), func(request webserver.Request) (webserver.Response, error) {
 		person := Person{
 			Name: "John Doe",
 			Age:  30,
@@ -24,13 +44,13 @@ func main() {
 
 		responseJson, err := json.Marshal(person)
 		if err != nil {
-			return webserver.InternalErrorResponse()
+			return nil, err
 		}
 
 		response := webserver.OkResponseWithBody(responseJson)
 		response.Headers().SetHeader("Content-Type", "application/json")
 
-		return response
+		return response, nil
 	}))
 
 	// Add a handler with a RegexPath
@@ -38,11 +58,31 @@ func main() {
 		return webserver.OkResponseWithBody([]byte("Received request at " + request.Path()))
 	}))
 
+	// Expose Prometheus metrics
+	ws.EnableMetrics("/metrics")
+
 	// Map static files
 	ws.StaticFiles("www")
 
-	if err := ws.Run(8080); err != nil {
-		fmt.Printf("Fatal error occurred while running server: %v", err)
+	// Shut down gracefully on Ctrl+C, giving requests in flight up to 10 seconds to finish
+	shutdownComplete := make(chan struct{})
+	go func() {
+		defer close(shutdownComplete)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = ws.Shutdown(shutdownCtx)
+	}()
+
+	err := ws.Run(8080)
+	if errors.Is(err, webserver.ErrServerClosed) {
+		<-shutdownComplete
+		return
 	}
 
+	fmt.Printf("Fatal error occurred while running server: %v", err)
 }
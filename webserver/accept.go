@@ -0,0 +1,67 @@
+package webserver
+
+import "strings"
+
+// negotiateMediaType picks the media type the client prefers out of the offered ones, which are listed in the server's
+// order of preference, based on an Accept header. An empty string is returned if the client accepts none of them; if
+// the Accept header is empty, the first offer is returned.
+func negotiateMediaType(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		if len(offers) == 0 {
+			return ""
+		}
+
+		return offers[0]
+	}
+
+	bestOffer := ""
+	bestQ := 0.0
+	bestSpecificity := -1
+	for _, offer := range offers {
+		q, specificity := mediaTypeQuality(accept, strings.ToLower(offer))
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			bestOffer = offer
+			bestQ = q
+			bestSpecificity = specificity
+		}
+	}
+
+	return bestOffer
+}
+
+// mediaTypeQuality returns the q-value the Accept header gives a media type, taken from the most specific media range
+// that matches it, along with how specific that range was: 2 for an exact match, 1 for `type/*` and 0 for `*/*`
+func mediaTypeQuality(accept string, mediaType string) (float64, int) {
+	mainType, _, _ := strings.Cut(mediaType, "/")
+
+	q := 0.0
+	specificity := -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+
+		rangeSpecificity := -1
+		switch mediaRange {
+		case mediaType:
+			rangeSpecificity = 2
+		case mainType + "/*":
+			rangeSpecificity = 1
+		case "*/*":
+			rangeSpecificity = 0
+		}
+
+		if rangeSpecificity <= specificity {
+			continue
+		}
+
+		rangeQ, ok := parseQValue(params[1:])
+		if !ok {
+			continue
+		}
+
+		q = rangeQ
+		specificity = rangeSpecificity
+	}
+
+	return q, specificity
+}
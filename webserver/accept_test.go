@@ -0,0 +1,29 @@
+package webserver
+
+import "testing"
+
+func TestNegotiateMediaType(t *testing.T) {
+	offers := []string{"application/json", "text/html"}
+
+	var tests = []struct {
+		accept   string
+		expected string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"text/html", "text/html"},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "text/html"},
+		{"application/json, text/html;q=0.5", "application/json"},
+		{"text/*", "text/html"},
+		{"text/*;q=0.5, */*;q=0.1", "text/html"},
+		{"text/html;q=0, */*", "application/json"},
+		{"image/png", ""},
+	}
+
+	for _, test := range tests {
+		mediaType := negotiateMediaType(test.accept, offers)
+		if mediaType != test.expected {
+			t.Errorf("negotiateMediaType(%q) expected %q, got %q", test.accept, test.expected, mediaType)
+		}
+	}
+}
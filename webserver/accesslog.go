@@ -0,0 +1,140 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat is the format access log lines are written in
+type AccessLogFormat int
+
+const (
+	// AccessLogCommon is the Apache Common Log Format, e.g.
+	// `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326`
+	AccessLogCommon AccessLogFormat = iota
+	// AccessLogCombined is the Apache Combined Log Format, which adds the referer and user agent to the Common Log Format
+	AccessLogCombined
+	// AccessLogJSON writes each request as a JSON object on its own line, including the latency
+	AccessLogJSON
+)
+
+// AccessLogOptions configures the access log middleware
+type AccessLogOptions struct {
+	// Format is the format each line is written in
+	Format AccessLogFormat
+	// Writer is where lines are written. Wrap it in an AsyncWriter to keep writes off the request path, or use a
+	// RotatingFileWriter to rotate log files by size.
+	Writer io.Writer
+}
+
+// accessLogEntry holds what is recorded about each request
+type accessLogEntry struct {
+	RemoteAddr string    `json:"remote_addr"`
+	Time       time.Time `json:"time"`
+	Method     Method    `json:"method"`
+	Path       string    `json:"path"`
+	Protocol   string    `json:"protocol"`
+	Status     int       `json:"status"`
+	Bytes      int       `json:"bytes"`
+	Referer    string    `json:"referer"`
+	UserAgent  string    `json:"user_agent"`
+	LatencyMs  float64   `json:"latency_ms"`
+}
+
+// NewAccessLogMiddleware creates a middleware that writes a line to the access log for every request
+func NewAccessLogMiddleware(options AccessLogOptions) Middleware {
+	// Lines are written whole under the mutex so that concurrent requests don't interleave
+	var mutex sync.Mutex
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(request Request) Response {
+			start := time.Now()
+			response := next(request)
+
+			referer, _ := request.Headers().GetHeader("Referer")
+			userAgent, _ := request.Headers().GetHeader("User-Agent")
+
+			line := formatAccessLogEntry(accessLogEntry{
+				RemoteAddr: request.ClientIP(),
+				Time:       start,
+				Method:     request.Method(),
+				Path:       request.Path(),
+				Protocol:   request.Protocol(),
+				Status:     response.StatusCode(),
+				Bytes:      len(response.Body()),
+				Referer:    referer,
+				UserAgent:  userAgent,
+				LatencyMs:  float64(time.Since(start).Microseconds()) / 1000,
+			}, options.Format)
+
+			mutex.Lock()
+			_, _ = options.Writer.Write(line)
+			mutex.Unlock()
+
+			return response
+		}
+	}
+}
+
+// formatAccessLogEntry formats an entry as a single line, including the trailing newline
+func formatAccessLogEntry(entry accessLogEntry, format AccessLogFormat) []byte {
+	if format == AccessLogJSON {
+		line, err := json.Marshal(entry)
+		if err == nil {
+			return append(line, '\n')
+		}
+	}
+
+	bytes := "-"
+	if entry.Bytes > 0 {
+		bytes = fmt.Sprint(entry.Bytes)
+	}
+
+	line := fmt.Sprintf("%s - - [%s] \"%s\" %d %s",
+		accessLogField(entry.RemoteAddr),
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		escapeAccessLogValue(fmt.Sprintf("%s %s %s", entry.Method, entry.Path, entry.Protocol)),
+		entry.Status,
+		bytes,
+	)
+
+	if format == AccessLogCombined {
+		line += fmt.Sprintf(" \"%s\" \"%s\"", escapeAccessLogValue(accessLogField(entry.Referer)), escapeAccessLogValue(accessLogField(entry.UserAgent)))
+	}
+
+	return []byte(line + "\n")
+}
+
+// accessLogField returns `-` in place of an empty value, as the log formats expect
+func accessLogField(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
+// escapeAccessLogValue escapes quotes, backslashes and control characters in a quoted value so a client can't forge
+// log lines
+func escapeAccessLogValue(value string) string {
+	var builder strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			builder.WriteByte('\\')
+			builder.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&builder, "\\x%02x", c)
+		default:
+			builder.WriteByte(c)
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,97 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatAccessLogEntry(t *testing.T) {
+	entry := accessLogEntry{
+		RemoteAddr: "127.0.0.1",
+		Time:       time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60)),
+		Method:     MethodGet,
+		Path:       "/index.html",
+		Protocol:   "HTTP/1.1",
+		Status:     200,
+		Bytes:      2326,
+		Referer:    "http://www.example.com/start.html",
+		UserAgent:  "Mozilla/4.08 [en] (Win98; I ;Nav)",
+	}
+
+	var tests = []struct {
+		format   AccessLogFormat
+		expected string
+	}{
+		{AccessLogCommon, "127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET /index.html HTTP/1.1\" 200 2326\n"},
+		{AccessLogCombined, "127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET /index.html HTTP/1.1\" 200 2326 \"http://www.example.com/start.html\" \"Mozilla/4.08 [en] (Win98; I ;Nav)\"\n"},
+	}
+
+	for _, test := range tests {
+		line := string(formatAccessLogEntry(entry, test.format))
+		if line != test.expected {
+			t.Errorf("formatAccessLogEntry() with format %v expected %q, got %q", test.format, test.expected, line)
+		}
+	}
+}
+
+func TestFormatAccessLogEntryEscapesValues(t *testing.T) {
+	entry := accessLogEntry{
+		RemoteAddr: "127.0.0.1",
+		Method:     MethodGet,
+		Path:       "/",
+		Protocol:   "HTTP/1.1",
+		Status:     204,
+		UserAgent:  "evil\" \"agent\n127.0.0.1 - - fake",
+	}
+
+	line := string(formatAccessLogEntry(entry, AccessLogCombined))
+	if strings.Count(line, "\n") != 1 || !strings.Contains(line, "\"evil\\\" \\\"agent\\x0a127.0.0.1 - - fake\"") {
+		t.Fatalf("Expected the user agent to be escaped but received %q", line)
+	}
+
+	if !strings.Contains(line, "204 - \"-\"") {
+		t.Fatalf("Expected missing values to be logged as - but received %q", line)
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	var buffer bytes.Buffer
+
+	middleware := NewAccessLogMiddleware(AccessLogOptions{Format: AccessLogJSON, Writer: &buffer})
+	handler := middleware(func(request Request) Response {
+		return NotFoundResponseWithBody([]byte("Not here"))
+	})
+
+	request := newTestRequest(MethodGet, "/missing", map[string]string{"User-Agent": "curl/8.0", "Referer": "http://localhost/"})
+	request.protocol = "HTTP/1.1"
+	request.remoteAddr = "192.168.0.10:51234"
+	handler(request)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buffer.Bytes(), &entry); err != nil {
+		t.Fatalf("Could not parse the access log line %q: %v", buffer.String(), err)
+	}
+
+	expected := map[string]any{
+		"remote_addr": "192.168.0.10",
+		"method":      "GET",
+		"path":        "/missing",
+		"protocol":    "HTTP/1.1",
+		"status":      float64(404),
+		"bytes":       float64(8),
+		"referer":     "http://localhost/",
+		"user_agent":  "curl/8.0",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("Expected %s to be %v but was %v", key, value, entry[key])
+		}
+	}
+
+	if _, found := entry["latency_ms"]; !found {
+		t.Errorf("Expected the entry to include latency_ms")
+	}
+}
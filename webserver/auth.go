@@ -0,0 +1,272 @@
+package webserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrUnauthorized is the cause of the error rendered when a request doesn't carry valid credentials
+var ErrUnauthorized = errors.New("the request could not be authenticated")
+
+// ErrInvalidToken is returned by token validators when a bearer token is malformed, expired or otherwise invalid
+var ErrInvalidToken = errors.New("the token is invalid")
+
+// ErrInvalidHtpasswd is returned when a line of an htpasswd file isn't in the `user:hash` format
+var ErrInvalidHtpasswd = errors.New("the htpasswd file is in the incorrect format")
+
+// ErrUnsupportedPasswordHash is returned when an htpasswd file contains a password hash in a format that isn't
+// supported. Only bcrypt and {SHA} hashes are supported.
+var ErrUnsupportedPasswordHash = errors.New("the password hash format is not supported")
+
+// Principal is the authenticated identity a request was made by
+type Principal struct {
+	// Name identifies the user or client, e.g. the username or a token's subject
+	Name string
+	// Scheme is the authentication scheme that was used, e.g. `Basic` or `Bearer`
+	Scheme string
+	// Claims holds any extra information about the principal, e.g. the claims of a token
+	Claims map[string]any
+}
+
+// principalKey is the context key the authenticated principal is stored under
+type principalKey struct{}
+
+// PrincipalFromRequest returns the principal the authentication middleware attached to the request
+func PrincipalFromRequest(request Request) (Principal, bool) {
+	principal, found := request.Context().Value(principalKey{}).(Principal)
+	return principal, found
+}
+
+// Htpasswd holds the users and password hashes from an htpasswd file
+type Htpasswd struct {
+	users map[string]passwordHash
+	// dummy is checked against when the user doesn't exist, so that unknown users take as long as known ones
+	dummy passwordHash
+}
+
+// passwordHash verifies passwords against a stored hash
+type passwordHash interface {
+	verify(password string) bool
+}
+
+// shaHash is a base64 encoded SHA-1 digest of the password, stored as `{SHA}<digest>`
+type shaHash []byte
+
+func (h shaHash) verify(password string) bool {
+	digest := sha1.Sum([]byte(password))
+	return subtle.ConstantTimeCompare(digest[:], h) == 1
+}
+
+// LoadHtpasswd reads the htpasswd file at path
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open htpasswd file: %w", err)
+	}
+	defer file.Close()
+
+	return ParseHtpasswd(file)
+}
+
+// ParseHtpasswd reads `user:hash` lines in the htpasswd format. Blank lines and lines starting with `#` are skipped.
+func ParseHtpasswd(reader io.Reader) (*Htpasswd, error) {
+	htpasswd := &Htpasswd{users: make(map[string]passwordHash)}
+
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, encoded, found := strings.Cut(line, ":")
+		if !found || user == "" {
+			return nil, fmt.Errorf("line %d of htpasswd file: %w", lineNumber, ErrInvalidHtpasswd)
+		}
+
+		hash, err := parsePasswordHash(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("line %d of htpasswd file: %w", lineNumber, err)
+		}
+
+		htpasswd.users[user] = hash
+		if _, isBcrypt := hash.(bcryptHash); isBcrypt || htpasswd.dummy == nil {
+			htpasswd.dummy = hash
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+
+	return htpasswd, nil
+}
+
+// parsePasswordHash parses a bcrypt or {SHA} password hash
+func parsePasswordHash(encoded string) (passwordHash, error) {
+	if digest, found := strings.CutPrefix(encoded, "{SHA}"); found {
+		decoded, err := base64.StdEncoding.DecodeString(digest)
+		if err != nil || len(decoded) != sha1.Size {
+			return nil, ErrUnsupportedPasswordHash
+		}
+
+		return shaHash(decoded), nil
+	}
+
+	if strings.HasPrefix(encoded, "$2") {
+		return parseBcryptHash(encoded)
+	}
+
+	return nil, ErrUnsupportedPasswordHash
+}
+
+// Verify returns whether the user exists and the password matches their hash
+func (h *Htpasswd) Verify(user string, password string) bool {
+	hash, found := h.users[user]
+	if !found {
+		if h.dummy != nil {
+			h.dummy.verify(password)
+		}
+
+		return false
+	}
+
+	return hash.verify(password)
+}
+
+// BasicAuthOptions configures the Basic authentication middleware
+type BasicAuthOptions struct {
+	// Realm is shown to the user when their browser asks for credentials
+	Realm string
+	// Users holds the usernames and password hashes that are allowed in
+	Users *Htpasswd
+}
+
+// NewBasicAuthMiddleware creates a middleware that requires requests to carry Basic credentials that match a user in
+// the htpasswd file. Other requests receive a 401 with a challenge that makes browsers prompt for a username and
+// password. Handlers can read the username with PrincipalFromRequest.
+func NewBasicAuthMiddleware(options BasicAuthOptions) Middleware {
+	challenge := fmt.Sprintf(`Basic realm=%s, charset="UTF-8"`, quoteAuthParam(options.Realm))
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(request Request) Response {
+			user, password, found := basicCredentials(request)
+			if !found || options.Users == nil || !options.Users.Verify(user, password) {
+				return unauthorizedResponse(request, challenge, "Valid credentials are required.")
+			}
+
+			return next(request.WithValue(principalKey{}, Principal{Name: user, Scheme: "Basic"}))
+		}
+	}
+}
+
+// basicCredentials returns the username and password from a request's Basic Authorization header
+func basicCredentials(request Request) (string, string, bool) {
+	encoded, found := authorizationCredentials(request, "Basic")
+	if !found {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+
+	return strings.Cut(string(decoded), ":")
+}
+
+// TokenValidator checks bearer tokens and returns the principal they were issued to. It should return an error wrapping
+// ErrInvalidToken for tokens that are malformed, expired or revoked. Errors that are an *HTTPError are rendered as they
+// are, e.g. so that a validator can respond with a 503 when the service it checks tokens against is down.
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string) (Principal, error)
+}
+
+// TokenValidatorFunc lets an ordinary function be used as a TokenValidator
+type TokenValidatorFunc func(ctx context.Context, token string) (Principal, error)
+
+// ValidateToken calls the function
+func (f TokenValidatorFunc) ValidateToken(ctx context.Context, token string) (Principal, error) {
+	return f(ctx, token)
+}
+
+// BearerAuthOptions configures the Bearer authentication middleware
+type BearerAuthOptions struct {
+	// Realm is included in the challenge sent with 401 responses
+	Realm string
+	// Validator checks the tokens
+	Validator TokenValidator
+}
+
+// NewBearerAuthMiddleware creates a middleware that requires requests to carry a bearer token the validator accepts.
+// Requests without a token receive a 401 with a challenge, and requests with an invalid token also get the
+// `invalid_token` error code. Handlers can read the principal with PrincipalFromRequest.
+func NewBearerAuthMiddleware(options BearerAuthOptions) Middleware {
+	challenge := "Bearer realm=" + quoteAuthParam(options.Realm)
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(request Request) Response {
+			token, found := authorizationCredentials(request, "Bearer")
+			if !found {
+				return unauthorizedResponse(request, challenge, "A bearer token is required.")
+			}
+
+			if options.Validator == nil {
+				return unauthorizedResponse(request, challenge+`, error="invalid_token"`, "The bearer token is invalid.")
+			}
+
+			principal, err := options.Validator.ValidateToken(request.Context(), token)
+			if err != nil {
+				var httpError *HTTPError
+				if errors.As(err, &httpError) {
+					return RenderError(request, httpError)
+				}
+
+				return unauthorizedResponse(request, challenge+`, error="invalid_token"`, "The bearer token is invalid.")
+			}
+
+			if principal.Scheme == "" {
+				principal.Scheme = "Bearer"
+			}
+
+			return next(request.WithValue(principalKey{}, principal))
+		}
+	}
+}
+
+// authorizationCredentials returns the credentials from the Authorization header if it uses the given scheme, which is
+// matched case-insensitively
+func authorizationCredentials(request Request, scheme string) (string, bool) {
+	authorization, err := request.Headers().GetHeader("Authorization")
+	if err != nil {
+		return "", false
+	}
+
+	requestScheme, credentials, found := strings.Cut(strings.TrimSpace(authorization), " ")
+	if !found || !strings.EqualFold(requestScheme, scheme) {
+		return "", false
+	}
+
+	credentials = strings.TrimSpace(credentials)
+	return credentials, credentials != ""
+}
+
+// unauthorizedResponse renders a 401 with the WWW-Authenticate challenge
+func unauthorizedResponse(request Request, challenge string, message string) Response {
+	response := RenderError(request, UnauthorizedError(message, ErrUnauthorized))
+	response.Headers().SetHeader("WWW-Authenticate", challenge)
+	return response
+}
+
+// quoteAuthParam quotes a value for use in an authentication challenge
+func quoteAuthParam(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
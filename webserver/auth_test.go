@@ -0,0 +1,228 @@
+package webserver
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testHtpasswd = `# Users
+alice:$2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW
+
+bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=
+`
+
+func basicAuthorization(user string, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestHtpasswd_Verify(t *testing.T) {
+	htpasswd, err := ParseHtpasswd(strings.NewReader(testHtpasswd))
+	if err != nil {
+		t.Fatalf("Received an error parsing the htpasswd file: %v", err)
+	}
+
+	var tests = []struct {
+		user     string
+		password string
+		expected bool
+	}{
+		{"alice", "U*U", true},
+		{"alice", "secret", false},
+		{"bob", "secret", true},
+		{"bob", "U*U", false},
+		{"carol", "secret", false},
+	}
+
+	for _, test := range tests {
+		if htpasswd.Verify(test.user, test.password) != test.expected {
+			t.Errorf("Verify(%q, %q) expected %v", test.user, test.password, test.expected)
+		}
+	}
+}
+
+func TestParseHtpasswd_Invalid(t *testing.T) {
+	var tests = []struct {
+		name     string
+		contents string
+		expected error
+	}{
+		{"Missing hash", "alice\n", ErrInvalidHtpasswd},
+		{"Missing user", ":{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n", ErrInvalidHtpasswd},
+		{"MD5 hash", "alice:$apr1$salt$hash\n", ErrUnsupportedPasswordHash},
+		{"Plain text", "alice:secret\n", ErrUnsupportedPasswordHash},
+		{"Truncated SHA", "alice:{SHA}5en6G6Me\n", ErrUnsupportedPasswordHash},
+		{"Invalid bcrypt", "alice:$2b$05$short\n", ErrInvalidBcryptHash},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseHtpasswd(strings.NewReader(test.contents)); !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v but received %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	if err := os.WriteFile(path, []byte(testHtpasswd), 0o600); err != nil {
+		t.Fatalf("Could not write the htpasswd file: %v", err)
+	}
+
+	htpasswd, err := LoadHtpasswd(path)
+	if err != nil {
+		t.Fatalf("Received an error loading the htpasswd file: %v", err)
+	}
+
+	if !htpasswd.Verify("bob", "secret") {
+		t.Fatalf("Expected bob to be loaded")
+	}
+
+	if _, err := LoadHtpasswd(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected a missing file to return os.ErrNotExist but received %v", err)
+	}
+}
+
+func TestNewBasicAuthMiddleware(t *testing.T) {
+	htpasswd, err := ParseHtpasswd(strings.NewReader(testHtpasswd))
+	if err != nil {
+		t.Fatalf("Received an error parsing the htpasswd file: %v", err)
+	}
+
+	handler := NewBasicAuthMiddleware(BasicAuthOptions{Realm: "Admin \"area\"", Users: htpasswd})(func(request Request) Response {
+		principal, found := PrincipalFromRequest(request)
+		if !found {
+			return InternalErrorResponse()
+		}
+
+		return OkResponseWithBody([]byte(principal.Scheme + " " + principal.Name))
+	})
+
+	var tests = []struct {
+		name          string
+		authorization string
+		expected      int
+		body          string
+	}{
+		{"Valid bcrypt credentials", basicAuthorization("alice", "U*U"), 200, "Basic alice"},
+		{"Valid SHA credentials", basicAuthorization("bob", "secret"), 200, "Basic bob"},
+		{"Lowercase scheme", strings.Replace(basicAuthorization("bob", "secret"), "Basic", "basic", 1), 200, "Basic bob"},
+		{"Wrong password", basicAuthorization("alice", "wrong"), 401, ""},
+		{"Unknown user", basicAuthorization("carol", "secret"), 401, ""},
+		{"Invalid base64", "Basic !!!", 401, ""},
+		{"Missing colon", "Basic " + base64.StdEncoding.EncodeToString([]byte("bob")), 401, ""},
+		{"Bearer scheme", "Bearer token", 401, ""},
+		{"No credentials", "", 401, ""},
+	}
+
+	for _, test := range tests {
+		headers := map[string]string{}
+		if test.authorization != "" {
+			headers["Authorization"] = test.authorization
+		}
+
+		response := handler(newTestRequest(MethodGet, "/admin", headers))
+		if response.StatusCode() != test.expected {
+			t.Errorf("%s: expected %d but received %d", test.name, test.expected, response.StatusCode())
+			continue
+		}
+
+		if test.expected == 200 {
+			if string(response.Body()) != test.body {
+				t.Errorf("%s: expected the body to be %q but received %q", test.name, test.body, string(response.Body()))
+			}
+			continue
+		}
+
+		challenge, _ := response.Headers().GetHeader("WWW-Authenticate")
+		if challenge != `Basic realm="Admin \"area\"", charset="UTF-8"` {
+			t.Errorf("%s: expected a Basic challenge but received %q", test.name, challenge)
+		}
+	}
+}
+
+func TestNewBearerAuthMiddleware(t *testing.T) {
+	validator := TokenValidatorFunc(func(ctx context.Context, token string) (Principal, error) {
+		switch token {
+		case "valid":
+			return Principal{Name: "service", Claims: map[string]any{"scope": "read"}}, nil
+		case "unavailable":
+			return Principal{}, NewHTTPError(503, "The token service is unavailable.", nil)
+		default:
+			return Principal{}, ErrInvalidToken
+		}
+	})
+
+	handler := NewBearerAuthMiddleware(BearerAuthOptions{Realm: "api", Validator: validator})(func(request Request) Response {
+		principal, found := PrincipalFromRequest(request)
+		if !found {
+			return InternalErrorResponse()
+		}
+
+		return OkResponseWithBody([]byte(principal.Scheme + " " + principal.Name + " " + principal.Claims["scope"].(string)))
+	})
+
+	var tests = []struct {
+		name          string
+		authorization string
+		expected      int
+		challenge     string
+	}{
+		{"Valid token", "Bearer valid", 200, ""},
+		{"Invalid token", "Bearer expired", 401, `Bearer realm="api", error="invalid_token"`},
+		{"Validator HTTP error", "Bearer unavailable", 503, ""},
+		{"Basic scheme", basicAuthorization("bob", "secret"), 401, `Bearer realm="api"`},
+		{"Empty token", "Bearer ", 401, `Bearer realm="api"`},
+		{"No token", "", 401, `Bearer realm="api"`},
+	}
+
+	for _, test := range tests {
+		headers := map[string]string{}
+		if test.authorization != "" {
+			headers["Authorization"] = test.authorization
+		}
+
+		response := handler(newTestRequest(MethodGet, "/api/person", headers))
+		if response.StatusCode() != test.expected {
+			t.Errorf("%s: expected %d but received %d", test.name, test.expected, response.StatusCode())
+			continue
+		}
+
+		if test.expected == 200 && string(response.Body()) != "Bearer service read" {
+			t.Errorf("%s: expected the principal to be attached but received %q", test.name, string(response.Body()))
+		}
+
+		if challenge, _ := response.Headers().GetHeader("WWW-Authenticate"); challenge != test.challenge {
+			t.Errorf("%s: expected the challenge %q but received %q", test.name, test.challenge, challenge)
+		}
+	}
+}
+
+func TestWebServer_BasicAuthChallenge(t *testing.T) {
+	htpasswd, err := ParseHtpasswd(strings.NewReader(testHtpasswd))
+	if err != nil {
+		t.Fatalf("Received an error parsing the htpasswd file: %v", err)
+	}
+
+	ws := NewWebServer()
+	ws.Use(NewBasicAuthMiddleware(BasicAuthOptions{Realm: "Admin", Users: htpasswd}))
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/admin"), func(request Request) Response {
+		return OkResponse()
+	}))
+	ws.SetErrorHandler(func(request Request, err error) Response {
+		return NewResponseWithBody(401, []byte("Please log in"))
+	})
+
+	rawResponse := serveTestRequest(t, &ws, "GET /admin HTTP/1.1\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 401 Unauthorized\r\n") ||
+		!strings.Contains(rawResponse, "WWW-Authenticate: Basic realm=\"Admin\", charset=\"UTF-8\"\r\n") {
+		t.Fatalf("Expected a 401 with a challenge but received %q", rawResponse)
+	}
+
+	if !strings.HasSuffix(rawResponse, "Please log in") {
+		t.Fatalf("Expected the error handler to render the 401 but received %q", rawResponse)
+	}
+}
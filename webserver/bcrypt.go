@@ -0,0 +1,191 @@
+package webserver
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidBcryptHash is returned when a bcrypt hash isn't in the `$2b$<cost>$<salt><hash>` format
+var ErrInvalidBcryptHash = errors.New("the bcrypt hash is in the incorrect format")
+
+// bcryptEncoding is the base64 variant bcrypt uses for its salt and hash, which has its own alphabet and no padding
+var bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").
+	WithPadding(base64.NoPadding)
+
+// bcryptMagic is the text bcrypt encrypts repeatedly with the expensive key schedule
+var bcryptMagic = []byte("OrpheanBeholderScryDoubt")
+
+const (
+	bcryptMinCost = 4
+	bcryptMaxCost = 31
+	// bcryptSaltLength and bcryptHashLength are the encoded lengths of the 16 byte salt and the 23 byte hash
+	bcryptSaltLength = 22
+	bcryptHashLength = 31
+	// bcryptMaxKeyLength is the most bytes of a password bcrypt uses, including the trailing NUL
+	bcryptMaxKeyLength = 72
+)
+
+// bcryptHash is a parsed bcrypt hash
+type bcryptHash struct {
+	version string
+	cost    int
+	salt    []byte
+	hash    string
+}
+
+// parseBcryptHash parses a hash in the `$2b$<cost>$<salt><hash>` format. The $2a$ and $2y$ versions are accepted too,
+// as they only differ from $2b$ in how older implementations handled long or non-ASCII passwords.
+func parseBcryptHash(encoded string) (bcryptHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "" {
+		return bcryptHash{}, ErrInvalidBcryptHash
+	}
+
+	version := parts[1]
+	if version != "2a" && version != "2b" && version != "2y" {
+		return bcryptHash{}, ErrInvalidBcryptHash
+	}
+
+	cost, err := strconv.Atoi(parts[2])
+	if err != nil || len(parts[2]) != 2 || cost < bcryptMinCost || cost > bcryptMaxCost {
+		return bcryptHash{}, ErrInvalidBcryptHash
+	}
+
+	if len(parts[3]) != bcryptSaltLength+bcryptHashLength {
+		return bcryptHash{}, ErrInvalidBcryptHash
+	}
+
+	salt, err := bcryptEncoding.DecodeString(parts[3][:bcryptSaltLength])
+	if err != nil {
+		return bcryptHash{}, ErrInvalidBcryptHash
+	}
+
+	hash := parts[3][bcryptSaltLength:]
+	if _, err := bcryptEncoding.DecodeString(hash); err != nil {
+		return bcryptHash{}, ErrInvalidBcryptHash
+	}
+
+	return bcryptHash{version: version, cost: cost, salt: salt, hash: hash}, nil
+}
+
+// verify returns whether the password hashes to the same value, comparing the hashes in constant time
+func (h bcryptHash) verify(password string) bool {
+	computed := bcryptEncoding.EncodeToString(bcrypt([]byte(password), h.salt, h.cost))
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(h.hash)) == 1
+}
+
+// bcrypt hashes the password with the salt, doing 2^cost rounds of the expensive key schedule, and returns the 23 byte
+// hash
+func bcrypt(password []byte, salt []byte, cost int) []byte {
+	// The key includes the trailing NUL of the C string, unless the password is so long that it gets cut off
+	key := make([]byte, 0, len(password)+1)
+	key = append(append(key, password...), 0)
+	if len(key) > bcryptMaxKeyLength {
+		key = key[:bcryptMaxKeyLength]
+	}
+
+	cipher := newEksBlowfish(salt, key, cost)
+
+	var text [6]uint32
+	for i := range text {
+		text[i] = binary.BigEndian.Uint32(bcryptMagic[i*4:])
+	}
+
+	for i := 0; i < 64; i++ {
+		for j := 0; j < len(text); j += 2 {
+			text[j], text[j+1] = cipher.encrypt(text[j], text[j+1])
+		}
+	}
+
+	hash := make([]byte, len(text)*4)
+	for i, word := range text {
+		binary.BigEndian.PutUint32(hash[i*4:], word)
+	}
+
+	// The last byte is dropped so that the hash fits in 31 base64 characters
+	return hash[:23]
+}
+
+// blowfish holds the subkeys of a Blowfish cipher
+type blowfish struct {
+	p [18]uint32
+	s [4][256]uint32
+}
+
+// newEksBlowfish runs the expensive key schedule, which mixes the salt and key into the initial subkeys and then
+// alternately re-keys with the key and the salt 2^cost times
+func newEksBlowfish(salt []byte, key []byte, cost int) *blowfish {
+	cipher := &blowfish{p: blowfishP, s: blowfishS}
+	cipher.expandKey(key, salt)
+
+	rounds := uint64(1) << cost
+	for i := uint64(0); i < rounds; i++ {
+		cipher.expandKey(key, nil)
+		cipher.expandKey(salt, nil)
+	}
+
+	return cipher
+}
+
+// expandKey mixes the key into the P-array, then replaces the subkeys by repeatedly encrypting a block that has the
+// salt mixed into it, if there is one
+func (b *blowfish) expandKey(key []byte, salt []byte) {
+	keyPosition := 0
+	for i := range b.p {
+		b.p[i] ^= streamWord(key, &keyPosition)
+	}
+
+	saltPosition := 0
+	var l, r uint32
+	next := func() (uint32, uint32) {
+		if salt != nil {
+			l ^= streamWord(salt, &saltPosition)
+			r ^= streamWord(salt, &saltPosition)
+		}
+
+		l, r = b.encrypt(l, r)
+		return l, r
+	}
+
+	for i := 0; i < len(b.p); i += 2 {
+		b.p[i], b.p[i+1] = next()
+	}
+
+	for i := range b.s {
+		for j := 0; j < len(b.s[i]); j += 2 {
+			b.s[i][j], b.s[i][j+1] = next()
+		}
+	}
+}
+
+// encrypt encrypts a single 64 bit block held as two halves
+func (b *blowfish) encrypt(l uint32, r uint32) (uint32, uint32) {
+	l ^= b.p[0]
+	for i := 1; i < 17; i += 2 {
+		r ^= b.f(l) ^ b.p[i]
+		l ^= b.f(r) ^ b.p[i+1]
+	}
+	r ^= b.p[17]
+
+	return r, l
+}
+
+// f is Blowfish's round function
+func (b *blowfish) f(x uint32) uint32 {
+	return ((b.s[0][x>>24] + b.s[1][x>>16&0xff]) ^ b.s[2][x>>8&0xff]) + b.s[3][x&0xff]
+}
+
+// streamWord reads the next 4 bytes of data as a big-endian word, starting again from the beginning once it runs out
+func streamWord(data []byte, position *int) uint32 {
+	var word uint32
+	for i := 0; i < 4; i++ {
+		word = word<<8 | uint32(data[*position])
+		*position = (*position + 1) % len(data)
+	}
+
+	return word
+}
@@ -0,0 +1,68 @@
+package webserver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBcryptHash_Verify(t *testing.T) {
+	var tests = []struct {
+		name     string
+		password string
+		hash     string
+	}{
+		{"Reference vector", "U*U", "$2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"},
+		{"Empty password", "", "$2a$04$abcdefghijklmnopqrstuubyCG3zY1GIXMyxfivm.ClDiInHzxjiq"},
+		{"2y version", "correct horse", "$2y$04$XXXXXXXXXXXXXXXXXXXXXOBOPOzALzHJkYBCljussDsZBRhtZ8NzC"},
+		{"Long password", strings.Repeat("x", 80), "$2b$04$0123456789abcdefghijkeQTbmm.RcNPT0JaHsD9KFomKrKSIRYFm"},
+	}
+
+	for _, test := range tests {
+		hash, err := parseBcryptHash(test.hash)
+		if err != nil {
+			t.Errorf("%s: received an error parsing the hash: %v", test.name, err)
+			continue
+		}
+
+		if !hash.verify(test.password) {
+			t.Errorf("%s: expected the password to match", test.name)
+		}
+
+		if hash.verify("!" + test.password) {
+			t.Errorf("%s: expected a different password not to match", test.name)
+		}
+	}
+}
+
+func TestBcryptHash_LongPasswordIsTruncated(t *testing.T) {
+	hash, err := parseBcryptHash("$2b$04$0123456789abcdefghijkeQTbmm.RcNPT0JaHsD9KFomKrKSIRYFm")
+	if err != nil {
+		t.Fatalf("Received an error parsing the hash: %v", err)
+	}
+
+	// Only the first 72 bytes of a password are used
+	if !hash.verify(strings.Repeat("x", 72) + "ignored") {
+		t.Fatalf("Expected bytes past the 72nd to be ignored")
+	}
+}
+
+func TestParseBcryptHash_Invalid(t *testing.T) {
+	var tests = []struct {
+		name string
+		hash string
+	}{
+		{"Unknown version", "$2x$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"},
+		{"Cost too low", "$2b$03$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"},
+		{"Cost not two digits", "$2b$5$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"},
+		{"Too short", "$2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOe"},
+		{"Invalid characters", "$2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyO+W"},
+		{"Missing prefix", "2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"},
+	}
+
+	for _, test := range tests {
+		if _, err := parseBcryptHash(test.hash); !errors.Is(err, ErrInvalidBcryptHash) {
+			t.Errorf("%s: expected ErrInvalidBcryptHash but received %v", test.name, err)
+		}
+	}
+}
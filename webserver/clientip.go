@@ -0,0 +1,185 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ErrInvalidCIDR is returned when an address range can't be parsed
+var ErrInvalidCIDR = errors.New("the address range is in the incorrect format")
+
+// ParseCIDRs parses address ranges in CIDR notation, e.g. `10.0.0.0/8` or `2001:db8::/32`. Single addresses are also
+// accepted and match only themselves.
+func ParseCIDRs(ranges ...string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(ranges))
+
+	for _, value := range ranges {
+		value = strings.TrimSpace(value)
+
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidCIDR, value)
+			}
+
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCIDR, value)
+		}
+
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+// ForwardedHeader is the header the trusted proxies put the addresses they forward requests for in
+type ForwardedHeader int
+
+const (
+	// ForwardedHeaderXForwardedFor is the de facto X-Forwarded-For header, e.g. `X-Forwarded-For: 192.0.2.60, 10.0.0.1`
+	ForwardedHeaderXForwardedFor ForwardedHeader = iota
+	// ForwardedHeaderForwarded is the standard Forwarded header from RFC 7239, e.g. `Forwarded: for=192.0.2.60`
+	ForwardedHeaderForwarded
+)
+
+// name returns the name of the header
+func (h ForwardedHeader) name() string {
+	if h == ForwardedHeaderForwarded {
+		return "Forwarded"
+	}
+
+	return "X-Forwarded-For"
+}
+
+// SetTrustedProxies sets the address ranges of the reverse proxies in front of the web server and the header they
+// forward the client's address in. When a request arrives from one of them, the client's IP address is taken from that
+// header instead of the connection. The other forwarding header is never read, as the proxies may pass it on from the
+// client untouched, and headers from other addresses are ignored, as any client can send them. It must be called
+// before the web server starts serving.
+func (w *WebServer) SetTrustedProxies(proxies []netip.Prefix, header ForwardedHeader) {
+	w.trustedProxies = proxies
+	w.forwardedHeader = header
+}
+
+// resolveClientIP returns the IP address of the client that sent the request. The forwarding headers are read from
+// right to left, as each proxy appends the address it received the request from, and the first address that isn't a
+// trusted proxy is the client. If every address is trusted, the leftmost one is used.
+func resolveClientIP(request Request, trustedProxies []netip.Prefix, header ForwardedHeader) string {
+	peer := remoteHost(request.RemoteAddr())
+	if len(trustedProxies) == 0 {
+		return peer
+	}
+
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !prefixesContain(trustedProxies, addr) {
+		return peer
+	}
+
+	hops, valid := forwardedFor(request.Headers(), header)
+	if !valid {
+		return addr.String()
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, valid := parseForwardedAddr(hops[i])
+		if !valid {
+			// Hidden or malformed addresses can't be checked, so the nearest trusted proxy is all that is known
+			return addr.String()
+		}
+
+		addr = hop
+		if !prefixesContain(trustedProxies, addr) {
+			break
+		}
+	}
+
+	return addr.String()
+}
+
+// forwardedFor returns the addresses in the forwarding header, in the order they were added. If the header was sent
+// more than once with different cases, the order of the addresses is lost, so it returns false and none of them are
+// believed.
+func forwardedFor(headers RequestHeaders, header ForwardedHeader) ([]string, bool) {
+	values := make([]string, 0, 1)
+	for name, value := range headers.GetAsMap() {
+		if strings.EqualFold(name, header.name()) {
+			values = append(values, value)
+		}
+	}
+
+	hops := make([]string, 0)
+	if len(values) == 0 {
+		return hops, true
+	} else if len(values) > 1 {
+		return nil, false
+	}
+
+	if header == ForwardedHeaderForwarded {
+		for _, element := range strings.Split(values[0], ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(name, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+
+		return hops, true
+	}
+
+	for _, hop := range strings.Split(values[0], ",") {
+		hops = append(hops, strings.TrimSpace(hop))
+	}
+
+	return hops, true
+}
+
+// parseForwardedAddr parses an address from a forwarding header, which may have a port and IPv6 addresses may be in
+// brackets, e.g. `[2001:db8::17]:4711`
+func parseForwardedAddr(value string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
+
+// prefixesContain returns whether any of the ranges contains the address
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteHost strips the port from a remote address
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap().String()
+	}
+
+	return host
+}
@@ -0,0 +1,96 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	prefixes, err := ParseCIDRs("10.1.2.3/8", "192.0.2.7", "2001:db8::/32", "::ffff:192.0.2.8")
+	if err != nil {
+		t.Fatalf("Received an error parsing valid ranges: %v", err)
+	}
+
+	var expected = []string{"10.0.0.0/8", "192.0.2.7/32", "2001:db8::/32", "192.0.2.8/32"}
+	for i, prefix := range prefixes {
+		if prefix.String() != expected[i] {
+			t.Errorf("Expected %q but received %q", expected[i], prefix)
+		}
+	}
+
+	for _, invalid := range []string{"10.0.0.0/33", "example.com", ""} {
+		if _, err := ParseCIDRs(invalid); !errors.Is(err, ErrInvalidCIDR) {
+			t.Errorf("Expected ErrInvalidCIDR for %q but received %v", invalid, err)
+		}
+	}
+}
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, err := ParseCIDRs("10.0.0.0/8", "2001:db8:ffff::/48")
+	if err != nil {
+		t.Fatalf("Received an error parsing the trusted proxies: %v", err)
+	}
+
+	const xff, forwarded = ForwardedHeaderXForwardedFor, ForwardedHeaderForwarded
+
+	var tests = []struct {
+		name       string
+		remoteAddr string
+		header     ForwardedHeader
+		headers    map[string]string
+		expected   string
+	}{
+		{"Direct client", "192.0.2.1:5000", xff, nil, "192.0.2.1"},
+		{"Untrusted peer's headers are ignored", "192.0.2.1:5000", xff, map[string]string{"X-Forwarded-For": "198.51.100.1"}, "192.0.2.1"},
+		{"Trusted proxy", "10.0.0.1:5000", xff, map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"Spoofed leftmost entry", "10.0.0.1:5000", xff, map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"Every hop trusted", "10.0.0.1:5000", xff, map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"Trusted proxy without header", "10.0.0.1:5000", xff, nil, "10.0.0.1"},
+		{"Malformed hop", "10.0.0.1:5000", xff, map[string]string{"X-Forwarded-For": "198.51.100.1, garbage"}, "10.0.0.1"},
+		{"Hop with port", "10.0.0.1:5000", xff, map[string]string{"X-Forwarded-For": "198.51.100.1:4711"}, "198.51.100.1"},
+		{"Case-variant duplicates", "10.0.0.1:5000", xff, map[string]string{"X-Forwarded-For": "198.51.100.1", "x-forwarded-for": "203.0.113.9"}, "10.0.0.1"},
+		{"Forwarded header", "10.0.0.1:5000", forwarded, map[string]string{"Forwarded": `for=198.51.100.1;proto=https, for="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"Forwarded ignored when not configured", "10.0.0.1:5000", xff, map[string]string{"Forwarded": "for=203.0.113.9", "X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"X-Forwarded-For ignored when not configured", "10.0.0.1:5000", forwarded, map[string]string{"X-Forwarded-For": "203.0.113.9"}, "10.0.0.1"},
+		{"Obfuscated Forwarded hop", "10.0.0.1:5000", forwarded, map[string]string{"Forwarded": "for=_hidden"}, "10.0.0.1"},
+		{"IPv6 proxy", "[2001:db8:ffff::1]:5000", xff, map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"IPv4-mapped peer", "[::ffff:10.0.0.1]:5000", xff, map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newTestRequest(MethodGet, "/", tt.headers)
+			request.remoteAddr = tt.remoteAddr
+
+			if clientIP := resolveClientIP(request, trusted, tt.header); clientIP != tt.expected {
+				t.Errorf("Expected %q but received %q", tt.expected, clientIP)
+			}
+		})
+	}
+}
+
+func TestRequestClientIP(t *testing.T) {
+	ws := NewWebServer()
+	trusted, _ := ParseCIDRs("127.0.0.1")
+	ws.SetTrustedProxies(trusted, ForwardedHeaderXForwardedFor)
+
+	var remoteAddr, clientIP string
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/"), func(request Request) Response {
+		remoteAddr, clientIP = request.RemoteAddr(), request.ClientIP()
+		return OkResponse()
+	}))
+
+	addr, _ := startTestServer(t, &ws)
+	defer ws.Shutdown(context.Background())
+
+	sendTestRequest(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Forwarded-For: 198.51.100.1\r\n\r\n")
+
+	if host := remoteHost(remoteAddr); host != "127.0.0.1" {
+		t.Errorf("Expected the remote address to be the connection's but received %q", remoteAddr)
+	}
+
+	if clientIP != "198.51.100.1" {
+		t.Errorf("Expected the client IP to come from the trusted proxy's header but received %q", clientIP)
+	}
+}
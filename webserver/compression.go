@@ -0,0 +1,146 @@
+package webserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// CompressionOptions configures the compression middleware
+type CompressionOptions struct {
+	// Encodings lists the content codings to compress with in order of preference. `gzip` and `deflate` are supported.
+	Encodings []string
+	// Level is the compression level passed to the compressor, e.g. gzip.BestSpeed or gzip.DefaultCompression
+	Level int
+	// MinSize is the smallest body, in bytes, that will be compressed. Smaller bodies are sent as they are.
+	MinSize int
+	// ContentTypes lists the media types that are worth compressing. Entries ending in `/` match a whole type, e.g.
+	// `text/` matches `text/html` and `text/css`.
+	ContentTypes []string
+}
+
+// DefaultCompressionOptions returns sensible options for compressing text based responses
+func DefaultCompressionOptions() CompressionOptions {
+	return CompressionOptions{
+		Encodings: []string{"gzip", "deflate"},
+		Level:     gzip.DefaultCompression,
+		MinSize:   1024,
+		ContentTypes: []string{
+			"text/",
+			"application/json",
+			"application/problem+json",
+			"application/javascript",
+			"application/xml",
+			"application/xhtml+xml",
+			"image/svg+xml",
+		},
+	}
+}
+
+// NewCompressionMiddleware creates a middleware that compresses response bodies with the best content coding the
+// client accepts
+func NewCompressionMiddleware(options CompressionOptions) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(request Request) Response {
+			response := next(request)
+
+			if !isCompressible(response, options) {
+				return response
+			}
+
+			// Caches need to know the body depends on Accept-Encoding even when we don't compress this one
+			addVary(response.Headers(), "Accept-Encoding")
+
+			acceptEncoding, _ := request.Headers().GetHeader("Accept-Encoding")
+			encoding := negotiateEncoding(acceptEncoding, options.Encodings)
+			if encoding == "" {
+				return response
+			}
+
+			compressed, err := compress(response.Body(), encoding, options.Level)
+			if err != nil || len(compressed) >= len(response.Body()) {
+				return response
+			}
+
+			response.SetBody(compressed)
+			response.Headers().SetHeader("Content-Encoding", encoding)
+			response.Headers().SetHeader("Content-Length", strconv.Itoa(len(compressed)))
+
+			// The compressed body is no longer byte-for-byte the representation a strong ETag promised
+			if etag, err := response.Headers().GetHeader("ETag"); err == nil && !strings.HasPrefix(etag, "W/") {
+				response.Headers().SetHeader("ETag", "W/"+etag)
+			}
+
+			return response
+		}
+	}
+}
+
+// isCompressible returns whether a response is one the compression middleware should consider compressing
+func isCompressible(response Response, options CompressionOptions) bool {
+	statusCode := response.StatusCode()
+	if statusCode < 200 || statusCode == 204 || statusCode == 206 || statusCode == 304 {
+		return false
+	}
+
+	headers := response.Headers()
+	if headers.HasHeader("Content-Range") {
+		return false
+	}
+
+	if contentEncoding, err := headers.GetHeader("Content-Encoding"); err == nil && !strings.EqualFold(contentEncoding, "identity") {
+		return false
+	}
+
+	if len(response.Body()) < options.MinSize {
+		return false
+	}
+
+	contentType, err := headers.GetHeader("Content-Type")
+	if err != nil {
+		return false
+	}
+
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, compressible := range options.ContentTypes {
+		if mediaType == compressible || (strings.HasSuffix(compressible, "/") && strings.HasPrefix(mediaType, compressible)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compress compresses the body with the given content coding
+func compress(body []byte, encoding string, level int) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	var writer io.WriteCloser
+	var err error
+
+	switch encoding {
+	case "gzip":
+		writer, err = gzip.NewWriterLevel(&buffer, level)
+	case "deflate":
+		// The HTTP `deflate` coding is the zlib format rather than a raw deflate stream
+		writer, err = zlib.NewWriterLevel(&buffer, level)
+	default:
+		return nil, ErrUnsupportedEncoding
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
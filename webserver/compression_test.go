@@ -0,0 +1,127 @@
+package webserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// compressionTestHandler returns a handler that responds with the given status code, content type and body
+func compressionTestHandler(statusCode int, contentType string, body []byte) HandlerFunc {
+	return func(request Request) Response {
+		response := NewResponseWithBody(statusCode, body)
+		if contentType != "" {
+			response.Headers().SetHeader("Content-Type", contentType)
+		}
+		response.Headers().SetHeader("Content-Length", strconv.Itoa(len(body)))
+		return response
+	}
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	body := []byte(strings.Repeat("{\"name\":\"John Doe\",\"age\":30}", 100))
+	middleware := NewCompressionMiddleware(DefaultCompressionOptions())
+	handler := middleware(compressionTestHandler(200, "application/json; charset=utf-8", body))
+
+	var tests = []struct {
+		acceptEncoding string
+		encoding       string
+		decompress     func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip", "gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"deflate, gzip;q=0.5", "deflate", func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }},
+		{"br", "", nil},
+		{"", "", nil},
+	}
+
+	for _, test := range tests {
+		response := handler(newTestRequest(MethodGet, "/api/person", map[string]string{"Accept-Encoding": test.acceptEncoding}))
+
+		if vary, _ := response.Headers().GetHeader("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q expected Vary: Accept-Encoding, got %q", test.acceptEncoding, vary)
+		}
+
+		contentEncoding, _ := response.Headers().GetHeader("Content-Encoding")
+		if contentEncoding != test.encoding {
+			t.Errorf("Accept-Encoding %q expected Content-Encoding %q, got %q", test.acceptEncoding, test.encoding, contentEncoding)
+			continue
+		}
+
+		contentLength, _ := response.Headers().GetHeader("Content-Length")
+		if contentLength != strconv.Itoa(len(response.Body())) {
+			t.Errorf("Accept-Encoding %q expected Content-Length %d, got %s", test.acceptEncoding, len(response.Body()), contentLength)
+		}
+
+		if test.decompress == nil {
+			if !bytes.Equal(response.Body(), body) {
+				t.Errorf("Accept-Encoding %q expected the body to be unchanged", test.acceptEncoding)
+			}
+			continue
+		}
+
+		reader, err := test.decompress(bytes.NewReader(response.Body()))
+		if err != nil {
+			t.Errorf("Accept-Encoding %q could not decompress the body: %v", test.acceptEncoding, err)
+			continue
+		}
+
+		decompressed, err := io.ReadAll(reader)
+		if err != nil || !bytes.Equal(decompressed, body) {
+			t.Errorf("Accept-Encoding %q expected the body to decompress to the original (error %v)", test.acceptEncoding, err)
+		}
+	}
+}
+
+func TestCompressionMiddlewareSkipsResponses(t *testing.T) {
+	body := []byte(strings.Repeat("Hello World! ", 200))
+
+	var tests = []struct {
+		name    string
+		handler HandlerFunc
+	}{
+		{"small body", compressionTestHandler(200, "text/plain", []byte("Hello World!"))},
+		{"incompressible type", compressionTestHandler(200, "image/png", body)},
+		{"missing type", compressionTestHandler(200, "", body)},
+		{"no content", compressionTestHandler(204, "text/plain", body)},
+		{"not modified", compressionTestHandler(304, "text/plain", body)},
+		{"partial content", compressionTestHandler(206, "text/plain", body)},
+		{"already encoded", func(request Request) Response {
+			response := compressionTestHandler(200, "text/plain", body)(request)
+			response.Headers().SetHeader("Content-Encoding", "br")
+			return response
+		}},
+		{"range", func(request Request) Response {
+			response := compressionTestHandler(200, "text/plain", body)(request)
+			response.Headers().SetHeader("Content-Range", "bytes 0-10/100")
+			return response
+		}},
+	}
+
+	middleware := NewCompressionMiddleware(DefaultCompressionOptions())
+	for _, test := range tests {
+		response := middleware(test.handler)(newTestRequest(MethodGet, "/", map[string]string{"Accept-Encoding": "gzip"}))
+
+		contentEncoding, _ := response.Headers().GetHeader("Content-Encoding")
+		if contentEncoding == "gzip" {
+			t.Errorf("Expected the %s response not to be compressed", test.name)
+		}
+	}
+}
+
+func TestCompressionMiddlewareWeakensETag(t *testing.T) {
+	body := []byte(strings.Repeat("Hello World! ", 200))
+	handler := NewCompressionMiddleware(DefaultCompressionOptions())(func(request Request) Response {
+		response := compressionTestHandler(200, "text/html", body)(request)
+		response.Headers().SetHeader("ETag", "\"abc\"")
+		return response
+	})
+
+	response := handler(newTestRequest(MethodGet, "/", map[string]string{"Accept-Encoding": "gzip"}))
+	if etag, _ := response.Headers().GetHeader("ETag"); etag != "W/\"abc\"" {
+		t.Fatalf("Expected the ETag to be weakened to W/\"abc\" but received %s", etag)
+	}
+}
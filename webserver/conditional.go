@@ -0,0 +1,146 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrRangeNotSatisfiable is returned when a Range header doesn't overlap with the representation at all
+var ErrRangeNotSatisfiable = errors.New("the requested range cannot be satisfied")
+
+// byteRange is an inclusive range of bytes within a representation
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+// length returns the number of bytes in the range
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+// contentRange returns the value of the Content-Range header for the range
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+// newETag builds a strong ETag from a file's size, modification time and the content coding it is served with, so
+// that each encoding of the same file gets its own ETag
+func newETag(size int64, modTime time.Time, encoding string) string {
+	if encoding == "" {
+		return fmt.Sprintf("\"%x-%x\"", modTime.UnixNano(), size)
+	}
+
+	return fmt.Sprintf("\"%x-%x-%s\"", modTime.UnixNano(), size, encoding)
+}
+
+// etagMatches returns whether any of the ETags in an If-None-Match header match the given ETag using the weak
+// comparison function
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseRange parses a Range header for a representation of the given size. Only a single byte range is supported; the
+// returned bool is false when the header should be ignored and the full representation served instead.
+func parseRange(header string, size int64) (byteRange, bool, error) {
+	rangeSpec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(rangeSpec, ",") {
+		return byteRange{}, false, nil
+	}
+
+	startStr, endStr, found := strings.Cut(strings.TrimSpace(rangeSpec), "-")
+	if !found {
+		return byteRange{}, false, nil
+	}
+
+	// A suffix range like `-500` asks for the last 500 bytes
+	if startStr == "" {
+		suffixLength, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffixLength < 0 {
+			return byteRange{}, false, nil
+		}
+
+		if suffixLength == 0 || size == 0 {
+			return byteRange{}, false, ErrRangeNotSatisfiable
+		}
+
+		return byteRange{start: max(size-suffixLength, 0), end: size - 1}, true, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, false, nil
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return byteRange{}, false, nil
+		}
+	}
+
+	if start >= size {
+		return byteRange{}, false, ErrRangeNotSatisfiable
+	}
+
+	return byteRange{start: start, end: min(end, size-1)}, true, nil
+}
+
+// newConditionalResponse builds a response for a representation identified by the ETag, answering If-None-Match
+// requests with a 304 and Range requests with a 206 or 416
+func newConditionalResponse(request Request, contents []byte, etag string) Response {
+	headers := request.Headers()
+
+	if ifNoneMatch, err := headers.GetHeader("If-None-Match"); err == nil && etagMatches(ifNoneMatch, etag) {
+		response := NewResponse(304)
+		response.Headers().SetHeader("ETag", etag)
+		return response
+	}
+
+	size := int64(len(contents))
+
+	rangeHeader, err := headers.GetHeader("Range")
+	if ifRange, ifRangeErr := headers.GetHeader("If-Range"); ifRangeErr == nil && ifRange != etag {
+		// The client's copy is stale, so it needs the full representation rather than a range
+		err = ErrHeaderNotFound
+	}
+
+	if err == nil {
+		byteRange, ok, err := parseRange(rangeHeader, size)
+		if errors.Is(err, ErrRangeNotSatisfiable) {
+			response := NewResponse(416)
+			response.Headers().SetHeader("Content-Range", fmt.Sprintf("bytes */%d", size))
+			response.Headers().SetHeader("ETag", etag)
+			return response
+		}
+
+		if ok {
+			response := NewResponseWithBody(206, contents[byteRange.start:byteRange.end+1])
+			response.Headers().SetHeader("Content-Range", byteRange.contentRange(size))
+			response.Headers().SetHeader("Content-Length", strconv.FormatInt(byteRange.length(), 10))
+			response.Headers().SetHeader("Accept-Ranges", "bytes")
+			response.Headers().SetHeader("ETag", etag)
+			return response
+		}
+	}
+
+	response := OkResponseWithBody(contents)
+	response.Headers().SetHeader("Content-Length", strconv.Itoa(len(contents)))
+	response.Headers().SetHeader("Accept-Ranges", "bytes")
+	response.Headers().SetHeader("ETag", etag)
+	return response
+}
@@ -0,0 +1,90 @@
+package webserver
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	var tests = []struct {
+		header   string
+		expected byteRange
+		ok       bool
+		err      error
+	}{
+		{"bytes=0-4", byteRange{0, 4}, true, nil},
+		{"bytes=5-", byteRange{5, 9}, true, nil},
+		{"bytes=-3", byteRange{7, 9}, true, nil},
+		{"bytes=-30", byteRange{0, 9}, true, nil},
+		{"bytes=8-100", byteRange{8, 9}, true, nil},
+		{"bytes=10-12", byteRange{}, false, ErrRangeNotSatisfiable},
+		{"bytes=-0", byteRange{}, false, ErrRangeNotSatisfiable},
+		{"bytes=4-2", byteRange{}, false, nil},
+		{"bytes=0-1,4-5", byteRange{}, false, nil},
+		{"items=0-4", byteRange{}, false, nil},
+		{"bytes=a-b", byteRange{}, false, nil},
+	}
+
+	for _, test := range tests {
+		byteRange, ok, err := parseRange(test.header, 10)
+		if !errors.Is(err, test.err) || ok != test.ok || byteRange != test.expected {
+			t.Errorf("parseRange(%q) expected (%v, %v, %v), got (%v, %v, %v)", test.header, test.expected, test.ok, test.err, byteRange, ok, err)
+		}
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	var tests = []struct {
+		ifNoneMatch string
+		expected    bool
+	}{
+		{"\"abc\"", true},
+		{"W/\"abc\"", true},
+		{"\"xyz\", \"abc\"", true},
+		{"*", true},
+		{"\"xyz\"", false},
+		{"abc", false},
+	}
+
+	for _, test := range tests {
+		if etagMatches(test.ifNoneMatch, "\"abc\"") != test.expected {
+			t.Errorf("etagMatches(%q) expected %v", test.ifNoneMatch, test.expected)
+		}
+	}
+}
+
+func TestNewConditionalResponse(t *testing.T) {
+	contents := []byte("Hello World!")
+	etag := "\"abc\""
+
+	var tests = []struct {
+		headers      map[string]string
+		statusCode   int
+		body         string
+		contentRange string
+	}{
+		{nil, 200, "Hello World!", ""},
+		{map[string]string{"If-None-Match": etag}, 304, "", ""},
+		{map[string]string{"If-None-Match": "\"xyz\""}, 200, "Hello World!", ""},
+		{map[string]string{"Range": "bytes=6-10"}, 206, "World", "bytes 6-10/12"},
+		{map[string]string{"Range": "bytes=6-10", "If-Range": etag}, 206, "World", "bytes 6-10/12"},
+		{map[string]string{"Range": "bytes=6-10", "If-Range": "\"xyz\""}, 200, "Hello World!", ""},
+		{map[string]string{"Range": "bytes=20-"}, 416, "", "bytes */12"},
+	}
+
+	for _, test := range tests {
+		response := newConditionalResponse(newTestRequest(MethodGet, "/", test.headers), contents, etag)
+		if response.StatusCode() != test.statusCode || string(response.Body()) != test.body {
+			t.Errorf("Headers %v expected %d %q, got %d %q", test.headers, test.statusCode, test.body, response.StatusCode(), string(response.Body()))
+		}
+
+		contentRange, _ := response.Headers().GetHeader("Content-Range")
+		if contentRange != test.contentRange {
+			t.Errorf("Headers %v expected Content-Range %q, got %q", test.headers, test.contentRange, contentRange)
+		}
+
+		if responseETag, _ := response.Headers().GetHeader("ETag"); responseETag != etag {
+			t.Errorf("Headers %v expected ETag %s, got %s", test.headers, etag, responseETag)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package webserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClientDisconnected is the cause of a request's context being cancelled because the client closed the connection
+var ErrClientDisconnected = errors.New("the client closed the connection")
+
+// connection wraps the net.Conn for a request so the server knows whether anything has been written to the client yet
+type connection struct {
+	net.Conn
+	// id identifies the connection in log records
+	id uint64
+	// logger includes the connection's ID and remote address with every record
+	logger *slog.Logger
+	// reader buffers everything read from the connection, so bytes read ahead of the request aren't lost
+	reader  *bufio.Reader
+	written atomic.Bool
+	// hijacked is set once a handler has taken the connection, so the server must no longer close it
+	hijacked atomic.Bool
+	// readTimedOut is set once a read fails because the read deadline passed
+	readTimedOut atomic.Bool
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+}
+
+// newConnection wraps a net.Conn accepted by the server, giving it a logger that identifies the connection
+func newConnection(conn net.Conn, id uint64, logger *slog.Logger) *connection {
+	c := &connection{
+		Conn:   conn,
+		id:     id,
+		logger: logger.With("connection_id", id, "remote_addr", conn.RemoteAddr().String()),
+	}
+	c.reader = bufio.NewReader(c)
+
+	return c
+}
+
+// Read reads from the underlying connection, counting the bytes received
+func (c *connection) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.bytesRead.Add(int64(n))
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		c.readTimedOut.Store(true)
+	}
+
+	return n, err
+}
+
+// Write writes to the underlying connection, recording that the response has started and counting the bytes sent
+func (c *connection) Write(p []byte) (int, error) {
+	c.written.Store(true)
+	n, err := c.Conn.Write(p)
+	c.bytesWritten.Add(int64(n))
+	return n, err
+}
+
+// hasWritten returns whether any part of the response has been sent to the client
+func (c *connection) hasWritten() bool {
+	return c.written.Load()
+}
+
+// abort closes the connection without completing the response. For TCP connections the close is sent as a reset, so
+// the client can tell the response was cut short rather than mistaking it for a complete one.
+func (c *connection) abort() error {
+	if tcpConn, ok := c.Conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetLinger(0)
+	}
+
+	return c.Conn.Close()
+}
+
+// watchForDisconnect cancels the context if the client closes the connection while its request is being handled. Any
+// bytes the client sends in the meantime are kept in the reader, and watching stops once they arrive. The returned
+// function stops watching and must be called before anything else reads from the connection.
+func (c *connection) watchForDisconnect(cancel context.CancelCauseFunc) (stop func()) {
+	stopped := atomic.Bool{}
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		if _, err := c.reader.Peek(1); err != nil && !stopped.Load() {
+			cancel(ErrClientDisconnected)
+		}
+	}()
+
+	return func() {
+		stopped.Store(true)
+
+		// A deadline in the past unblocks the pending read
+		_ = c.SetReadDeadline(time.Unix(1, 0))
+		<-done
+		_ = c.SetReadDeadline(time.Time{})
+	}
+}
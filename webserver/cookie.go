@@ -0,0 +1,143 @@
+package webserver
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrCookieNotFound is returned when a request doesn't carry the cookie that was asked for
+var ErrCookieNotFound = errors.New("the specified cookie could not be found")
+
+// cookieTimeFormat is the date format used by the Expires attribute
+const cookieTimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// SameSite controls whether browsers send a cookie with cross-site requests
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out, so the browser's default applies
+	SameSiteDefault SameSite = iota
+	// SameSiteLax sends the cookie with top-level navigations from other sites, but not with their subrequests
+	SameSiteLax
+	// SameSiteStrict only sends the cookie with requests that come from the same site
+	SameSiteStrict
+	// SameSiteNone sends the cookie with every request. Browsers require such cookies to be Secure.
+	SameSiteNone
+)
+
+// Cookie is an HTTP cookie, either sent by the client or set by a response
+type Cookie struct {
+	Name  string
+	Value string
+
+	// The attributes below are only used when setting a cookie
+	Path   string
+	Domain string
+	// Expires is when the cookie expires. The zero time makes it a session cookie.
+	Expires time.Time
+	// MaxAge is how many seconds the cookie lasts for. Zero leaves the attribute out, and a negative value deletes the
+	// cookie straight away.
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+}
+
+// String returns the cookie in the format of a Set-Cookie header, or an empty string if its name isn't valid. Bytes
+// that aren't allowed in cookie values are dropped from the value.
+func (c Cookie) String() string {
+	if !isCookieName(c.Name) {
+		return ""
+	}
+
+	var builder strings.Builder
+	builder.WriteString(c.Name)
+	builder.WriteString("=")
+	builder.WriteString(sanitizeCookieValue(c.Value))
+
+	if c.Path != "" {
+		builder.WriteString("; Path=" + sanitizeCookieValue(c.Path))
+	}
+
+	if c.Domain != "" {
+		builder.WriteString("; Domain=" + sanitizeCookieValue(c.Domain))
+	}
+
+	if !c.Expires.IsZero() {
+		builder.WriteString("; Expires=" + c.Expires.UTC().Format(cookieTimeFormat))
+	}
+
+	if c.MaxAge > 0 {
+		builder.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		builder.WriteString("; Max-Age=0")
+	}
+
+	if c.HttpOnly {
+		builder.WriteString("; HttpOnly")
+	}
+
+	if c.Secure {
+		builder.WriteString("; Secure")
+	}
+
+	switch c.SameSite {
+	case SameSiteLax:
+		builder.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		builder.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		builder.WriteString("; SameSite=None")
+	}
+
+	return builder.String()
+}
+
+// parseCookies parses the name-value pairs of a Cookie header. Pairs with invalid names are skipped.
+func parseCookies(header string) []Cookie {
+	cookies := make([]Cookie, 0)
+
+	for _, pair := range strings.Split(header, ";") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !isCookieName(name) {
+			continue
+		}
+
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+
+		cookies = append(cookies, Cookie{Name: name, Value: value})
+	}
+
+	return cookies
+}
+
+// isCookieName returns whether the name is a valid token
+func isCookieName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?={}`, c) >= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sanitizeCookieValue drops the bytes that can't appear in a cookie value, such as spaces, quotes and semicolons
+func sanitizeCookieValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r >= 0x7f || r == '"' || r == ',' || r == ';' || r == '\\' {
+			return -1
+		}
+
+		return r
+	}, value)
+}
@@ -0,0 +1,106 @@
+package webserver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookie_String(t *testing.T) {
+	var tests = []struct {
+		name     string
+		cookie   Cookie
+		expected string
+	}{
+		{"Name and value", Cookie{Name: "theme", Value: "dark"}, "theme=dark"},
+		{
+			name: "All attributes",
+			cookie: Cookie{
+				Name:     "session",
+				Value:    "abc",
+				Path:     "/",
+				Domain:   "example.com",
+				Expires:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				MaxAge:   3600,
+				Secure:   true,
+				HttpOnly: true,
+				SameSite: SameSiteStrict,
+			},
+			expected: "session=abc; Path=/; Domain=example.com; Expires=Tue, 02 Jan 2024 03:04:05 GMT; Max-Age=3600; HttpOnly; Secure; SameSite=Strict",
+		},
+		{"Delete", Cookie{Name: "session", MaxAge: -1}, "session=; Max-Age=0"},
+		{"Invalid value bytes", Cookie{Name: "note", Value: "a b;c\"d"}, "note=abcd"},
+		{"Invalid name", Cookie{Name: "bad name", Value: "x"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cookie.String() != tt.expected {
+				t.Errorf("Expected %q but received %q", tt.expected, tt.cookie.String())
+			}
+		})
+	}
+}
+
+func TestRequest_Cookie(t *testing.T) {
+	request := newTestRequest(MethodGet, "/", map[string]string{"Cookie": `theme=dark; session="abc"; bad name=x; empty=`})
+
+	var tests = []struct {
+		name     string
+		expected string
+	}{
+		{"theme", "dark"},
+		{"session", "abc"},
+		{"empty", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookie, err := request.Cookie(tt.name)
+			if err != nil || cookie.Value != tt.expected {
+				t.Errorf("Expected %q but received %q, %v", tt.expected, cookie.Value, err)
+			}
+		})
+	}
+
+	if _, err := request.Cookie("missing"); !errors.Is(err, ErrCookieNotFound) {
+		t.Errorf("Expected ErrCookieNotFound but received %v", err)
+	}
+
+	if len(request.Cookies()) != 3 {
+		t.Errorf("Expected 3 cookies but received %d", len(request.Cookies()))
+	}
+}
+
+func TestResponse_SetCookie(t *testing.T) {
+	response := OkResponse()
+	response.SetCookie(Cookie{Name: "a", Value: "1", Path: "/"})
+	response.SetCookie(Cookie{Name: "b", Value: "2"})
+	response.SetCookie(Cookie{Name: "a", Value: "3", Path: "/"})
+	response.SetCookie(Cookie{Name: "a", Value: "4", Path: "/admin"})
+
+	var values []string
+	for _, cookie := range response.Cookies() {
+		values = append(values, cookie.Name+"="+cookie.Value)
+	}
+
+	if strings.Join(values, ", ") != "a=3, b=2, a=4" {
+		t.Fatalf("Expected cookies with the same name and path to be replaced but received %v", values)
+	}
+}
+
+func TestWebServer_WritesEachCookie(t *testing.T) {
+	ws := NewWebServer()
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/"), func(request Request) Response {
+		response := OkResponse()
+		response.SetCookie(Cookie{Name: "a", Value: "1"})
+		response.SetCookie(Cookie{Name: "b", Value: "2", HttpOnly: true})
+		return response
+	}))
+
+	rawResponse := serveTestRequest(t, &ws, "GET / HTTP/1.1\r\n\r\n")
+	if !strings.Contains(rawResponse, "Set-Cookie: a=1\r\n") || !strings.Contains(rawResponse, "Set-Cookie: b=2; HttpOnly\r\n") {
+		t.Fatalf("Expected a Set-Cookie header for each cookie but received %q", rawResponse)
+	}
+}
@@ -0,0 +1,200 @@
+package webserver
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures the CORS middleware
+type CORSOptions struct {
+	// AllowedOrigins lists the origins that may make cross-origin requests. Entries are exact origins like
+	// `https://example.com`, wildcard subdomains like `https://*.example.com`, or `*` for any origin.
+	AllowedOrigins []string
+	// AllowOriginFunc decides whether an origin that isn't in AllowedOrigins may make cross-origin requests
+	AllowOriginFunc func(origin string) bool
+	// AllowedMethods lists the methods cross-origin requests may use
+	AllowedMethods []Method
+	// AllowedHeaders lists the request headers cross-origin requests may send, or `*` for any header
+	AllowedHeaders []string
+	// ExposedHeaders lists the response headers, beyond the CORS-safelisted ones, that scripts may read
+	ExposedHeaders []string
+	// AllowCredentials lets cross-origin requests include cookies and Authorization headers
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache the result of a preflight request. Zero leaves it to the browser.
+	MaxAge time.Duration
+}
+
+// DefaultCORSOptions returns options that allow the common methods and headers but no origins, which must be added
+func DefaultCORSOptions() CORSOptions {
+	return CORSOptions{
+		AllowedMethods: []Method{MethodGet, MethodHead, MethodPost, MethodPut, MethodPatch, MethodDelete},
+		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-Requested-With"},
+		MaxAge:         5 * time.Minute,
+	}
+}
+
+// NewCORSMiddleware creates a middleware that adds CORS headers to responses for allowed origins. Preflight requests
+// are answered with a 204 without calling the handler.
+func NewCORSMiddleware(options CORSOptions) Middleware {
+	allowAnyOrigin := false
+	for _, origin := range options.AllowedOrigins {
+		allowAnyOrigin = allowAnyOrigin || origin == "*"
+	}
+
+	allowAnyHeader := false
+	for _, header := range options.AllowedHeaders {
+		allowAnyHeader = allowAnyHeader || header == "*"
+	}
+
+	methods := make([]string, 0, len(options.AllowedMethods))
+	for _, method := range options.AllowedMethods {
+		methods = append(methods, string(method))
+	}
+
+	// The response only depends on the origin if it is reflected back rather than always being `*`
+	varyByOrigin := !allowAnyOrigin || options.AllowCredentials
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(request Request) Response {
+			origin, err := request.Headers().GetHeader("Origin")
+			hasOrigin := err == nil
+			allowed := hasOrigin && corsOriginAllowed(origin, options, allowAnyOrigin)
+
+			requestMethod, err := request.Headers().GetHeader("Access-Control-Request-Method")
+			if request.Method() == MethodOptions && hasOrigin && err == nil {
+				response := NewResponse(204)
+				addVary(response.Headers(), "Origin")
+				addVary(response.Headers(), "Access-Control-Request-Method")
+				addVary(response.Headers(), "Access-Control-Request-Headers")
+
+				requestHeaders, _ := request.Headers().GetHeader("Access-Control-Request-Headers")
+				if !allowed || !corsMethodAllowed(requestMethod, options.AllowedMethods) ||
+					(!allowAnyHeader && !corsHeadersAllowed(requestHeaders, options.AllowedHeaders)) {
+					return response
+				}
+
+				setCORSOriginHeaders(response.Headers(), origin, options, allowAnyOrigin)
+				response.Headers().SetHeader("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+				if allowAnyHeader && strings.TrimSpace(requestHeaders) != "" {
+					response.Headers().SetHeader("Access-Control-Allow-Headers", requestHeaders)
+				} else if !allowAnyHeader && len(options.AllowedHeaders) > 0 {
+					response.Headers().SetHeader("Access-Control-Allow-Headers", strings.Join(options.AllowedHeaders, ", "))
+				}
+
+				if options.MaxAge > 0 {
+					response.Headers().SetHeader("Access-Control-Max-Age", strconv.Itoa(int(options.MaxAge.Seconds())))
+				}
+
+				return response
+			}
+
+			response := next(request)
+
+			if varyByOrigin {
+				addVary(response.Headers(), "Origin")
+			}
+
+			if allowed {
+				setCORSOriginHeaders(response.Headers(), origin, options, allowAnyOrigin)
+				if len(options.ExposedHeaders) > 0 {
+					response.Headers().SetHeader("Access-Control-Expose-Headers", strings.Join(options.ExposedHeaders, ", "))
+				}
+			}
+
+			return response
+		}
+	}
+}
+
+// setCORSOriginHeaders sets the headers that let the origin read the response. Credentialed requests can't use `*`,
+// so the origin is reflected back instead.
+func setCORSOriginHeaders(headers ResponseHeaders, origin string, options CORSOptions, allowAnyOrigin bool) {
+	if allowAnyOrigin && !options.AllowCredentials {
+		headers.SetHeader("Access-Control-Allow-Origin", "*")
+	} else {
+		headers.SetHeader("Access-Control-Allow-Origin", origin)
+	}
+
+	if options.AllowCredentials {
+		headers.SetHeader("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// corsOriginAllowed returns whether the origin matches one of the allowed origins or is accepted by AllowOriginFunc
+func corsOriginAllowed(origin string, options CORSOptions, allowAnyOrigin bool) bool {
+	if allowAnyOrigin {
+		return true
+	}
+
+	for _, allowedOrigin := range options.AllowedOrigins {
+		if corsOriginMatches(origin, allowedOrigin) {
+			return true
+		}
+	}
+
+	return options.AllowOriginFunc != nil && options.AllowOriginFunc(origin)
+}
+
+// corsOriginMatches returns whether the origin matches an allowed origin, which may contain a `*` in place of one or
+// more subdomains, e.g. `https://*.example.com`
+func corsOriginMatches(origin string, allowedOrigin string) bool {
+	origin = strings.ToLower(origin)
+	allowedOrigin = strings.ToLower(allowedOrigin)
+
+	prefix, suffix, wildcard := strings.Cut(allowedOrigin, "*")
+	if !wildcard {
+		return origin == allowedOrigin
+	}
+
+	if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	// The wildcard may only stand in for subdomain labels, so it can't be used to match a different scheme or port
+	subdomains := origin[len(prefix) : len(origin)-len(suffix)]
+	for i := 0; i < len(subdomains); i++ {
+		c := subdomains[i]
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' && c != '.' {
+			return false
+		}
+	}
+
+	return !strings.HasPrefix(subdomains, ".") && !strings.HasSuffix(subdomains, ".")
+}
+
+// corsMethodAllowed returns whether the method a preflight asks about is allowed
+func corsMethodAllowed(method string, allowedMethods []Method) bool {
+	for _, allowedMethod := range allowedMethods {
+		if strings.EqualFold(method, string(allowedMethod)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// corsHeadersAllowed returns whether every header in a preflight's Access-Control-Request-Headers is allowed
+func corsHeadersAllowed(requestHeaders string, allowedHeaders []string) bool {
+	for _, header := range strings.Split(requestHeaders, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+
+		found := false
+		for _, allowedHeader := range allowedHeaders {
+			if strings.EqualFold(header, allowedHeader) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
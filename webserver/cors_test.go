@@ -0,0 +1,204 @@
+package webserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCorsOriginMatches(t *testing.T) {
+	var tests = []struct {
+		origin        string
+		allowedOrigin string
+		expected      bool
+	}{
+		{"https://example.com", "https://example.com", true},
+		{"https://EXAMPLE.com", "https://example.com", true},
+		{"http://example.com", "https://example.com", false},
+		{"https://api.example.com", "https://*.example.com", true},
+		{"https://a.b.example.com", "https://*.example.com", true},
+		{"https://example.com", "https://*.example.com", false},
+		{"https://evilexample.com", "https://*.example.com", false},
+		{"http://api.example.com", "https://*.example.com", false},
+		{"https://api.example.com:8443", "https://*.example.com", false},
+		{"https://evil.com/.example.com", "https://*.example.com", false},
+		{"https://api.example.com:8443", "https://*.example.com:8443", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin+" "+tt.allowedOrigin, func(t *testing.T) {
+			if corsOriginMatches(tt.origin, tt.allowedOrigin) != tt.expected {
+				t.Errorf("Expected %v", tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewCORSMiddleware_Preflight(t *testing.T) {
+	options := DefaultCORSOptions()
+	options.AllowedOrigins = []string{"https://app.example.com"}
+	options.AllowOriginFunc = func(origin string) bool {
+		return origin == "https://partner.test"
+	}
+
+	called := false
+	handler := NewCORSMiddleware(options)(func(request Request) Response {
+		called = true
+		return OkResponse()
+	})
+
+	var tests = []struct {
+		name           string
+		origin         string
+		method         string
+		requestHeaders string
+		allowed        bool
+	}{
+		{"Allowed origin", "https://app.example.com", "PUT", "Content-Type, Authorization", true},
+		{"Allowed by function", "https://partner.test", "DELETE", "", true},
+		{"Disallowed origin", "https://evil.test", "PUT", "", false},
+		{"Disallowed method", "https://app.example.com", "CONNECT", "", false},
+		{"Disallowed header", "https://app.example.com", "PUT", "X-Secret", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{"Origin": tt.origin, "Access-Control-Request-Method": tt.method}
+			if tt.requestHeaders != "" {
+				headers["Access-Control-Request-Headers"] = tt.requestHeaders
+			}
+
+			response := handler(newTestRequest(MethodOptions, "/api/person", headers))
+			if response.StatusCode() != 204 || called {
+				t.Fatalf("Expected a 204 without calling the handler but received %d", response.StatusCode())
+			}
+
+			allowOrigin, err := response.Headers().GetHeader("Access-Control-Allow-Origin")
+			if tt.allowed != (err == nil) || (tt.allowed && allowOrigin != tt.origin) {
+				t.Fatalf("Expected the origin to be allowed: %v but received %q", tt.allowed, allowOrigin)
+			}
+
+			if vary, _ := response.Headers().GetHeader("Vary"); vary != "Origin, Access-Control-Request-Method, Access-Control-Request-Headers" {
+				t.Errorf("Expected the preflight to vary by its request headers but received %q", vary)
+			}
+
+			if !tt.allowed {
+				return
+			}
+
+			if methods, _ := response.Headers().GetHeader("Access-Control-Allow-Methods"); !strings.Contains(methods, tt.method) {
+				t.Errorf("Expected the allowed methods to include %s but received %q", tt.method, methods)
+			}
+
+			if maxAge, _ := response.Headers().GetHeader("Access-Control-Max-Age"); maxAge != "300" {
+				t.Errorf("Expected a max age of 300 but received %q", maxAge)
+			}
+		})
+	}
+}
+
+func TestNewCORSMiddleware_ActualRequest(t *testing.T) {
+	var tests = []struct {
+		name        string
+		options     CORSOptions
+		origin      string
+		allowOrigin string
+		credentials string
+		exposed     string
+		vary        string
+	}{
+		{
+			name:        "Any origin",
+			options:     CORSOptions{AllowedOrigins: []string{"*"}},
+			origin:      "https://anywhere.test",
+			allowOrigin: "*",
+		},
+		{
+			name:        "Any origin with credentials",
+			options:     CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true, ExposedHeaders: []string{"X-Request-Id"}},
+			origin:      "https://anywhere.test",
+			allowOrigin: "https://anywhere.test",
+			credentials: "true",
+			exposed:     "X-Request-Id",
+			vary:        "Origin",
+		},
+		{
+			name:        "Wildcard subdomain",
+			options:     CORSOptions{AllowedOrigins: []string{"https://*.example.com"}},
+			origin:      "https://app.example.com",
+			allowOrigin: "https://app.example.com",
+			vary:        "Origin",
+		},
+		{
+			name:    "Disallowed origin",
+			options: CORSOptions{AllowedOrigins: []string{"https://app.example.com"}},
+			origin:  "https://evil.test",
+			vary:    "Origin",
+		},
+		{
+			name:    "Same origin request",
+			options: CORSOptions{AllowedOrigins: []string{"https://app.example.com"}},
+			vary:    "Origin",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewCORSMiddleware(tt.options)(func(request Request) Response {
+				return OkResponse()
+			})
+
+			headers := map[string]string{}
+			if tt.origin != "" {
+				headers["Origin"] = tt.origin
+			}
+
+			response := handler(newTestRequest(MethodGet, "/api/person", headers))
+			if response.StatusCode() != 200 {
+				t.Fatalf("Expected the handler's response but received %d", response.StatusCode())
+			}
+
+			var expectedHeaders = map[string]string{
+				"Access-Control-Allow-Origin":      tt.allowOrigin,
+				"Access-Control-Allow-Credentials": tt.credentials,
+				"Access-Control-Expose-Headers":    tt.exposed,
+				"Vary":                             tt.vary,
+			}
+			for header, expected := range expectedHeaders {
+				if value, _ := response.Headers().GetHeader(header); value != expected {
+					t.Errorf("Expected %s to be %q but received %q", header, expected, value)
+				}
+			}
+		})
+	}
+}
+
+func TestNewCORSMiddleware_OptionsWithoutPreflight(t *testing.T) {
+	handler := NewCORSMiddleware(CORSOptions{AllowedOrigins: []string{"*"}})(func(request Request) Response {
+		return OkResponseWithBody([]byte("options"))
+	})
+
+	response := handler(newTestRequest(MethodOptions, "/", map[string]string{"Origin": "https://app.test"}))
+	if response.StatusCode() != 200 || string(response.Body()) != "options" {
+		t.Fatalf("Expected an OPTIONS request without Access-Control-Request-Method to reach the handler")
+	}
+}
+
+func TestNewCORSMiddleware_AnyHeader(t *testing.T) {
+	options := DefaultCORSOptions()
+	options.AllowedOrigins = []string{"https://app.test"}
+	options.AllowedHeaders = []string{"*"}
+
+	handler := NewCORSMiddleware(options)(func(request Request) Response {
+		return OkResponse()
+	})
+
+	response := handler(newTestRequest(MethodOptions, "/", map[string]string{
+		"Origin":                         "https://app.test",
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "X-Custom, Content-Type",
+	}))
+
+	if allowHeaders, _ := response.Headers().GetHeader("Access-Control-Allow-Headers"); allowHeaders != "X-Custom, Content-Type" {
+		t.Fatalf("Expected the requested headers to be allowed but received %q", allowHeaders)
+	}
+}
@@ -0,0 +1,324 @@
+package webserver
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ErrCSRFTokenInvalid is the cause of the error rendered when an unsafe request doesn't carry the expected CSRF token
+var ErrCSRFTokenInvalid = errors.New("the CSRF token is missing or invalid")
+
+// ErrCrossOriginRequest is the cause of the error rendered when an unsafe request comes from an untrusted origin
+var ErrCrossOriginRequest = errors.New("the request came from an untrusted origin")
+
+// csrfTokenLength is the number of random bytes in a CSRF token
+const csrfTokenLength = 32
+
+// csrfSessionKey is the session value the token is kept under in CSRFSession mode
+const csrfSessionKey = "csrf_token"
+
+// maxCSRFFormMemory is how much of a multipart form is held in memory while looking for the token field
+const maxCSRFFormMemory = 1 << 20
+
+// CSRFMode decides where the expected CSRF token is kept
+type CSRFMode int
+
+const (
+	// CSRFDoubleSubmit keeps the token in a cookie, and requests must submit the same token. It doesn't need sessions.
+	CSRFDoubleSubmit CSRFMode = iota
+	// CSRFSession keeps the token in the session, which needs the session middleware to run first
+	CSRFSession
+)
+
+// CSRFOptions configures the CSRF middleware
+type CSRFOptions struct {
+	// Mode decides where the expected token is kept
+	Mode CSRFMode
+	// FieldName is the form field the token is read from
+	FieldName string
+	// HeaderName is the header the token is read from, which is how scripts submit it
+	HeaderName string
+	// Cookie holds the name and attributes of the cookie the token is kept in with CSRFDoubleSubmit
+	Cookie Cookie
+	// TrustedOrigins lists other origins that may make unsafe requests, e.g. `https://app.example.com`. Wildcard
+	// subdomains like `https://*.example.com` are allowed.
+	TrustedOrigins []string
+	// Exempt lists the paths that aren't checked, such as API routes that authenticate with bearer tokens rather than
+	// cookies
+	Exempt []Path
+}
+
+// DefaultCSRFOptions returns options that use double-submit tokens read from the `csrf_token` form field or the
+// X-CSRF-Token header
+func DefaultCSRFOptions() CSRFOptions {
+	return CSRFOptions{
+		Mode:       CSRFDoubleSubmit,
+		FieldName:  "csrf_token",
+		HeaderName: "X-CSRF-Token",
+		Cookie: Cookie{
+			Name:     "csrf_token",
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: SameSiteLax,
+		},
+	}
+}
+
+// csrfKey is the context key the request's CSRF state is stored under
+type csrfKey struct{}
+
+// csrfState holds the request's CSRF token, which is only created when a handler asks for it
+type csrfState struct {
+	options CSRFOptions
+	request Request
+
+	mutex  sync.Mutex
+	loaded bool
+	token  []byte
+	// created is set when a new token was made, so that it needs storing
+	created bool
+}
+
+// CSRFToken returns the token to embed in forms or send in the CSRF header. It is masked with a different random
+// value each time, so that it can't be recovered through compression side channels like BREACH. It returns an empty
+// string if the CSRF middleware isn't running.
+func CSRFToken(request Request) string {
+	state, found := request.Context().Value(csrfKey{}).(*csrfState)
+	if !found {
+		return ""
+	}
+
+	return maskCSRFToken(state.get())
+}
+
+// NewCSRFMiddleware creates a middleware that protects unsafe requests (anything other than GET, HEAD, OPTIONS and
+// TRACE) from cross-site request forgery. They must carry the token from CSRFToken in a form field or header, and
+// if the browser says where they came from with Origin or Sec-Fetch-Site, it must be this site or a trusted origin.
+// Failures receive a 403.
+func NewCSRFMiddleware(options CSRFOptions) Middleware {
+	defaults := DefaultCSRFOptions()
+	if options.FieldName == "" {
+		options.FieldName = defaults.FieldName
+	}
+
+	if options.HeaderName == "" {
+		options.HeaderName = defaults.HeaderName
+	}
+
+	if options.Cookie.Name == "" {
+		options.Cookie.Name = defaults.Cookie.Name
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(request Request) Response {
+			for _, path := range options.Exempt {
+				if path.Matches(request.Path()) {
+					return next(request)
+				}
+			}
+
+			state := &csrfState{options: options, request: request}
+
+			if !isSafeMethod(request.Method()) {
+				if !csrfOriginAllowed(request, options.TrustedOrigins) {
+					return RenderError(request, ForbiddenError("The request came from an untrusted origin.", ErrCrossOriginRequest))
+				}
+
+				submitted := unmaskCSRFToken(submittedCSRFToken(request, options))
+				expected := state.load()
+				if expected == nil || subtle.ConstantTimeCompare(submitted, expected) != 1 {
+					return RenderError(request, ForbiddenError("The CSRF token is missing or invalid.", ErrCSRFTokenInvalid))
+				}
+			}
+
+			response := next(request.WithValue(csrfKey{}, state))
+
+			state.mutex.Lock()
+			defer state.mutex.Unlock()
+			if state.created && options.Mode == CSRFDoubleSubmit {
+				cookie := options.Cookie
+				cookie.Value = base64.RawURLEncoding.EncodeToString(state.token)
+				response.SetCookie(cookie)
+			}
+
+			return response
+		}
+	}
+}
+
+// load returns the token kept in the cookie or session, or nil if there isn't a valid one. It is only looked up once
+// it is needed, so that safe requests that don't render a form don't load the session.
+func (s *csrfState) load() []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.loadLocked()
+}
+
+// loadLocked is load for callers that hold the mutex
+func (s *csrfState) loadLocked() []byte {
+	if !s.loaded {
+		s.token = s.stored()
+		s.loaded = true
+	}
+
+	return s.token
+}
+
+// get returns the request's token, creating one if there isn't one stored
+func (s *csrfState) get() []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.loadLocked() == nil {
+		s.token = randomBytes(csrfTokenLength)
+		s.created = true
+
+		if s.options.Mode == CSRFSession {
+			if session, found := SessionFromRequest(s.request); found {
+				session.Set(csrfSessionKey, base64.RawURLEncoding.EncodeToString(s.token))
+			}
+		}
+	}
+
+	return s.token
+}
+
+// stored returns the token kept in the cookie or session, or nil if there isn't a valid one
+func (s *csrfState) stored() []byte {
+	var encoded string
+	if s.options.Mode == CSRFSession {
+		session, found := SessionFromRequest(s.request)
+		if !found {
+			return nil
+		}
+
+		value, _ := session.Get(csrfSessionKey)
+		encoded, _ = value.(string)
+	} else {
+		cookie, err := s.request.Cookie(s.options.Cookie.Name)
+		if err != nil {
+			return nil
+		}
+
+		encoded = cookie.Value
+	}
+
+	token, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(token) != csrfTokenLength {
+		return nil
+	}
+
+	return token
+}
+
+// isSafeMethod returns whether the method shouldn't change anything on the server, so can't be used for CSRF
+func isSafeMethod(method Method) bool {
+	return method == MethodGet || method == MethodHead || method == MethodOptions || method == MethodTrace
+}
+
+// csrfOriginAllowed checks where the browser says the request came from. Requests with an Origin must come from this
+// host or a trusted origin. Without an Origin, Sec-Fetch-Site must show that the request came from this site or was
+// made by the user directly. Requests with neither header, such as those from older browsers, rely on the token.
+func csrfOriginAllowed(request Request, trustedOrigins []string) bool {
+	if origin, err := request.Headers().GetHeader("Origin"); err == nil {
+		return originAllowed(request, origin, trustedOrigins)
+	}
+
+	if site, err := request.Headers().GetHeader("Sec-Fetch-Site"); err == nil {
+		return site == "same-origin" || site == "none"
+	}
+
+	return true
+}
+
+// originAllowed returns whether an Origin header names this host or one of the trusted origins. The opaque "null"
+// origin is never allowed.
+func originAllowed(request Request, origin string, trustedOrigins []string) bool {
+	if origin == "null" {
+		return false
+	}
+
+	for _, trustedOrigin := range trustedOrigins {
+		if corsOriginMatches(origin, trustedOrigin) {
+			return true
+		}
+	}
+
+	parsed, err := url.Parse(origin)
+	host, hostErr := request.Headers().GetHeader("Host")
+	return err == nil && hostErr == nil && parsed.Host != "" && strings.EqualFold(parsed.Host, host)
+}
+
+// submittedCSRFToken returns the token from the header, or from the form field if the body is a form
+func submittedCSRFToken(request Request, options CSRFOptions) string {
+	if token, err := request.Headers().GetHeader(options.HeaderName); err == nil {
+		return token
+	}
+
+	contentType, err := request.Headers().GetHeader("Content-Type")
+	if err != nil {
+		return ""
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(request.BodyAsString())
+		if err != nil {
+			return ""
+		}
+
+		return values.Get(options.FieldName)
+	case "multipart/form-data":
+		form, err := multipart.NewReader(bytes.NewReader(request.Body()), params["boundary"]).ReadForm(maxCSRFFormMemory)
+		if err != nil {
+			return ""
+		}
+		defer form.RemoveAll()
+
+		if values := form.Value[options.FieldName]; len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
+
+// maskCSRFToken XORs the token with a one-time pad and prepends the pad, so the same token looks different every time
+func maskCSRFToken(token []byte) string {
+	pad := randomBytes(len(token))
+	masked := make([]byte, 0, len(token)*2)
+	masked = append(masked, pad...)
+	for i := range token {
+		masked = append(masked, token[i]^pad[i])
+	}
+
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+// unmaskCSRFToken reverses maskCSRFToken, returning nil if the value isn't a masked token
+func unmaskCSRFToken(value string) []byte {
+	masked, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(masked) != csrfTokenLength*2 {
+		return nil
+	}
+
+	token := make([]byte, csrfTokenLength)
+	for i := range token {
+		token[i] = masked[i] ^ masked[csrfTokenLength+i]
+	}
+
+	return token
+}
@@ -0,0 +1,185 @@
+package webserver
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCSRFTokenMasking(t *testing.T) {
+	token := randomBytes(csrfTokenLength)
+
+	first, second := maskCSRFToken(token), maskCSRFToken(token)
+	if first == second {
+		t.Fatalf("Expected each masked token to be different")
+	}
+
+	if !bytes.Equal(unmaskCSRFToken(first), token) || !bytes.Equal(unmaskCSRFToken(second), token) {
+		t.Fatalf("Expected the masked tokens to unmask to the token")
+	}
+
+	if unmaskCSRFToken("short") != nil || unmaskCSRFToken("") != nil {
+		t.Fatalf("Expected invalid values to unmask to nil")
+	}
+}
+
+func TestCSRFOriginAllowed(t *testing.T) {
+	var tests = []struct {
+		name     string
+		headers  map[string]string
+		expected bool
+	}{
+		{"Same origin", map[string]string{"Host": "example.com", "Origin": "https://example.com"}, true},
+		{"Same origin with port", map[string]string{"Host": "localhost:8080", "Origin": "http://localhost:8080"}, true},
+		{"Trusted origin", map[string]string{"Host": "example.com", "Origin": "https://app.trusted.test"}, true},
+		{"Cross origin", map[string]string{"Host": "example.com", "Origin": "https://evil.test"}, false},
+		{"Null origin", map[string]string{"Host": "example.com", "Origin": "null"}, false},
+		{"Same origin fetch", map[string]string{"Sec-Fetch-Site": "same-origin"}, true},
+		{"User initiated", map[string]string{"Sec-Fetch-Site": "none"}, true},
+		{"Cross site fetch", map[string]string{"Sec-Fetch-Site": "cross-site"}, false},
+		{"Same site fetch", map[string]string{"Sec-Fetch-Site": "same-site"}, false},
+		{"No headers", map[string]string{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newTestRequest(MethodPost, "/", tt.headers)
+			if csrfOriginAllowed(request, []string{"https://*.trusted.test"}) != tt.expected {
+				t.Errorf("Expected %v", tt.expected)
+			}
+		})
+	}
+}
+
+// csrfTestHandler renders the token for GET requests and accepts everything else
+func csrfTestHandler(request Request) Response {
+	if request.Method() == MethodGet {
+		return OkResponseWithBody([]byte(CSRFToken(request)))
+	}
+
+	return OkResponseWithBody([]byte("accepted"))
+}
+
+func TestNewCSRFMiddleware_DoubleSubmit(t *testing.T) {
+	options := DefaultCSRFOptions()
+	options.Exempt = []Path{RegexPath(regexp.MustCompile("^/api/"))}
+	handler := NewCSRFMiddleware(options)(csrfTestHandler)
+
+	// Requests that don't render a token don't get a cookie
+	noToken := NewCSRFMiddleware(options)(func(request Request) Response { return OkResponse() })
+	if response := noToken(newTestRequest(MethodGet, "/", nil)); len(response.Cookies()) != 0 {
+		t.Fatalf("Expected no cookie when the token isn't used")
+	}
+
+	response := handler(newTestRequest(MethodGet, "/form", nil))
+	if len(response.Cookies()) != 1 || !response.Cookies()[0].HttpOnly {
+		t.Fatalf("Expected the token cookie to be set")
+	}
+	cookie := "csrf_token=" + response.Cookies()[0].Value
+	token := string(response.Body())
+
+	// A second page view reuses the cookie's token without setting it again
+	response = handler(newTestRequest(MethodGet, "/form", map[string]string{"Cookie": cookie}))
+	if len(response.Cookies()) != 0 || string(response.Body()) == token {
+		t.Fatalf("Expected the existing token to be reused and masked differently")
+	}
+	otherToken := string(response.Body())
+
+	form := url.Values{"csrf_token": {token}, "name": {"alice"}}.Encode()
+
+	var multipartBody bytes.Buffer
+	writer := multipart.NewWriter(&multipartBody)
+	_ = writer.WriteField("csrf_token", otherToken)
+	_ = writer.Close()
+
+	var tests = []struct {
+		name     string
+		method   Method
+		path     string
+		headers  map[string]string
+		body     string
+		expected int
+		message  string
+	}{
+		{"Form field", MethodPost, "/form", map[string]string{"Cookie": cookie, "Content-Type": "application/x-www-form-urlencoded"}, form, 200, ""},
+		{"Header", MethodDelete, "/form", map[string]string{"Cookie": cookie, "X-CSRF-Token": otherToken}, "", 200, ""},
+		{"Multipart form", MethodPut, "/form", map[string]string{"Cookie": cookie, "Content-Type": writer.FormDataContentType()}, multipartBody.String(), 200, ""},
+		{"Missing token", MethodPost, "/form", map[string]string{"Cookie": cookie}, "", 403, "CSRF token"},
+		{"Missing cookie", MethodPost, "/form", map[string]string{"X-CSRF-Token": token}, "", 403, "CSRF token"},
+		{"Wrong token", MethodPatch, "/form", map[string]string{"Cookie": cookie, "X-CSRF-Token": maskCSRFToken(randomBytes(csrfTokenLength))}, "", 403, "CSRF token"},
+		{"Unmasked cookie value", MethodPost, "/form", map[string]string{"Cookie": cookie, "X-CSRF-Token": strings.TrimPrefix(cookie, "csrf_token=")}, "", 403, "CSRF token"},
+		{"Cross origin", MethodPost, "/form", map[string]string{"Cookie": cookie, "X-CSRF-Token": token, "Host": "example.com", "Origin": "https://evil.test"}, "", 403, "untrusted origin"},
+		{"Exempt path", MethodPost, "/api/person", nil, "", 200, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newTestRequest(tt.method, tt.path, tt.headers)
+			request.body = []byte(tt.body)
+
+			response := handler(request)
+			if response.StatusCode() != tt.expected {
+				t.Fatalf("Expected %d but received %d", tt.expected, response.StatusCode())
+			}
+
+			if !strings.Contains(string(response.Body()), tt.message) {
+				t.Errorf("Expected the body to mention %q but received %q", tt.message, string(response.Body()))
+			}
+		})
+	}
+}
+
+func TestNewCSRFMiddleware_Session(t *testing.T) {
+	sessionOptions := DefaultSessionOptions()
+	sessionOptions.Store = NewMemorySessionStore(0)
+
+	csrfOptions := DefaultCSRFOptions()
+	csrfOptions.Mode = CSRFSession
+
+	handler := chainMiddleware(csrfTestHandler, []Middleware{NewSessionMiddleware(sessionOptions), NewCSRFMiddleware(csrfOptions)})
+
+	response := handler(newTestRequest(MethodGet, "/form", nil))
+	if len(response.Cookies()) != 1 || response.Cookies()[0].Name != "session" {
+		t.Fatalf("Expected the token to be kept in the session rather than its own cookie")
+	}
+	cookie := "session=" + response.Cookies()[0].Value
+	token := string(response.Body())
+
+	response = handler(newTestRequest(MethodPost, "/form", map[string]string{"Cookie": cookie, "X-CSRF-Token": token}))
+	if response.StatusCode() != 200 {
+		t.Fatalf("Expected the session's token to be accepted but received %d", response.StatusCode())
+	}
+
+	response = handler(newTestRequest(MethodPost, "/form", map[string]string{"X-CSRF-Token": token}))
+	if response.StatusCode() != 403 {
+		t.Fatalf("Expected the token to be rejected without the session but received %d", response.StatusCode())
+	}
+}
+
+func TestCSRFToken_WithoutMiddleware(t *testing.T) {
+	if token := CSRFToken(newTestRequest(MethodGet, "/", nil)); token != "" {
+		t.Fatalf("Expected no token without the middleware but received %q", token)
+	}
+}
+
+func TestNewCSRFMiddleware_UsesServerErrorHandler(t *testing.T) {
+	handler := NewCSRFMiddleware(DefaultCSRFOptions())(func(request Request) Response {
+		return OkResponse()
+	})
+
+	request := newTestRequest(MethodPost, "/form", nil).WithValue(errorHandlerKey{}, ErrorHandler(func(request Request, err error) Response {
+		if !errors.Is(err, ErrCSRFTokenInvalid) {
+			return InternalErrorResponse()
+		}
+
+		return NewResponseWithBody(403, []byte("custom"))
+	}))
+
+	if response := handler(request); response.StatusCode() != 403 || string(response.Body()) != "custom" {
+		t.Fatalf("Expected the server's error handler to render the rejection but received %d %q", response.StatusCode(), string(response.Body()))
+	}
+}
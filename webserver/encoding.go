@@ -0,0 +1,93 @@
+package webserver
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedEncoding is returned when asked to encode a body with a content coding that isn't supported
+var ErrUnsupportedEncoding = errors.New("the content coding is not supported")
+
+// parseAcceptEncoding parses an Accept-Encoding header into a map of lower case content codings to their q-values.
+// Codings without a q-value have a q-value of 1 and codings with an invalid q-value are ignored.
+func parseAcceptEncoding(header string) map[string]float64 {
+	encodings := make(map[string]float64)
+
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+
+		q, ok := parseQValue(params[1:])
+		if !ok {
+			continue
+		}
+
+		encodings[coding] = q
+	}
+
+	return encodings
+}
+
+// parseQValue finds the `q` parameter in a list of header parameters, returning 1 if there isn't one and false if it
+// is not a valid q-value
+func parseQValue(params []string) (float64, bool) {
+	for _, param := range params {
+		name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+		if !found || strings.ToLower(strings.TrimSpace(name)) != "q" {
+			continue
+		}
+
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0, false
+		}
+
+		return q, true
+	}
+
+	return 1, true
+}
+
+// negotiateEncoding picks the content coding the client prefers out of the available codings, which are listed in the
+// server's order of preference. An empty string is returned when the response should not be encoded.
+func negotiateEncoding(acceptEncoding string, available []string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+
+	accepted := parseAcceptEncoding(acceptEncoding)
+
+	// Identity is acceptable unless the client rules it out, either by name or through `*`
+	identityQ, found := accepted["identity"]
+	if !found {
+		identityQ = 1
+		if anyQ, found := accepted["*"]; found {
+			identityQ = anyQ
+		}
+	}
+
+	bestEncoding := ""
+	bestQ := 0.0
+	for _, encoding := range available {
+		q, found := accepted[strings.ToLower(encoding)]
+		if !found {
+			q = accepted["*"]
+		}
+
+		if q > bestQ {
+			bestEncoding = encoding
+			bestQ = q
+		}
+	}
+
+	if bestQ == 0 || bestQ < identityQ {
+		return ""
+	}
+
+	return bestEncoding
+}
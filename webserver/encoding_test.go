@@ -0,0 +1,53 @@
+package webserver
+
+import "testing"
+
+func TestParseAcceptEncoding(t *testing.T) {
+	encodings := parseAcceptEncoding("gzip;q=0.8, DEFLATE, br;q=invalid, zstd; q=0, *;q=0.1")
+
+	var tests = []struct {
+		coding   string
+		expected float64
+		found    bool
+	}{
+		{"gzip", 0.8, true},
+		{"deflate", 1, true},
+		{"br", 0, false},
+		{"zstd", 0, true},
+		{"*", 0.1, true},
+	}
+
+	for _, test := range tests {
+		q, found := encodings[test.coding]
+		if found != test.found || q != test.expected {
+			t.Errorf("parseAcceptEncoding() expected %s to be %v (found %v), got %v (found %v)", test.coding, test.expected, test.found, q, found)
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	available := []string{"zstd", "gzip"}
+
+	var tests = []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, zstd", "zstd"},
+		{"gzip;q=1, zstd;q=0.9", "gzip"},
+		{"*", "zstd"},
+		{"*;q=0.5, zstd;q=0", "gzip"},
+		{"gzip;q=0", ""},
+		{"br", ""},
+		{"gzip;q=0.5, identity", ""},
+		{"gzip;q=0.5, identity;q=0.1", "gzip"},
+	}
+
+	for _, test := range tests {
+		encoding := negotiateEncoding(test.acceptEncoding, available)
+		if encoding != test.expected {
+			t.Errorf("negotiateEncoding(%q) expected %q, got %q", test.acceptEncoding, test.expected, encoding)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+)
+
+// ErrorHandler builds the response sent to the client when a request fails with an error, such as a handler returning
+// an error or panicking
+type ErrorHandler func(request Request, err error) Response
+
+// HTTPError is an error that carries the status code and message to send to the client, along with the internal
+// cause, which is logged but never sent
+type HTTPError struct {
+	// StatusCode is the status code of the response
+	StatusCode int
+	// Message is a description of the problem that is safe to show to the client
+	Message string
+	// Cause is the underlying error, if there is one
+	Cause error
+}
+
+// Error returns a description of the error including its cause
+func (e *HTTPError) Error() string {
+	description := statusLine(e.StatusCode)
+	if e.Message != "" {
+		description += ": " + e.Message
+	}
+
+	if e.Cause != nil {
+		description += ": " + e.Cause.Error()
+	}
+
+	return description
+}
+
+// Unwrap returns the cause of the error
+func (e *HTTPError) Unwrap() error {
+	return e.Cause
+}
+
+// NewHTTPError creates an error that is rendered as a response with the given status code and public message
+func NewHTTPError(statusCode int, message string, cause error) *HTTPError {
+	return &HTTPError{
+		StatusCode: statusCode,
+		Message:    message,
+		Cause:      cause,
+	}
+}
+
+// BadRequestError creates an error that is rendered as a 400 with the given public message
+func BadRequestError(message string, cause error) *HTTPError {
+	return NewHTTPError(400, message, cause)
+}
+
+// UnauthorizedError creates an error that is rendered as a 401 with the given public message
+func UnauthorizedError(message string, cause error) *HTTPError {
+	return NewHTTPError(401, message, cause)
+}
+
+// ForbiddenError creates an error that is rendered as a 403 with the given public message
+func ForbiddenError(message string, cause error) *HTTPError {
+	return NewHTTPError(403, message, cause)
+}
+
+// NotFoundError creates an error that is rendered as a 404 with the given public message
+func NotFoundError(message string, cause error) *HTTPError {
+	return NewHTTPError(404, message, cause)
+}
+
+// InternalError creates an error that is rendered as a 500 without exposing its cause
+func InternalError(cause error) *HTTPError {
+	return NewHTTPError(500, "", cause)
+}
+
+// problemDetails is the body of an RFC 9457 `application/problem+json` response
+type problemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// DefaultErrorHandler is the ErrorHandler used when none has been set. An HTTPError is rendered with its status code and
+// message, while any other error is rendered as a 500 that doesn't reveal anything about it. The body is an RFC 9457
+// problem+json document or an HTML page depending on the client's Accept header.
+func DefaultErrorHandler(request Request, err error) Response {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		httpErr = InternalError(err)
+	}
+
+	return renderError(request, httpErr.StatusCode, httpErr.Message)
+}
+
+// errorHandlerKey is the context key for the error handler of the web server handling a request
+type errorHandlerKey struct{}
+
+// RenderError builds the response for an error with the ErrorHandler of the web server handling the request, or the
+// DefaultErrorHandler outside of one, e.g. in tests. Middleware that rejects requests should use it so that the
+// handler set with SetErrorHandler renders those responses too.
+func RenderError(request Request, err error) Response {
+	if errorHandler, ok := request.Context().Value(errorHandlerKey{}).(ErrorHandler); ok {
+		return errorHandler(request, err)
+	}
+
+	return DefaultErrorHandler(request, err)
+}
+
+// renderError builds an error response with a problem+json or HTML body, whichever the client prefers
+func renderError(request Request, statusCode int, message string) Response {
+	accept, _ := request.Headers().GetHeader("Accept")
+	if negotiateMediaType(accept, []string{"application/problem+json", "application/json", "text/html"}) == "text/html" {
+		return renderHTMLError(statusCode, message)
+	}
+
+	return renderProblemError(request, statusCode, message)
+}
+
+// renderProblemError builds an error response with an RFC 9457 problem+json body
+func renderProblemError(request Request, statusCode int, message string) Response {
+	body, err := json.Marshal(problemDetails{
+		Type:     "about:blank",
+		Title:    statusText(statusCode),
+		Status:   statusCode,
+		Detail:   message,
+		Instance: request.Path(),
+	})
+	if err != nil {
+		return NewResponse(statusCode)
+	}
+
+	response := NewResponseWithBody(statusCode, body)
+	response.Headers().SetHeader("Content-Type", "application/problem+json")
+	return response
+}
+
+// renderHTMLError builds an error response with a small HTML page as its body
+func renderHTMLError(statusCode int, message string) Response {
+	title := html.EscapeString(statusLine(statusCode))
+
+	body := fmt.Sprintf("<!DOCTYPE html>\n<html lang=\"en\">\n    <head>\n        <title>%s</title>\n    </head>\n    <body>\n        <h1>%s</h1>\n", title, title)
+	if message != "" {
+		body += fmt.Sprintf("        <p>%s</p>\n", html.EscapeString(message))
+	}
+	body += "    </body>\n</html>\n"
+
+	response := NewResponseWithBody(statusCode, []byte(body))
+	response.Headers().SetHeader("Content-Type", "text/html; charset=utf-8")
+	return response
+}
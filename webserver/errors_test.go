@@ -0,0 +1,76 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestHTTPError_Error(t *testing.T) {
+	err := NotFoundError("person 42 does not exist", io.EOF)
+
+	expected := "404 Not Found: person 42 does not exist: EOF"
+	if err.Error() != expected {
+		t.Fatalf("Expected Error() to return %q but received %q", expected, err.Error())
+	}
+
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected the HTTPError to unwrap to its cause")
+	}
+}
+
+func TestDefaultErrorHandlerWithProblemJSON(t *testing.T) {
+	request := newTestRequest(MethodGet, "/api/person/42", map[string]string{"Accept": "application/json"})
+	response := DefaultErrorHandler(request, NotFoundError("person 42 does not exist", nil))
+
+	if response.StatusCode() != 404 {
+		t.Fatalf("Expected a 404 but received %d", response.StatusCode())
+	}
+
+	if contentType, _ := response.Headers().GetHeader("Content-Type"); contentType != "application/problem+json" {
+		t.Fatalf("Expected Content-Type application/problem+json but received %s", contentType)
+	}
+
+	var problem problemDetails
+	if err := json.Unmarshal(response.Body(), &problem); err != nil {
+		t.Fatalf("Could not parse the problem details: %v", err)
+	}
+
+	expected := problemDetails{Type: "about:blank", Title: "Not Found", Status: 404, Detail: "person 42 does not exist", Instance: "/api/person/42"}
+	if problem != expected {
+		t.Fatalf("Expected problem details %+v but received %+v", expected, problem)
+	}
+}
+
+func TestDefaultErrorHandlerWithHTML(t *testing.T) {
+	request := newTestRequest(MethodGet, "/about", map[string]string{"Accept": "text/html,*/*;q=0.8"})
+	response := DefaultErrorHandler(request, BadRequestError("<script> is not allowed", nil))
+
+	if response.StatusCode() != 400 {
+		t.Fatalf("Expected a 400 but received %d", response.StatusCode())
+	}
+
+	if contentType, _ := response.Headers().GetHeader("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+		t.Fatalf("Expected an HTML Content-Type but received %s", contentType)
+	}
+
+	body := string(response.Body())
+	if !strings.Contains(body, "<h1>400 Bad Request</h1>") || !strings.Contains(body, "&lt;script&gt; is not allowed") {
+		t.Fatalf("Expected an HTML page with the escaped message but received %q", body)
+	}
+}
+
+func TestDefaultErrorHandlerHidesInternalErrors(t *testing.T) {
+	request := newTestRequest(MethodGet, "/", nil)
+	response := DefaultErrorHandler(request, errors.New("database password is hunter2"))
+
+	if response.StatusCode() != 500 {
+		t.Fatalf("Expected a 500 but received %d", response.StatusCode())
+	}
+
+	if strings.Contains(string(response.Body()), "hunter2") {
+		t.Fatalf("Expected the internal error not to be exposed but received %q", string(response.Body()))
+	}
+}
@@ -2,64 +2,78 @@ package webserver
 
 import (
 	"errors"
-	"fmt"
-	"os"
-	"path"
+	"time"
 )
 
+// ErrNilResponse is returned when a handler returns neither a response nor an error
+var ErrNilResponse = errors.New("the handler did not return a response")
+
 type HandlerFunc func(request Request) Response
 
+// HandlerFuncWithError is a handler that can return an error rather than building an error response itself. The error
+// is turned into a response by the web server's ErrorHandler.
+type HandlerFuncWithError func(request Request) (Response, error)
+
 type Handler struct {
 	method      Method
 	pathPattern Path
-	handler     HandlerFunc
+	handler     HandlerFuncWithError
+	// timeout is how long the handler may run for, or zero to use the web server's default
+	timeout time.Duration
+}
+
+// HandlerOption configures a handler when it is created
+type HandlerOption func(handler *Handler)
+
+// WithTimeout limits how long the handler may run for, overriding the web server's default. When the timeout elapses
+// the request's context is cancelled with ErrHandlerTimeout as the cause and the client receives a timeout response.
+func WithTimeout(timeout time.Duration) HandlerOption {
+	return func(handler *Handler) {
+		handler.timeout = timeout
+	}
 }
 
 func (h *Handler) Matches(request Request) bool {
 	return (request.Method() == h.method || h.method == MethodAny) && h.pathPattern.Matches(request.Path())
 }
 
+// Execute runs the handler. Any error it returns is rendered with RenderError.
 func (h *Handler) Execute(request Request) Response {
-	return h.handler(request)
+	response, err := h.executeWithError(request)
+	if err != nil {
+		return RenderError(request, err)
+	}
+
+	return response
 }
 
-func NewHandler(method Method, path Path, handler HandlerFunc) *Handler {
-	return &Handler{
+// executeWithError runs the handler, returning any error instead of rendering it
+func (h *Handler) executeWithError(request Request) (Response, error) {
+	response, err := h.handler(request)
+	if err == nil && response == nil {
+		return nil, ErrNilResponse
+	}
+
+	return response, err
+}
+
+func NewHandler(method Method, path Path, handler HandlerFunc, options ...HandlerOption) *Handler {
+	return NewHandlerWithError(method, path, func(request Request) (Response, error) {
+		return handler(request), nil
+	}, options...)
+}
+
+// NewHandlerWithError creates a handler whose function can return an error
+func NewHandlerWithError(method Method, path Path, handler HandlerFuncWithError, options ...HandlerOption) *Handler {
+	h := &Handler{
 		method:      method,
 		pathPattern: path,
 		handler:     handler,
 	}
-}
 
-func NewStaticFileHandler(wwwFilePath string) *Handler {
-	return NewHandler(MethodGet, AnyPath(), func(request Request) Response {
-		// First clean the path
-		cleanedRequestPath := path.Clean(request.Path())
-
-		// Account for `/`
-		if cleanedRequestPath == "/" {
-			cleanedRequestPath = "/index.html"
-		}
-
-		// Get the file path
-		filePath := path.Join(wwwFilePath, cleanedRequestPath)
-
-		// Check if the file exists and return a 404 if it doesn't
-		_, err := os.Stat(filePath)
-		if err != nil && errors.Is(err, os.ErrNotExist) {
-			return NotFoundResponse()
-		} else if err != nil {
-			fmt.Printf("Internal error occurred while finding a static file: %v", err)
-			return InternalErrorResponse()
-		}
-
-		// Read the file
-		fileContents, err := os.ReadFile(filePath)
-		if err != nil {
-			fmt.Printf("Internal error occurred while reading a static file: %v", err)
-			return InternalErrorResponse()
-		}
-
-		return OkResponseWithBody(fileContents)
-	})
+	for _, option := range options {
+		option(h)
+	}
+
+	return h
 }
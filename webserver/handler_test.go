@@ -0,0 +1,65 @@
+package webserver
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHandler_Matches(t *testing.T) {
+	handler := NewHandler(MethodGet, StringPath("/hello"), func(request Request) Response {
+		return OkResponse()
+	})
+
+	var tests = []struct {
+		method   Method
+		path     string
+		expected bool
+	}{
+		{MethodGet, "/hello", true},
+		{MethodGet, "/hello/", true},
+		{MethodPost, "/hello", false},
+		{MethodGet, "/goodbye", false},
+	}
+
+	for _, test := range tests {
+		if handler.Matches(newTestRequest(test.method, test.path, nil)) != test.expected {
+			t.Errorf("Matches() for %s %s expected %v", test.method, test.path, test.expected)
+		}
+	}
+}
+
+func TestHandler_ExecuteWithError(t *testing.T) {
+	handler := NewHandlerWithError(MethodGet, AnyPath(), func(request Request) (Response, error) {
+		return nil, NotFoundError("nothing here", nil)
+	})
+
+	response := handler.Execute(newTestRequest(MethodGet, "/", nil))
+	if response.StatusCode() != 404 {
+		t.Fatalf("Expected the error to be rendered as a 404 but received %d", response.StatusCode())
+	}
+}
+
+func TestHandler_ExecuteWithNilResponse(t *testing.T) {
+	handler := NewHandlerWithError(MethodGet, AnyPath(), func(request Request) (Response, error) {
+		return nil, nil
+	})
+
+	if _, err := handler.executeWithError(newTestRequest(MethodGet, "/", nil)); !errors.Is(err, ErrNilResponse) {
+		t.Fatalf("Expected an ErrNilResponse error but received %v", err)
+	}
+}
+
+func TestHandler_ExecuteUsesServerErrorHandler(t *testing.T) {
+	handler := NewHandlerWithError(MethodGet, AnyPath(), func(request Request) (Response, error) {
+		return nil, NotFoundError("nothing here", nil)
+	})
+
+	request := newTestRequest(MethodGet, "/", nil).WithValue(errorHandlerKey{}, ErrorHandler(func(request Request, err error) Response {
+		return NewResponseWithBody(410, []byte("custom"))
+	}))
+
+	response := handler.Execute(request)
+	if response.StatusCode() != 410 || string(response.Body()) != "custom" {
+		t.Fatalf("Expected the server's error handler to render the error but received %d", response.StatusCode())
+	}
+}
@@ -26,8 +26,10 @@ type Headers interface {
 type ResponseHeaders interface {
 	// Headers is an extension of the Headers interface
 	Headers
-	// SetHeader sets the value of the specified header
+	// SetHeader sets the value of the specified header, replacing any existing value. The header key is case-insensitive
 	SetHeader(header string, value string)
+	// RemoveHeader removes the specified header if it exists. The header key is case-insensitive
+	RemoveHeader(header string)
 	// ClearHeaders clears all headers
 	ClearHeaders()
 }
@@ -77,16 +79,44 @@ func (h *headers) GetAsMap() map[string]string {
 	return toReturn
 }
 
-// SetHeader sets the value of the specified header
+// SetHeader sets the value of the specified header, replacing any existing value. The header key is case-insensitive
 func (h *headers) SetHeader(header string, value string) {
+	h.RemoveHeader(header)
 	h.headersMap[header] = value
 }
 
+// RemoveHeader removes the specified header if it exists. The header key is case-insensitive
+func (h *headers) RemoveHeader(header string) {
+	for k := range h.headersMap {
+		if strings.ToLower(k) == strings.ToLower(header) {
+			delete(h.headersMap, k)
+		}
+	}
+}
+
 // ClearHeaders clears all headers
 func (h *headers) ClearHeaders() {
 	h.headersMap = make(map[string]string)
 }
 
+// addVary adds a header name to the Vary header of a response if it isn't already listed
+func addVary(h ResponseHeaders, header string) {
+	vary, err := h.GetHeader("Vary")
+	if err != nil || strings.TrimSpace(vary) == "" {
+		h.SetHeader("Vary", header)
+		return
+	}
+
+	for _, existing := range strings.Split(vary, ",") {
+		existing = strings.TrimSpace(existing)
+		if existing == "*" || strings.EqualFold(existing, header) {
+			return
+		}
+	}
+
+	h.SetHeader("Vary", vary+", "+header)
+}
+
 // newResponseHeaders creates a new response headers object
 func newResponseHeaders() ResponseHeaders {
 	return &headers{
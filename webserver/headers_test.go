@@ -128,6 +128,58 @@ func TestResponseHeaders_SetHeader(t *testing.T) {
 	}
 }
 
+func TestResponseHeaders_SetHeaderWithDifferentCaseHeader(t *testing.T) {
+	headers := &headers{
+		headersMap: map[string]string{
+			"Content-Length": "13",
+			"Host":           "www.bing.com",
+		},
+	}
+
+	headers.SetHeader("content-length", "15")
+	if len(headers.headersMap) != 2 || headers.headersMap["content-length"] != "15" {
+		t.Fatalf("Expected content-length to replace Content-Length but headers were %v", headers.headersMap)
+	}
+}
+
+func TestResponseHeaders_RemoveHeader(t *testing.T) {
+	headers := &headers{
+		headersMap: map[string]string{
+			"Content-Length": "13",
+			"Host":           "www.bing.com",
+		},
+	}
+
+	headers.RemoveHeader("content-length")
+	if len(headers.headersMap) != 1 || headers.HasHeader("Content-Length") {
+		t.Fatalf("Expected Content-Length to be removed but headers were %v", headers.headersMap)
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	var tests = []struct {
+		vary     string
+		expected string
+	}{
+		{"", "Accept-Encoding"},
+		{"Origin", "Origin, Accept-Encoding"},
+		{"origin, accept-encoding", "origin, accept-encoding"},
+		{"*", "*"},
+	}
+
+	for _, test := range tests {
+		headers := newResponseHeaders()
+		if test.vary != "" {
+			headers.SetHeader("Vary", test.vary)
+		}
+
+		addVary(headers, "Accept-Encoding")
+		if vary, _ := headers.GetHeader("Vary"); vary != test.expected {
+			t.Errorf("addVary() with Vary %q expected %q, got %q", test.vary, test.expected, vary)
+		}
+	}
+}
+
 func TestResponseHeaders_ClearHeaders(t *testing.T) {
 	headers := &headers{
 		headersMap: map[string]string{
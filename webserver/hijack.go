@@ -0,0 +1,53 @@
+package webserver
+
+import (
+	"net"
+	"runtime/debug"
+	"time"
+)
+
+// HijackHandlerFunc takes ownership of a connection once the response has been sent. buffered holds any bytes the
+// client sent after the request that the server had already read, which must be consumed before reading from conn.
+// The handler must close the connection when it is done.
+type HijackHandlerFunc func(conn net.Conn, buffered []byte)
+
+// hijackResponse is a response that gives its connection away once it has been written
+type hijackResponse struct {
+	Response
+	hijack HijackHandlerFunc
+}
+
+// HijackResponse returns a response that hands the connection to the handler once the response has been sent, e.g. a
+// 101 that switches to a custom protocol. From then on the server stops managing the connection: it isn't closed,
+// doesn't count towards the connection limit and isn't waited for or closed at shutdown.
+func HijackResponse(response Response, handler HijackHandlerFunc) Response {
+	return &hijackResponse{
+		Response: response,
+		hijack:   handler,
+	}
+}
+
+// hijack gives the connection to a hijack response's handler, which runs on its own goroutine so that the server can
+// let go of the connection
+func (w *WebServer) hijack(conn *connection, response *hijackResponse) {
+	conn.hijacked.Store(true)
+
+	buffered, _ := conn.reader.Peek(conn.reader.Buffered())
+	buffered = append([]byte(nil), buffered...)
+
+	// The handler gets the plain connection, free of any deadlines
+	_ = conn.Conn.SetDeadline(time.Time{})
+
+	conn.logger.Debug("Connection hijacked")
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				conn.logger.Error("Hijack handler panicked", "panic", r, "stack", string(debug.Stack()))
+				_ = conn.Conn.Close()
+			}
+		}()
+
+		response.hijack(conn.Conn, buffered)
+	}()
+}
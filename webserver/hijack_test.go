@@ -0,0 +1,89 @@
+package webserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHijackResponse(t *testing.T) {
+	ws := NewWebServer()
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/shell"), func(request Request) Response {
+		response := NewResponse(101)
+		response.Headers().SetHeader("Upgrade", "echo")
+		response.Headers().SetHeader("Connection", "Upgrade")
+
+		return HijackResponse(response, func(conn net.Conn, buffered []byte) {
+			defer conn.Close()
+
+			// Bytes the server read ahead of the request come first
+			reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn))
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				if _, err := conn.Write([]byte("echo: " + line)); err != nil {
+					return
+				}
+			}
+		})
+	}))
+
+	addr, _ := startTestServer(t, &ws)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The first line of the new protocol is sent along with the request
+	if _, err := conn.Write([]byte("GET /shell HTTP/1.1\r\nHost: localhost\r\n\r\nfirst\n")); err != nil {
+		t.Fatalf("Could not send the request: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	var head strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Could not read the response head: %v", err)
+		}
+
+		head.WriteString(line)
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	if !strings.HasPrefix(head.String(), "HTTP/1.1 101 Switching Protocols\r\n") || !strings.Contains(head.String(), "Upgrade: echo\r\n") {
+		t.Fatalf("Expected the chosen response to be sent but received %q", head.String())
+	}
+
+	if line, err := reader.ReadString('\n'); err != nil || line != "echo: first\n" {
+		t.Fatalf("Expected the buffered line to be echoed but received %q (%v)", line, err)
+	}
+
+	// Shutdown doesn't wait for the hijacked connection, and leaves it open
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ws.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected the shutdown to complete without waiting for the hijacked connection but received %v", err)
+	}
+
+	if _, err := conn.Write([]byte("second\n")); err != nil {
+		t.Fatalf("Could not write to the hijacked connection: %v", err)
+	}
+
+	if line, err := reader.ReadString('\n'); err != nil || line != "echo: second\n" {
+		t.Fatalf("Expected the hijacked connection to stay open but received %q (%v)", line, err)
+	}
+}
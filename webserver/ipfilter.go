@@ -0,0 +1,47 @@
+package webserver
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// ErrIPNotAllowed is the cause of the error rendered when a request comes from an address that isn't allowed
+var ErrIPNotAllowed = errors.New("the client's IP address is not allowed")
+
+// IPFilterOptions configures the IP filter middleware. Use ParseCIDRs to build the ranges.
+type IPFilterOptions struct {
+	// Allow lists the ranges requests may come from. When it is empty, every address not denied is allowed.
+	Allow []netip.Prefix
+	// Deny lists the ranges requests may not come from, which takes precedence over Allow
+	Deny []netip.Prefix
+}
+
+// NewIPFilterMiddleware creates a middleware that only lets through requests whose client IP is allowed, responding to
+// the rest with a 403. The client IP is resolved through any trusted proxies. Wrap it in ForPath to restrict a single
+// route, e.g. an admin area.
+func NewIPFilterMiddleware(options IPFilterOptions) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(request Request) Response {
+			if !ipAllowed(request.ClientIP(), options) {
+				return RenderError(request, ForbiddenError("Access from this address is not allowed.", ErrIPNotAllowed))
+			}
+
+			return next(request)
+		}
+	}
+}
+
+// ipAllowed returns whether the filter lets the address through. Addresses that can't be parsed are only allowed when
+// there are no ranges to check them against.
+func ipAllowed(clientIP string, options IPFilterOptions) bool {
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return len(options.Allow) == 0 && len(options.Deny) == 0
+	}
+
+	if prefixesContain(options.Deny, addr) {
+		return false
+	}
+
+	return len(options.Allow) == 0 || prefixesContain(options.Allow, addr)
+}
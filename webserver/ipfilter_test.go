@@ -0,0 +1,68 @@
+package webserver
+
+import "testing"
+
+func TestNewIPFilterMiddleware(t *testing.T) {
+	allow, _ := ParseCIDRs("192.0.2.0/24", "2001:db8::/32")
+	deny, _ := ParseCIDRs("192.0.2.13")
+
+	var tests = []struct {
+		name       string
+		options    IPFilterOptions
+		remoteAddr string
+		expected   int
+	}{
+		{"Allowed range", IPFilterOptions{Allow: allow, Deny: deny}, "192.0.2.1:5000", 200},
+		{"Allowed IPv6 range", IPFilterOptions{Allow: allow, Deny: deny}, "[2001:db8::1]:5000", 200},
+		{"Outside allowed ranges", IPFilterOptions{Allow: allow, Deny: deny}, "198.51.100.1:5000", 403},
+		{"Denied within allowed range", IPFilterOptions{Allow: allow, Deny: deny}, "192.0.2.13:5000", 403},
+		{"Deny list only", IPFilterOptions{Deny: deny}, "198.51.100.1:5000", 200},
+		{"Denied by deny list only", IPFilterOptions{Deny: deny}, "192.0.2.13:5000", 403},
+		{"Unknown address", IPFilterOptions{Allow: allow}, "", 403},
+		{"Unknown address without ranges", IPFilterOptions{}, "", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewIPFilterMiddleware(tt.options)(func(request Request) Response {
+				return OkResponse()
+			})
+
+			request := newTestRequest(MethodGet, "/admin", nil)
+			request.remoteAddr = tt.remoteAddr
+
+			if response := handler(request); response.StatusCode() != tt.expected {
+				t.Errorf("Expected %d but received %d", tt.expected, response.StatusCode())
+			}
+		})
+	}
+}
+
+func TestIPFilterMiddlewareForPath(t *testing.T) {
+	allow, _ := ParseCIDRs("10.0.0.0/8")
+
+	handler := chainMiddleware(func(request Request) Response {
+		return OkResponse()
+	}, []Middleware{ForPath(StringPath("/admin"), NewIPFilterMiddleware(IPFilterOptions{Allow: allow}))})
+
+	var tests = []struct {
+		path       string
+		remoteAddr string
+		expected   int
+	}{
+		{"/admin", "10.1.2.3:5000", 200},
+		{"/admin", "192.0.2.1:5000", 403},
+		{"/", "192.0.2.1:5000", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+" from "+tt.remoteAddr, func(t *testing.T) {
+			request := newTestRequest(MethodGet, tt.path, nil)
+			request.remoteAddr = tt.remoteAddr
+
+			if response := handler(request); response.StatusCode() != tt.expected {
+				t.Errorf("Expected %d but received %d", tt.expected, response.StatusCode())
+			}
+		})
+	}
+}
@@ -0,0 +1,387 @@
+package webserver
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrInvalidJWKS is returned when a JWKS file isn't a valid JSON Web Key Set
+var ErrInvalidJWKS = errors.New("the JWKS is in the incorrect format")
+
+// The signing algorithms JWTs can be verified with
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmES256 = "ES256"
+)
+
+// JWKS is a set of keys for verifying JWT signatures, loaded from a JSON Web Key Set
+type JWKS struct {
+	keys []jwk
+}
+
+// jwk is a single verification key. Exactly one of secret, rsaKey and ecdsaKey is set, depending on the key type.
+type jwk struct {
+	id        string
+	algorithm string
+	secret    []byte
+	rsaKey    *rsa.PublicKey
+	ecdsaKey  *ecdsa.PublicKey
+}
+
+// jwkJSON is the JSON representation of a key. Only the members needed for verification are read.
+type jwkJSON struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	K         string `json:"k"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// LoadJWKS reads the JSON Web Key Set file at path
+func LoadJWKS(path string) (*JWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	return ParseJWKS(data)
+}
+
+// ParseJWKS parses a JSON Web Key Set. Symmetric (`oct`), RSA and P-256 EC keys are loaded; keys of other types or
+// that are only for encryption are skipped.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var set struct {
+		Keys []jwkJSON `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJWKS, err)
+	}
+
+	jwks := &JWKS{}
+	for i, raw := range set.Keys {
+		if raw.Use != "" && raw.Use != "sig" {
+			continue
+		}
+
+		key, supported, err := parseJWK(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %d: %v", ErrInvalidJWKS, i, err)
+		}
+
+		if supported {
+			jwks.keys = append(jwks.keys, key)
+		}
+	}
+
+	return jwks, nil
+}
+
+// parseJWK decodes a key, returning false if its type isn't supported
+func parseJWK(raw jwkJSON) (jwk, bool, error) {
+	key := jwk{id: raw.KeyID, algorithm: raw.Algorithm}
+
+	switch raw.KeyType {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(raw.K)
+		if err != nil || len(secret) == 0 {
+			return jwk{}, false, errors.New("invalid symmetric key")
+		}
+
+		key.secret = secret
+	case "RSA":
+		n, nErr := base64.RawURLEncoding.DecodeString(raw.N)
+		e, eErr := base64.RawURLEncoding.DecodeString(raw.E)
+		if nErr != nil || eErr != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return jwk{}, false, errors.New("invalid RSA key")
+		}
+
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		if exponent < 3 || exponent%2 == 0 {
+			return jwk{}, false, errors.New("invalid RSA exponent")
+		}
+
+		key.rsaKey = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}
+	case "EC":
+		if raw.Curve != "P-256" {
+			return jwk{}, false, nil
+		}
+
+		x, xErr := base64.RawURLEncoding.DecodeString(raw.X)
+		y, yErr := base64.RawURLEncoding.DecodeString(raw.Y)
+		if xErr != nil || yErr != nil || len(x) != 32 || len(y) != 32 {
+			return jwk{}, false, errors.New("invalid EC key")
+		}
+
+		// Parsing the uncompressed point checks that it is on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return jwk{}, false, errors.New("invalid EC key")
+		}
+
+		key.ecdsaKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	default:
+		return jwk{}, false, nil
+	}
+
+	return key, true, nil
+}
+
+// Len returns the number of keys in the set
+func (s *JWKS) Len() int {
+	return len(s.keys)
+}
+
+// find returns the key with the given ID that can verify the algorithm. Tokens without a key ID can only be verified
+// when the set holds a single key for the algorithm.
+func (s *JWKS) find(keyID string, algorithm string) (jwk, bool) {
+	var candidates []jwk
+	for _, key := range s.keys {
+		if !key.supports(algorithm) || (keyID != "" && key.id != keyID) {
+			continue
+		}
+
+		candidates = append(candidates, key)
+	}
+
+	if len(candidates) != 1 {
+		return jwk{}, false
+	}
+
+	return candidates[0], true
+}
+
+// supports returns whether the key can verify the algorithm. The key type must match the algorithm, which stops a
+// public RSA key from being used as an HMAC secret.
+func (k jwk) supports(algorithm string) bool {
+	if k.algorithm != "" && k.algorithm != algorithm {
+		return false
+	}
+
+	switch algorithm {
+	case JWTAlgorithmHS256:
+		return k.secret != nil
+	case JWTAlgorithmRS256:
+		return k.rsaKey != nil
+	case JWTAlgorithmES256:
+		return k.ecdsaKey != nil
+	default:
+		return false
+	}
+}
+
+// verify checks the signature over the signing input
+func (k jwk) verify(algorithm string, signingInput string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch algorithm {
+	case JWTAlgorithmHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(signingInput))
+		return hmac.Equal(mac.Sum(nil), signature)
+	case JWTAlgorithmRS256:
+		return rsa.VerifyPKCS1v15(k.rsaKey, crypto.SHA256, digest[:], signature) == nil
+	case JWTAlgorithmES256:
+		// ES256 signatures are the 32 byte r and s values concatenated, rather than ASN.1
+		if len(signature) != 64 {
+			return false
+		}
+
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k.ecdsaKey, digest[:], r, s)
+	default:
+		return false
+	}
+}
+
+// JWTOptions configures JWT verification
+type JWTOptions struct {
+	// Realm is included in the challenge sent with 401 responses
+	Realm string
+	// Keys holds the keys that signatures are verified with
+	Keys *JWKS
+	// Algorithms lists the signing algorithms that are accepted
+	Algorithms []string
+	// Issuer is the `iss` claim tokens must have, or empty to accept any issuer
+	Issuer string
+	// Audience is a value the `aud` claim must contain, or empty to accept any audience
+	Audience string
+	// ClockSkew is how far the `exp` and `nbf` claims may be exceeded to allow for clocks being out of sync
+	ClockSkew time.Duration
+}
+
+// DefaultJWTOptions returns options that accept all the supported algorithms with a minute of clock skew. Keys must
+// be added.
+func DefaultJWTOptions() JWTOptions {
+	return JWTOptions{
+		Algorithms: []string{JWTAlgorithmHS256, JWTAlgorithmRS256, JWTAlgorithmES256},
+		ClockSkew:  time.Minute,
+	}
+}
+
+// JWTValidator is a TokenValidator that verifies JWTs
+type JWTValidator struct {
+	options JWTOptions
+	// now returns the current time, which tests replace
+	now func() time.Time
+}
+
+// NewJWTValidator creates a validator that verifies JWT signatures against the keys and checks the `exp`, `nbf`, `iss`
+// and `aud` claims
+func NewJWTValidator(options JWTOptions) *JWTValidator {
+	return &JWTValidator{options: options, now: time.Now}
+}
+
+// NewJWTMiddleware creates a middleware that requires requests to carry a valid JWT as a bearer token. The principal
+// attached to the request is named after the `sub` claim and carries all of the token's claims.
+func NewJWTMiddleware(options JWTOptions) Middleware {
+	return NewBearerAuthMiddleware(BearerAuthOptions{Realm: options.Realm, Validator: NewJWTValidator(options)})
+}
+
+// ValidateToken verifies the JWT and returns a principal carrying its claims
+func (v *JWTValidator) ValidateToken(ctx context.Context, token string) (Principal, error) {
+	claims, err := v.verify(token)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	return Principal{Name: subject, Scheme: "Bearer", Claims: claims}, nil
+}
+
+// verify checks the token's signature and claims, returning the claims if they are valid
+func (v *JWTValidator) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: the token is not a JWT", ErrInvalidToken)
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: the header is invalid", ErrInvalidToken)
+	}
+
+	if !slices.Contains(v.options.Algorithms, header.Algorithm) {
+		return nil, fmt.Errorf("%w: the %q algorithm is not accepted", ErrInvalidToken, header.Algorithm)
+	}
+
+	if v.options.Keys == nil {
+		return nil, fmt.Errorf("%w: there are no keys to verify the token with", ErrInvalidToken)
+	}
+
+	key, found := v.options.Keys.find(header.KeyID, header.Algorithm)
+	if !found {
+		return nil, fmt.Errorf("%w: no key was found for key ID %q", ErrInvalidToken, header.KeyID)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !key.verify(header.Algorithm, parts[0]+"."+parts[1], signature) {
+		return nil, fmt.Errorf("%w: the signature is invalid", ErrInvalidToken)
+	}
+
+	var claims map[string]any
+	if err := decodeJWTSegment(parts[1], &claims); err != nil || claims == nil {
+		return nil, fmt.Errorf("%w: the claims are invalid", ErrInvalidToken)
+	}
+
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// checkClaims checks the registered claims that restrict when and where the token can be used
+func (v *JWTValidator) checkClaims(claims map[string]any) error {
+	now := v.now()
+
+	if expires, found, err := numericDateClaim(claims, "exp"); err != nil {
+		return err
+	} else if found && !now.Before(expires.Add(v.options.ClockSkew)) {
+		return fmt.Errorf("%w: the token has expired", ErrInvalidToken)
+	}
+
+	if notBefore, found, err := numericDateClaim(claims, "nbf"); err != nil {
+		return err
+	} else if found && now.Add(v.options.ClockSkew).Before(notBefore) {
+		return fmt.Errorf("%w: the token is not valid yet", ErrInvalidToken)
+	}
+
+	if v.options.Issuer != "" {
+		if issuer, _ := claims["iss"].(string); issuer != v.options.Issuer {
+			return fmt.Errorf("%w: the token was issued by %q", ErrInvalidToken, issuer)
+		}
+	}
+
+	if v.options.Audience != "" && !audienceContains(claims["aud"], v.options.Audience) {
+		return fmt.Errorf("%w: the token is not intended for this audience", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// decodeJWTSegment decodes a base64url encoded JSON segment of a token
+func decodeJWTSegment(segment string, value any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
+
+// numericDateClaim returns a claim holding seconds since the Unix epoch as a time
+func numericDateClaim(claims map[string]any, name string) (time.Time, bool, error) {
+	value, found := claims[name]
+	if !found {
+		return time.Time{}, false, nil
+	}
+
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: the %s claim is not a number", ErrInvalidToken, name)
+	}
+
+	return time.UnixMilli(int64(seconds * 1000)), true, nil
+}
+
+// audienceContains returns whether the `aud` claim, which is either a string or an array of strings, contains the
+// audience
+func audienceContains(claim any, audience string) bool {
+	switch value := claim.(type) {
+	case string:
+		return value == audience
+	case []any:
+		for _, item := range value {
+			if item == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
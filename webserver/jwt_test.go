@@ -0,0 +1,275 @@
+package webserver
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testJWTKeys holds locally generated keys along with a JWKS of their public halves
+type testJWTKeys struct {
+	secret     []byte
+	rsaKey     *rsa.PrivateKey
+	ecdsaKey   *ecdsa.PrivateKey
+	jwksJSON   []byte
+	otherECDSA *ecdsa.PrivateKey
+}
+
+func newTestJWTKeys(t *testing.T) testJWTKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Could not generate an RSA key: %v", err)
+	}
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate an ECDSA key: %v", err)
+	}
+
+	otherECDSA, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate an ECDSA key: %v", err)
+	}
+
+	keys := testJWTKeys{secret: []byte("a very secret key that is long enough"), rsaKey: rsaKey, ecdsaKey: ecdsaKey, otherECDSA: otherECDSA}
+
+	encode := base64.RawURLEncoding.EncodeToString
+	ecdsaBytes, err := ecdsaKey.PublicKey.ECDH()
+	if err != nil {
+		t.Fatalf("Could not convert the ECDSA key: %v", err)
+	}
+	point := ecdsaBytes.Bytes()
+
+	jwks := map[string]any{
+		"keys": []map[string]any{
+			{"kty": "oct", "kid": "hmac", "alg": "HS256", "k": encode(keys.secret)},
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(point[1:33]), "y": encode(point[33:])},
+			{"kty": "RSA", "kid": "encryption", "use": "enc", "n": "AQAB", "e": "AQAB"},
+			{"kty": "OKP", "kid": "ed25519", "crv": "Ed25519", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		},
+	}
+
+	keys.jwksJSON, err = json.Marshal(jwks)
+	if err != nil {
+		t.Fatalf("Could not encode the JWKS: %v", err)
+	}
+
+	return keys
+}
+
+// signTestJWT builds a token signed with the key, which is a secret, *rsa.PrivateKey or *ecdsa.PrivateKey
+func signTestJWT(t *testing.T, algorithm string, keyID string, key any, claims map[string]any) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": algorithm, "kid": keyID, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("Could not sign the token: %v", err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("Could not sign the token: %v", err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestParseJWKS(t *testing.T) {
+	keys := newTestJWTKeys(t)
+
+	jwks, err := ParseJWKS(keys.jwksJSON)
+	if err != nil {
+		t.Fatalf("Received an error parsing the JWKS: %v", err)
+	}
+
+	// The encryption key and the unsupported key type are skipped
+	if jwks.Len() != 3 {
+		t.Fatalf("Expected 3 keys but received %d", jwks.Len())
+	}
+
+	var tests = []struct {
+		name string
+		jwks string
+	}{
+		{"Not JSON", "keys"},
+		{"Empty secret", `{"keys":[{"kty":"oct","k":""}]}`},
+		{"Even RSA exponent", `{"keys":[{"kty":"RSA","n":"AQAB","e":"Ag"}]}`},
+		{"Point not on curve", `{"keys":[{"kty":"EC","crv":"P-256","x":"AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA","y":"AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseJWKS([]byte(tt.jwks)); !errors.Is(err, ErrInvalidJWKS) {
+				t.Errorf("Expected ErrInvalidJWKS but received %v", err)
+			}
+		})
+	}
+}
+
+func TestJWTValidator_ValidateToken(t *testing.T) {
+	keys := newTestJWTKeys(t)
+
+	jwks, err := ParseJWKS(keys.jwksJSON)
+	if err != nil {
+		t.Fatalf("Received an error parsing the JWKS: %v", err)
+	}
+
+	options := DefaultJWTOptions()
+	options.Keys = jwks
+	options.Issuer = "https://gateway.example.com"
+	options.Audience = "api"
+	options.ClockSkew = 30 * time.Second
+
+	now := time.Unix(1_700_000_000, 0)
+	validator := NewJWTValidator(options)
+	validator.now = func() time.Time { return now }
+
+	claims := func(overrides map[string]any) map[string]any {
+		claims := map[string]any{
+			"sub": "alice",
+			"iss": "https://gateway.example.com",
+			"aud": []string{"web", "api"},
+			"exp": now.Add(time.Minute).Unix(),
+			"nbf": now.Add(-time.Minute).Unix(),
+		}
+		for name, value := range overrides {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+
+	var tests = []struct {
+		name     string
+		token    string
+		expected bool
+	}{
+		{"HS256", signTestJWT(t, "HS256", "hmac", keys.secret, claims(nil)), true},
+		{"RS256", signTestJWT(t, "RS256", "rsa", keys.rsaKey, claims(nil)), true},
+		{"ES256", signTestJWT(t, "ES256", "ec", keys.ecdsaKey, claims(nil)), true},
+		{"Single key without key ID", signTestJWT(t, "ES256", "", keys.ecdsaKey, claims(nil)), true},
+		{"String audience", signTestJWT(t, "HS256", "hmac", keys.secret, claims(map[string]any{"aud": "api"})), true},
+		{"Expired within skew", signTestJWT(t, "HS256", "hmac", keys.secret, claims(map[string]any{"exp": now.Add(-20 * time.Second).Unix()})), true},
+		{"Not yet valid within skew", signTestJWT(t, "HS256", "hmac", keys.secret, claims(map[string]any{"nbf": now.Add(20 * time.Second).Unix()})), true},
+		{"Without exp and nbf", signTestJWT(t, "HS256", "hmac", keys.secret, claims(map[string]any{"exp": nil, "nbf": nil})), true},
+		{"Expired", signTestJWT(t, "HS256", "hmac", keys.secret, claims(map[string]any{"exp": now.Add(-time.Minute).Unix()})), false},
+		{"Not yet valid", signTestJWT(t, "HS256", "hmac", keys.secret, claims(map[string]any{"nbf": now.Add(time.Minute).Unix()})), false},
+		{"Non-numeric exp", signTestJWT(t, "HS256", "hmac", keys.secret, claims(map[string]any{"exp": "tomorrow"})), false},
+		{"Wrong issuer", signTestJWT(t, "HS256", "hmac", keys.secret, claims(map[string]any{"iss": "https://evil.test"})), false},
+		{"Wrong audience", signTestJWT(t, "HS256", "hmac", keys.secret, claims(map[string]any{"aud": "web"})), false},
+		{"Missing audience", signTestJWT(t, "HS256", "hmac", keys.secret, claims(map[string]any{"aud": nil})), false},
+		{"Wrong secret", signTestJWT(t, "HS256", "hmac", []byte("wrong"), claims(nil)), false},
+		{"Wrong ECDSA key", signTestJWT(t, "ES256", "ec", keys.otherECDSA, claims(nil)), false},
+		{"Unknown key ID", signTestJWT(t, "HS256", "unknown", keys.secret, claims(nil)), false},
+		{"Key of the wrong type", signTestJWT(t, "HS256", "rsa", keys.secret, claims(nil)), false},
+		{"Unsupported algorithm", signTestJWT(t, "none", "hmac", keys.secret, claims(nil)), false},
+		{"Not a JWT", "not-a-jwt", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := validator.ValidateToken(context.Background(), tt.token)
+			if !tt.expected {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("Expected ErrInvalidToken but received %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Received an error validating the token: %v", err)
+			}
+
+			if principal.Name != "alice" || principal.Scheme != "Bearer" || principal.Claims["iss"] != "https://gateway.example.com" {
+				t.Errorf("Expected the principal to carry the claims but received %+v", principal)
+			}
+		})
+	}
+}
+
+func TestJWTValidator_RestrictedAlgorithms(t *testing.T) {
+	keys := newTestJWTKeys(t)
+
+	jwks, err := ParseJWKS(keys.jwksJSON)
+	if err != nil {
+		t.Fatalf("Received an error parsing the JWKS: %v", err)
+	}
+
+	validator := NewJWTValidator(JWTOptions{Keys: jwks, Algorithms: []string{JWTAlgorithmRS256}})
+
+	token := signTestJWT(t, "HS256", "hmac", keys.secret, map[string]any{"sub": "alice"})
+	if _, err := validator.ValidateToken(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Expected HS256 to be rejected but received %v", err)
+	}
+}
+
+func TestNewJWTMiddleware(t *testing.T) {
+	keys := newTestJWTKeys(t)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, keys.jwksJSON, 0o600); err != nil {
+		t.Fatalf("Could not write the JWKS file: %v", err)
+	}
+
+	jwks, err := LoadJWKS(path)
+	if err != nil {
+		t.Fatalf("Received an error loading the JWKS: %v", err)
+	}
+
+	options := DefaultJWTOptions()
+	options.Realm = "api"
+	options.Keys = jwks
+
+	handler := NewJWTMiddleware(options)(func(request Request) Response {
+		principal, _ := PrincipalFromRequest(request)
+		return OkResponseWithBody([]byte(principal.Claims["role"].(string)))
+	})
+
+	token := signTestJWT(t, "RS256", "rsa", keys.rsaKey, map[string]any{"sub": "alice", "role": "admin", "exp": time.Now().Add(time.Hour).Unix()})
+	response := handler(newTestRequest(MethodGet, "/api/person", map[string]string{"Authorization": "Bearer " + token}))
+	if response.StatusCode() != 200 || string(response.Body()) != "admin" {
+		t.Fatalf("Expected the claims to reach the handler but received %d %q", response.StatusCode(), string(response.Body()))
+	}
+
+	expired := signTestJWT(t, "RS256", "rsa", keys.rsaKey, map[string]any{"sub": "alice", "exp": time.Now().Add(-time.Hour).Unix()})
+	response = handler(newTestRequest(MethodGet, "/api/person", map[string]string{"Authorization": "Bearer " + expired}))
+	if challenge, _ := response.Headers().GetHeader("WWW-Authenticate"); response.StatusCode() != 401 || challenge != `Bearer realm="api", error="invalid_token"` {
+		t.Fatalf("Expected a 401 with an invalid_token challenge but received %d %q", response.StatusCode(), challenge)
+	}
+}
@@ -0,0 +1,210 @@
+package webserver
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// ErrServerBusy is the cause of the error rendered when a request is turned away because the web server is at its
+// limit
+var ErrServerBusy = errors.New("the web server is at its limit")
+
+// OverflowPolicy decides what happens to connections and requests that arrive when the web server is at its limit
+type OverflowPolicy int
+
+const (
+	// OverflowQueue waits up to the QueueTimeout for a slot to free up before responding with a 503
+	OverflowQueue OverflowPolicy = iota
+	// OverflowReject immediately responds with a 503
+	OverflowReject
+	// OverflowPause waits as long as it takes for a slot to free up. For connections, the web server stops accepting
+	// new ones, leaving them in the operating system's backlog.
+	OverflowPause
+)
+
+// LimitOptions configures how many connections and requests the web server handles at once
+type LimitOptions struct {
+	// MaxConnections is the number of connections that may be open at once, or zero for no limit
+	MaxConnections int
+	// MaxInFlightRequests is the number of requests whose handlers may run at once, or zero for no limit
+	MaxInFlightRequests int
+	// Overflow decides what happens to connections and requests over the limit
+	Overflow OverflowPolicy
+	// QueueTimeout is how long a connection or request waits for a slot when using OverflowQueue
+	QueueTimeout time.Duration
+	// RetryAfter is sent in the Retry-After header of 503 responses, rounded up to whole seconds
+	RetryAfter time.Duration
+}
+
+// DefaultLimitOptions returns limit options that queue connections and requests over the limit for up to 5 seconds. No
+// limits are set.
+func DefaultLimitOptions() LimitOptions {
+	return LimitOptions{
+		Overflow:     OverflowQueue,
+		QueueTimeout: 5 * time.Second,
+		RetryAfter:   time.Second,
+	}
+}
+
+// limits holds the slots handed out to connections and requests
+type limits struct {
+	options LimitOptions
+	// connections and requests are semaphores, which are nil when there is no limit
+	connections chan struct{}
+	requests    chan struct{}
+}
+
+// newLimits creates the slots for the given options
+func newLimits(options LimitOptions) *limits {
+	l := &limits{options: options}
+
+	if options.MaxConnections > 0 {
+		l.connections = make(chan struct{}, options.MaxConnections)
+	}
+
+	if options.MaxInFlightRequests > 0 {
+		l.requests = make(chan struct{}, options.MaxInFlightRequests)
+	}
+
+	return l
+}
+
+// acquire takes a slot, waiting according to the overflow policy. It returns false if no slot could be taken, or if
+// cancel is closed while waiting.
+func (l *limits) acquire(slots chan struct{}, cancel <-chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	switch l.options.Overflow {
+	case OverflowReject:
+		return false
+	case OverflowQueue:
+		timer := time.NewTimer(l.options.QueueTimeout)
+		defer timer.Stop()
+
+		select {
+		case slots <- struct{}{}:
+			return true
+		case <-timer.C:
+			return false
+		case <-cancel:
+			return false
+		}
+	default:
+		select {
+		case slots <- struct{}{}:
+			return true
+		case <-cancel:
+			return false
+		}
+	}
+}
+
+// release gives back a slot taken by acquire
+func (l *limits) release(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
+
+// retryAfter returns the value of the Retry-After header for 503 responses
+func (l *limits) retryAfter() string {
+	seconds := (l.options.RetryAfter + time.Second - 1) / time.Second
+	return strconv.Itoa(int(max(seconds, 1)))
+}
+
+// SetLimits sets how many connections and requests the web server handles at once. It must be called before the web
+// server starts serving.
+func (w *WebServer) SetLimits(options LimitOptions) {
+	w.limits = newLimits(options)
+}
+
+// serveConnection handles a connection that has just been accepted once it has a connection slot, rejecting it if it
+// can't get one
+func (w *WebServer) serveConnection(conn net.Conn, hasSlot bool) {
+	defer w.state.connections.Done()
+
+	if !hasSlot && !w.limits.acquire(w.limits.connections, w.state.closing) {
+		w.logger.Warn("Rejecting connection as the web server is at its connection limit", "remote_addr", conn.RemoteAddr().String())
+		w.rejectConnection(conn)
+		return
+	}
+	defer w.limits.release(w.limits.connections)
+
+	w.handle(conn)
+}
+
+// rejectConnection responds to a connection with a 503 without reading its request
+func (w *WebServer) rejectConnection(conn net.Conn) {
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+
+	response := NewResponseWithBody(503, []byte(statusText(503)))
+	response.Headers().SetHeader("Content-Type", "text/plain; charset=utf-8")
+	response.Headers().SetHeader("Retry-After", w.limits.retryAfter())
+	if err := writeResponse(conn, response); err != nil {
+		return
+	}
+
+	// Read the unread request before closing, as closing with unread data resets the connection and the client may
+	// lose the response
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.CloseWrite()
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(conn, 64<<10))
+}
+
+// busyResponse builds the response for a request turned away because the web server is at its request limit
+func (w *WebServer) busyResponse(request Request) Response {
+	response := RenderError(request, NewHTTPError(503, "The server is too busy to handle the request.", ErrServerBusy))
+	response.Headers().SetHeader("Retry-After", w.limits.retryAfter())
+
+	return response
+}
+
+// acceptBackoff is how long to wait before accepting again after a temporary error, doubling up to a second
+type acceptBackoff time.Duration
+
+// next returns the delay to wait after another temporary error
+func (b *acceptBackoff) next() time.Duration {
+	if *b == 0 {
+		*b = acceptBackoff(5 * time.Millisecond)
+	} else {
+		*b = min(*b*2, acceptBackoff(time.Second))
+	}
+
+	return time.Duration(*b)
+}
+
+// reset starts the backoff again after a successful accept
+func (b *acceptBackoff) reset() {
+	*b = 0
+}
+
+// isTemporaryAcceptError returns whether an error from Accept is likely to go away by itself, e.g. running out of file
+// descriptors, so the web server should keep accepting rather than stop
+func isTemporaryAcceptError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.EMFILE) ||
+		errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ENOBUFS) ||
+		errors.Is(err, syscall.ENOMEM) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,281 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLimits_Acquire(t *testing.T) {
+	var tests = []struct {
+		name     string
+		overflow OverflowPolicy
+		release  bool
+		cancel   bool
+		expected bool
+	}{
+		{"Reject when full", OverflowReject, true, false, false},
+		{"Queue until released", OverflowQueue, true, false, true},
+		{"Queue until timeout", OverflowQueue, false, false, false},
+		{"Pause until released", OverflowPause, true, false, true},
+		{"Pause until cancelled", OverflowPause, false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimits(LimitOptions{MaxConnections: 1, Overflow: tt.overflow, QueueTimeout: 50 * time.Millisecond})
+			if !l.acquire(l.connections, nil) {
+				t.Fatalf("Expected the first slot to be free")
+			}
+
+			cancel := make(chan struct{})
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				if tt.release {
+					l.release(l.connections)
+				}
+				if tt.cancel {
+					close(cancel)
+				}
+			}()
+
+			if acquired := l.acquire(l.connections, cancel); acquired != tt.expected {
+				t.Fatalf("Expected acquire to return %v but received %v", tt.expected, acquired)
+			}
+		})
+	}
+}
+
+func TestLimits_RetryAfter(t *testing.T) {
+	var tests = []struct {
+		retryAfter time.Duration
+		expected   string
+	}{
+		{0, "1"},
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+		{time.Minute, "60"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.retryAfter.String(), func(t *testing.T) {
+			l := newLimits(LimitOptions{RetryAfter: tt.retryAfter})
+			if l.retryAfter() != tt.expected {
+				t.Errorf("Expected %s but received %s", tt.expected, l.retryAfter())
+			}
+		})
+	}
+}
+
+func TestAcceptBackoff_Next(t *testing.T) {
+	backoff := acceptBackoff(0)
+
+	expected := []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond}
+	for _, delay := range expected {
+		if next := backoff.next(); next != delay {
+			t.Fatalf("Expected a delay of %v but received %v", delay, next)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		backoff.next()
+	}
+
+	if next := backoff.next(); next != time.Second {
+		t.Fatalf("Expected the delay to be capped at a second but received %v", next)
+	}
+
+	backoff.reset()
+	if next := backoff.next(); next != 5*time.Millisecond {
+		t.Fatalf("Expected the delay to start again after a reset but received %v", next)
+	}
+}
+
+func TestIsTemporaryAcceptError(t *testing.T) {
+	var tests = []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"Too many open files", &net.OpError{Op: "accept", Err: syscall.EMFILE}, true},
+		{"Connection aborted", fmt.Errorf("accept: %w", syscall.ECONNABORTED), true},
+		{"Closed listener", net.ErrClosed, false},
+		{"Other error", errors.New("broken"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if isTemporaryAcceptError(tt.err) != tt.expected {
+				t.Errorf("Expected %v for %v", tt.expected, tt.err)
+			}
+		})
+	}
+}
+
+// failingListener returns temporary errors from Accept a number of times, then blocks until it is closed
+type failingListener struct {
+	net.Listener
+	failures atomic.Int32
+	closed   chan struct{}
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if l.failures.Add(-1) >= 0 {
+		return nil, &net.OpError{Op: "accept", Err: syscall.EMFILE}
+	}
+
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *failingListener) Close() error {
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+
+	return nil
+}
+
+func TestWebServer_ServeRetriesTemporaryErrors(t *testing.T) {
+	ln := &failingListener{closed: make(chan struct{})}
+	ln.failures.Store(3)
+
+	ws := NewWebServer()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- ws.Serve(ln)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	select {
+	case err := <-served:
+		t.Fatalf("Expected Serve to keep running after temporary errors but it returned %v", err)
+	default:
+	}
+
+	if err := ws.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Received an error shutting down: %v", err)
+	}
+
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Expected ErrServerClosed but received %v", err)
+	}
+}
+
+func TestWebServer_MaxConnectionsReject(t *testing.T) {
+	ws := NewWebServer()
+	ws.SetLimits(LimitOptions{MaxConnections: 1, Overflow: OverflowReject, RetryAfter: 3 * time.Second})
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/"), func(request Request) Response {
+		return OkResponse()
+	}))
+
+	addr, _ := startTestServer(t, &ws)
+	defer ws.Shutdown(context.Background())
+
+	// Hold the only slot open by connecting without sending a request
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	rawResponse := sendTestRequest(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 503 Service Unavailable\r\n") || !strings.Contains(rawResponse, "Retry-After: 3\r\n") {
+		t.Fatalf("Expected a 503 with Retry-After but received %q", rawResponse)
+	}
+
+	_ = idle.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	rawResponse = sendTestRequest(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 200 OK\r\n") {
+		t.Fatalf("Expected a 200 once the slot was freed but received %q", rawResponse)
+	}
+}
+
+func TestWebServer_MaxConnectionsQueue(t *testing.T) {
+	ws := NewWebServer()
+	ws.SetLimits(LimitOptions{MaxConnections: 1, Overflow: OverflowQueue, QueueTimeout: 5 * time.Second})
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/"), func(request Request) Response {
+		return OkResponse()
+	}))
+
+	addr, _ := startTestServer(t, &ws)
+	defer ws.Shutdown(context.Background())
+
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = idle.Close()
+	}()
+
+	rawResponse := sendTestRequest(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 200 OK\r\n") {
+		t.Fatalf("Expected the queued connection to be served but received %q", rawResponse)
+	}
+}
+
+func TestWebServer_MaxInFlightRequestsReject(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	ws := NewWebServer()
+	ws.SetLimits(LimitOptions{MaxInFlightRequests: 1, Overflow: OverflowReject, RetryAfter: time.Second})
+	metrics := ws.EnableMetrics("/metrics")
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/slow"), func(request Request) Response {
+		close(started)
+		<-release
+		return OkResponse()
+	}))
+	ws.Use(func(next HandlerFunc) HandlerFunc {
+		return func(request Request) Response {
+			response := next(request)
+			response.Headers().SetHeader("X-Middleware", "seen")
+			return response
+		}
+	})
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go ws.handle(server)
+	go func() {
+		_, _ = client.Write([]byte("GET /slow HTTP/1.1\r\n\r\n"))
+	}()
+	<-started
+
+	rawResponse := serveTestRequest(t, &ws, "GET /slow HTTP/1.1\r\nAccept: application/json\r\n\r\n")
+	inFlight := metrics.inFlight.Load()
+	close(release)
+
+	if inFlight != 1 {
+		t.Errorf("Expected only the running request to be in flight but there were %d", inFlight)
+	}
+
+	if !strings.Contains(rawResponse, "X-Middleware: seen\r\n") {
+		t.Errorf("Expected the rejection to go through the middleware but received %q", rawResponse)
+	}
+
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 503 Service Unavailable\r\n") || !strings.Contains(rawResponse, "Retry-After: 1\r\n") {
+		t.Fatalf("Expected a 503 with Retry-After but received %q", rawResponse)
+	}
+
+	if !strings.Contains(rawResponse, "\"status\":503") {
+		t.Fatalf("Expected the error handler to render the 503 but received %q", rawResponse)
+	}
+}
@@ -0,0 +1,210 @@
+package webserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrWriterClosed is returned when writing to a writer that has been closed
+var ErrWriterClosed = errors.New("the writer has been closed")
+
+// AsyncWriter buffers writes in memory and writes them to the underlying writer on a background goroutine, so that
+// slow disks don't hold up requests. Writes block once the buffer is full. Close must be called to flush the buffer.
+type AsyncWriter struct {
+	writer        *bufio.Writer
+	lines         chan []byte
+	flushInterval time.Duration
+	done          chan struct{}
+
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// NewAsyncWriter creates an AsyncWriter that holds up to bufferSize pending writes and flushes the underlying writer at
+// least every flushInterval, which defaults to a second
+func NewAsyncWriter(writer io.Writer, bufferSize int, flushInterval time.Duration) *AsyncWriter {
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+
+	asyncWriter := &AsyncWriter{
+		writer:        bufio.NewWriter(writer),
+		lines:         make(chan []byte, bufferSize),
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+	}
+
+	go asyncWriter.run()
+
+	return asyncWriter
+}
+
+// Write queues p to be written to the underlying writer
+func (w *AsyncWriter) Write(p []byte) (int, error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+
+	// The caller may reuse p once we return, so queue a copy
+	w.lines <- append([]byte(nil), p...)
+
+	return len(p), nil
+}
+
+// Close writes everything that is still queued, flushes the underlying writer and stops the background goroutine
+func (w *AsyncWriter) Close() error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.lines)
+	w.mutex.Unlock()
+
+	<-w.done
+	return nil
+}
+
+// run writes queued lines until the writer is closed
+func (w *AsyncWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case line, ok := <-w.lines:
+			if !ok {
+				_ = w.writer.Flush()
+				return
+			}
+
+			_, _ = w.writer.Write(line)
+		case <-ticker.C:
+			_ = w.writer.Flush()
+		}
+	}
+}
+
+// RotatingFileWriter writes to a file, rotating it once it reaches a maximum size. The current file is renamed to
+// `<path>.1`, the previous `<path>.1` to `<path>.2` and so on, keeping up to MaxBackups old files.
+type RotatingFileWriter struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// NewRotatingFileWriter opens the file at path for appending, rotating it once it grows past maxBytes and keeping up to
+// maxBackups rotated files
+func NewRotatingFileWriter(path string, maxBytes int64, maxBackups int) (*RotatingFileWriter, error) {
+	writer := &RotatingFileWriter{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+
+	if err := writer.open(); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// Write writes p to the current file, rotating first if p would take it over the maximum size
+func (w *RotatingFileWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return 0, ErrWriterClosed
+	}
+
+	if w.size > 0 && w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+// Close closes the current file
+func (w *RotatingFileWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+
+	return err
+}
+
+// open opens the file at the writer's path for appending. The mutex must be held or the writer not yet shared.
+func (w *RotatingFileWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	w.file = file
+	w.size = fileInfo.Size()
+
+	return nil
+}
+
+// rotate shifts the backups along, moves the current file to `<path>.1` and opens a new file. The mutex must be held.
+func (w *RotatingFileWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	w.file = nil
+
+	if w.maxBackups <= 0 {
+		if err := os.Remove(w.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+
+		return w.open()
+	}
+
+	for i := w.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	}
+
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		// Keep appending to the current file rather than losing lines
+		return errors.Join(fmt.Errorf("failed to rotate log file: %w", err), w.open())
+	}
+
+	return w.open()
+}
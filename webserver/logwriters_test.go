@@ -0,0 +1,96 @@
+package webserver
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAsyncWriter(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := NewAsyncWriter(&buffer, 10, time.Hour)
+
+	line := []byte("first\n")
+	if _, err := writer.Write(line); err != nil {
+		t.Fatalf("Received an error writing: %v", err)
+	}
+
+	// The writer must not hold on to the caller's slice
+	copy(line, "XXXXX\n")
+
+	if _, err := writer.Write([]byte("second\n")); err != nil {
+		t.Fatalf("Received an error writing: %v", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Received an error closing: %v", err)
+	}
+
+	if buffer.String() != "first\nsecond\n" {
+		t.Fatalf("Expected both lines to be flushed on close but received %q", buffer.String())
+	}
+
+	if _, err := writer.Write([]byte("third\n")); !errors.Is(err, ErrWriterClosed) {
+		t.Fatalf("Expected an ErrWriterClosed error writing after close but received %v", err)
+	}
+}
+
+func TestRotatingFileWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	writer, err := NewRotatingFileWriter(path, 10, 2)
+	if err != nil {
+		t.Fatalf("Received an error creating the writer: %v", err)
+	}
+	defer writer.Close()
+
+	for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		if _, err := writer.Write([]byte(line)); err != nil {
+			t.Fatalf("Received an error writing: %v", err)
+		}
+	}
+
+	expected := map[string]string{
+		path:        "dddddd\n",
+		path + ".1": "cccccc\n",
+		path + ".2": "bbbbbb\n",
+	}
+	for name, contents := range expected {
+		fileContents, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Could not read %s: %v", name, err)
+		}
+
+		if string(fileContents) != contents {
+			t.Errorf("Expected %s to contain %q but it contained %q", name, contents, string(fileContents))
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected only 2 backups to be kept")
+	}
+}
+
+func TestRotatingFileWriterAppendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(path, []byte("existing\n"), 0o644); err != nil {
+		t.Fatalf("Could not create the log file: %v", err)
+	}
+
+	writer, err := NewRotatingFileWriter(path, 12, 1)
+	if err != nil {
+		t.Fatalf("Received an error creating the writer: %v", err)
+	}
+	defer writer.Close()
+
+	if _, err := writer.Write([]byte("new\n")); err != nil {
+		t.Fatalf("Received an error writing: %v", err)
+	}
+
+	if fileContents, _ := os.ReadFile(path + ".1"); string(fileContents) != "existing\n" {
+		t.Fatalf("Expected the existing contents to count towards the size and be rotated but received %q", string(fileContents))
+	}
+}
@@ -0,0 +1,256 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the request latency histogram buckets
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics collects counters describing the traffic the web server handles and exposes them in the Prometheus text
+// exposition format
+type Metrics struct {
+	buckets []float64
+
+	mutex       sync.Mutex
+	requests    map[requestMetricKey]uint64
+	latencies   map[latencyMetricKey]*histogram
+	parseErrors map[string]uint64
+
+	inFlight         atomic.Int64
+	openConnections  atomic.Int64
+	connectionsTotal atomic.Uint64
+	bytesReceived    atomic.Uint64
+	bytesSent        atomic.Uint64
+}
+
+// requestMetricKey holds the labels requests are counted by
+type requestMetricKey struct {
+	route  string
+	method Method
+	status int
+}
+
+// latencyMetricKey holds the labels request latencies are recorded by
+type latencyMetricKey struct {
+	route  string
+	method Method
+}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	// counts holds the number of observations in each bucket, plus a final `+Inf` bucket
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewMetrics creates a metrics collector that uses the DefaultLatencyBuckets
+func NewMetrics() *Metrics {
+	return NewMetricsWithBuckets(DefaultLatencyBuckets)
+}
+
+// NewMetricsWithBuckets creates a metrics collector whose latency histogram has buckets with the given upper bounds, in
+// seconds
+func NewMetricsWithBuckets(buckets []float64) *Metrics {
+	sortedBuckets := append([]float64(nil), buckets...)
+	sort.Float64s(sortedBuckets)
+
+	return &Metrics{
+		buckets:     sortedBuckets,
+		requests:    make(map[requestMetricKey]uint64),
+		latencies:   make(map[latencyMetricKey]*histogram),
+		parseErrors: make(map[string]uint64),
+	}
+}
+
+// NewMetricsHandler creates a handler that responds with the metrics in the Prometheus text exposition format
+func NewMetricsHandler(path Path, metrics *Metrics) *Handler {
+	return NewHandlerWithError(MethodGet, path, func(request Request) (Response, error) {
+		var builder strings.Builder
+		if _, err := metrics.WriteTo(&builder); err != nil {
+			return nil, err
+		}
+
+		response := OkResponseWithBody([]byte(builder.String()))
+		response.Headers().SetHeader("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		return response, nil
+	})
+}
+
+// connectionOpened records a newly accepted connection
+func (m *Metrics) connectionOpened() {
+	m.openConnections.Add(1)
+	m.connectionsTotal.Add(1)
+}
+
+// connectionClosed records a closed connection along with the bytes that went through it
+func (m *Metrics) connectionClosed(conn *connection) {
+	m.openConnections.Add(-1)
+	m.bytesReceived.Add(uint64(conn.bytesRead.Load()))
+	m.bytesSent.Add(uint64(conn.bytesWritten.Load()))
+}
+
+// requestStarted records a request's handler starting to run once it has a request slot
+func (m *Metrics) requestStarted() {
+	m.inFlight.Add(1)
+}
+
+// requestEnded records a request's handler returning
+func (m *Metrics) requestEnded() {
+	m.inFlight.Add(-1)
+}
+
+// requestFinished records a request that was responded to along with how long it took, including requests turned away
+// because the web server was busy
+func (m *Metrics) requestFinished(route string, method Method, status int, latency time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.requests[requestMetricKey{route: route, method: method, status: status}]++
+
+	key := latencyMetricKey{route: route, method: method}
+	latencyHistogram, found := m.latencies[key]
+	if !found {
+		latencyHistogram = &histogram{counts: make([]uint64, len(m.buckets)+1)}
+		m.latencies[key] = latencyHistogram
+	}
+
+	seconds := latency.Seconds()
+	bucket := sort.SearchFloat64s(m.buckets, seconds)
+	latencyHistogram.counts[bucket]++
+	latencyHistogram.sum += seconds
+	latencyHistogram.count++
+}
+
+// parseFailed records a request that could not be parsed
+func (m *Metrics) parseFailed(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.parseErrors[parseErrorKind(err)]++
+}
+
+// parseErrorKind returns the label for the kind of parse error
+func parseErrorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidRequest):
+		return "invalid_request"
+	case errors.Is(err, ErrInvalidMethod):
+		return "invalid_method"
+	case errors.Is(err, ErrInvalidHeader):
+		return "invalid_header"
+	case errors.Is(err, ErrInvalidBody):
+		return "invalid_body"
+	case errors.Is(err, ErrUnsupportedBody):
+		return "unsupported_body"
+	default:
+		return "other"
+	}
+}
+
+// WriteTo writes the metrics to the writer in the Prometheus text exposition format
+func (m *Metrics) WriteTo(writer io.Writer) (int64, error) {
+	var builder strings.Builder
+
+	m.mutex.Lock()
+
+	writeMetricHeader(&builder, "webserver_requests_total", "counter", "Total number of requests handled.")
+	requestKeys := make([]requestMetricKey, 0, len(m.requests))
+	for key := range m.requests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	for _, key := range requestKeys {
+		fmt.Fprintf(&builder, "webserver_requests_total{route=\"%s\",method=\"%s\",status=\"%d\"} %d\n",
+			escapeLabelValue(key.route), escapeLabelValue(string(key.method)), key.status, m.requests[key])
+	}
+
+	writeMetricHeader(&builder, "webserver_request_duration_seconds", "histogram", "Time taken to handle requests in seconds.")
+	latencyKeys := make([]latencyMetricKey, 0, len(m.latencies))
+	for key := range m.latencies {
+		latencyKeys = append(latencyKeys, key)
+	}
+	sort.Slice(latencyKeys, func(i, j int) bool {
+		a, b := latencyKeys[i], latencyKeys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		return a.method < b.method
+	})
+	for _, key := range latencyKeys {
+		labels := fmt.Sprintf("route=\"%s\",method=\"%s\"", escapeLabelValue(key.route), escapeLabelValue(string(key.method)))
+		latencyHistogram := m.latencies[key]
+
+		cumulativeCount := uint64(0)
+		for i, bound := range m.buckets {
+			cumulativeCount += latencyHistogram.counts[i]
+			fmt.Fprintf(&builder, "webserver_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound), cumulativeCount)
+		}
+		fmt.Fprintf(&builder, "webserver_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, latencyHistogram.count)
+		fmt.Fprintf(&builder, "webserver_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(latencyHistogram.sum))
+		fmt.Fprintf(&builder, "webserver_request_duration_seconds_count{%s} %d\n", labels, latencyHistogram.count)
+	}
+
+	writeMetricHeader(&builder, "webserver_parse_errors_total", "counter", "Total number of requests that could not be parsed.")
+	kinds := make([]string, 0, len(m.parseErrors))
+	for kind := range m.parseErrors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(&builder, "webserver_parse_errors_total{kind=\"%s\"} %d\n", escapeLabelValue(kind), m.parseErrors[kind])
+	}
+
+	m.mutex.Unlock()
+
+	writeMetricHeader(&builder, "webserver_requests_in_flight", "gauge", "Number of requests currently being handled.")
+	fmt.Fprintf(&builder, "webserver_requests_in_flight %d\n", m.inFlight.Load())
+
+	writeMetricHeader(&builder, "webserver_open_connections", "gauge", "Number of connections currently open.")
+	fmt.Fprintf(&builder, "webserver_open_connections %d\n", m.openConnections.Load())
+
+	writeMetricHeader(&builder, "webserver_connections_total", "counter", "Total number of connections accepted.")
+	fmt.Fprintf(&builder, "webserver_connections_total %d\n", m.connectionsTotal.Load())
+
+	writeMetricHeader(&builder, "webserver_received_bytes_total", "counter", "Total number of bytes received from clients.")
+	fmt.Fprintf(&builder, "webserver_received_bytes_total %d\n", m.bytesReceived.Load())
+
+	writeMetricHeader(&builder, "webserver_sent_bytes_total", "counter", "Total number of bytes sent to clients.")
+	fmt.Fprintf(&builder, "webserver_sent_bytes_total %d\n", m.bytesSent.Load())
+
+	n, err := io.WriteString(writer, builder.String())
+	return int64(n), err
+}
+
+// writeMetricHeader writes the HELP and TYPE lines that come before a metric's samples
+func writeMetricHeader(builder *strings.Builder, name string, metricType string, help string) {
+	fmt.Fprintf(builder, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// escapeLabelValue escapes a label value as required by the text exposition format
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
+
+// formatFloat formats a float in the shortest form that round trips
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
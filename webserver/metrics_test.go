@@ -0,0 +1,91 @@
+package webserver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics_WriteTo(t *testing.T) {
+	metrics := NewMetricsWithBuckets([]float64{0.1, 0.01})
+
+	metrics.connectionOpened()
+	metrics.requestStarted()
+	metrics.requestEnded()
+	metrics.requestFinished("/api/person", MethodGet, 200, 5*time.Millisecond)
+	metrics.requestStarted()
+	metrics.requestEnded()
+	metrics.requestFinished("/api/person", MethodGet, 200, 50*time.Millisecond)
+	metrics.requestStarted()
+	metrics.requestEnded()
+	metrics.requestFinished("/api/\"quoted\"", MethodPost, 500, time.Second)
+	metrics.requestStarted()
+	metrics.parseFailed(ErrInvalidHeader)
+	metrics.parseFailed(errors.New("something else"))
+
+	var builder strings.Builder
+	if _, err := metrics.WriteTo(&builder); err != nil {
+		t.Fatalf("Received an error writing the metrics: %v", err)
+	}
+	output := builder.String()
+
+	expectedLines := []string{
+		"# TYPE webserver_requests_total counter",
+		"webserver_requests_total{route=\"/api/person\",method=\"GET\",status=\"200\"} 2",
+		"webserver_requests_total{route=\"/api/\\\"quoted\\\"\",method=\"POST\",status=\"500\"} 1",
+		"# TYPE webserver_request_duration_seconds histogram",
+		"webserver_request_duration_seconds_bucket{route=\"/api/person\",method=\"GET\",le=\"0.01\"} 1",
+		"webserver_request_duration_seconds_bucket{route=\"/api/person\",method=\"GET\",le=\"0.1\"} 2",
+		"webserver_request_duration_seconds_bucket{route=\"/api/person\",method=\"GET\",le=\"+Inf\"} 2",
+		"webserver_request_duration_seconds_sum{route=\"/api/person\",method=\"GET\"} 0.055",
+		"webserver_request_duration_seconds_count{route=\"/api/person\",method=\"GET\"} 2",
+		"webserver_request_duration_seconds_bucket{route=\"/api/\\\"quoted\\\"\",method=\"POST\",le=\"0.1\"} 0",
+		"webserver_request_duration_seconds_bucket{route=\"/api/\\\"quoted\\\"\",method=\"POST\",le=\"+Inf\"} 1",
+		"webserver_parse_errors_total{kind=\"invalid_header\"} 1",
+		"webserver_parse_errors_total{kind=\"other\"} 1",
+		"webserver_requests_in_flight 1",
+		"webserver_open_connections 1",
+		"webserver_connections_total 1",
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("Expected the metrics to contain %q but received:\n%s", line, output)
+		}
+	}
+}
+
+func TestWebServer_EnableMetrics(t *testing.T) {
+	ws := NewWebServer()
+	metrics := ws.EnableMetrics("/metrics")
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/api/person"), func(request Request) Response {
+		return OkResponseWithBody([]byte("John Doe"))
+	}))
+
+	serveTestRequest(t, &ws, "GET /api/person HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	serveTestRequest(t, &ws, "GET /missing HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	serveTestRequest(t, &ws, "GET /broken HTTP/1.1\r\nBroken-Header\r\n\r\n")
+
+	rawResponse := serveTestRequest(t, &ws, "GET /metrics HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 200 OK\r\n") || !strings.Contains(rawResponse, "text/plain; version=0.0.4") {
+		t.Fatalf("Expected a 200 response in the text exposition format but received %q", rawResponse)
+	}
+
+	expectedLines := []string{
+		"webserver_requests_total{route=\"/api/person\",method=\"GET\",status=\"200\"} 1",
+		"webserver_requests_total{route=\"not_found\",method=\"GET\",status=\"404\"} 1",
+		"webserver_parse_errors_total{kind=\"invalid_header\"} 1",
+		"webserver_connections_total 4",
+		"webserver_requests_in_flight 1",
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(rawResponse, line+"\n") {
+			t.Errorf("Expected the metrics to contain %q but received:\n%s", line, rawResponse)
+		}
+	}
+
+	// The metrics request's own bytes are only counted once its connection closes
+	if metrics.bytesReceived.Load() == 0 || metrics.bytesSent.Load() == 0 {
+		t.Errorf("Expected bytes to have been counted in both directions")
+	}
+}
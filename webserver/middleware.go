@@ -0,0 +1,31 @@
+package webserver
+
+// Middleware wraps a HandlerFunc so that it can run code before and after the handler, change the request and
+// response, or answer the request itself without calling the handler
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// chainMiddleware wraps the handler in the given middleware. The first middleware is the outermost one, so it runs
+// first on the way in and last on the way out.
+func chainMiddleware(handler HandlerFunc, middleware []Middleware) HandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+// ForPath applies the middleware only to requests whose path matches, passing every other request straight on to the
+// handler
+func ForPath(path Path, middleware Middleware) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		wrapped := middleware(next)
+
+		return func(request Request) Response {
+			if path.Matches(request.Path()) {
+				return wrapped(request)
+			}
+
+			return next(request)
+		}
+	}
+}
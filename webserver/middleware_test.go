@@ -0,0 +1,73 @@
+package webserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChainMiddleware(t *testing.T) {
+	calls := make([]string, 0)
+
+	recordingMiddleware := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(request Request) Response {
+				calls = append(calls, name+" before")
+				response := next(request)
+				calls = append(calls, name+" after")
+				return response
+			}
+		}
+	}
+
+	handler := chainMiddleware(func(request Request) Response {
+		calls = append(calls, "handler")
+		return OkResponse()
+	}, []Middleware{recordingMiddleware("first"), recordingMiddleware("second")})
+
+	handler(newTestRequest(MethodGet, "/", nil))
+
+	expected := "first before, second before, handler, second after, first after"
+	if strings.Join(calls, ", ") != expected {
+		t.Fatalf("Expected calls to be %q but received %q", expected, strings.Join(calls, ", "))
+	}
+}
+
+func TestChainMiddlewareWithoutMiddleware(t *testing.T) {
+	handler := chainMiddleware(func(request Request) Response {
+		return NotFoundResponse()
+	}, nil)
+
+	if response := handler(newTestRequest(MethodGet, "/", nil)); response.StatusCode() != 404 {
+		t.Fatalf("Expected the handler to be called directly but received %d", response.StatusCode())
+	}
+}
+
+func TestForPath(t *testing.T) {
+	teapot := func(next HandlerFunc) HandlerFunc {
+		return func(request Request) Response {
+			return NewResponse(418)
+		}
+	}
+
+	handler := chainMiddleware(func(request Request) Response {
+		return OkResponse()
+	}, []Middleware{ForPath(StringPath("/api"), teapot)})
+
+	var tests = []struct {
+		path     string
+		expected int
+	}{
+		{"/api", 418},
+		{"/api/", 418},
+		{"/", 200},
+		{"/index.html", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if response := handler(newTestRequest(MethodGet, tt.path, nil)); response.StatusCode() != tt.expected {
+				t.Errorf("Expected %d but received %d", tt.expected, response.StatusCode())
+			}
+		})
+	}
+}
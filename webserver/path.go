@@ -8,14 +8,26 @@ import (
 
 type Path struct {
 	regex *regexp.Regexp
+	// pattern is a readable description of the paths that match, used when reporting on a route
+	pattern string
 }
 
 func (p Path) Matches(s string) bool {
 	return p.regex.MatchString(s)
 }
 
+// String returns the path as it was given, e.g. `/api/person` for a StringPath or the regular expression for a
+// RegexPath
+func (p Path) String() string {
+	if p.pattern != "" {
+		return p.pattern
+	}
+
+	return p.regex.String()
+}
+
 func RegexPath(regex *regexp.Regexp) Path {
-	return Path{regex: regex}
+	return Path{regex: regex, pattern: regex.String()}
 }
 
 func AnyPath() Path {
@@ -25,5 +37,5 @@ func AnyPath() Path {
 func StringPath(path string) Path {
 	regex := regexp.MustCompile(fmt.Sprintf("^%v\\/{0,1}$", strings.ReplaceAll(path, "/", "\\/")))
 
-	return Path{regex: regex}
+	return Path{regex: regex, pattern: path}
 }
@@ -49,3 +49,13 @@ func TestStringPath(t *testing.T) {
 		t.Fatalf("Expected path to match /hello/world")
 	}
 }
+
+func TestPath_String(t *testing.T) {
+	if pattern := StringPath("/hello/world").String(); pattern != "/hello/world" {
+		t.Fatalf("Expected a StringPath to be described as /hello/world but received %s", pattern)
+	}
+
+	if pattern := RegexPath(regexp.MustCompile("^/api.*$")).String(); pattern != "^/api.*$" {
+		t.Fatalf("Expected a RegexPath to be described as ^/api.*$ but received %s", pattern)
+	}
+}
@@ -0,0 +1,377 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is the cause of the error rendered when a client has made too many requests
+var ErrRateLimited = errors.New("the rate limit has been exceeded")
+
+// ErrInvalidRateLimitPolicy is returned when a rate limit policy doesn't have a positive limit and window
+var ErrInvalidRateLimitPolicy = errors.New("the rate limit policy needs a positive limit and window")
+
+// RateLimitAlgorithm decides how requests are counted against a rate limit
+type RateLimitAlgorithm int
+
+const (
+	// RateLimitTokenBucket refills a bucket of Burst tokens at Limit tokens per Window, with each request taking a token.
+	// This allows short bursts while holding clients to the average rate.
+	RateLimitTokenBucket RateLimitAlgorithm = iota
+	// RateLimitSlidingWindow allows Limit requests in any Window, estimated by weighting the previous fixed window's
+	// count by how much of it overlaps the sliding window
+	RateLimitSlidingWindow
+)
+
+// RateLimitPolicy describes the rate limit applied to each key
+type RateLimitPolicy struct {
+	// Algorithm decides how requests are counted
+	Algorithm RateLimitAlgorithm
+	// Limit is the number of requests allowed per Window
+	Limit int
+	// Window is the period the Limit applies to
+	Window time.Duration
+	// Burst is the size of the token bucket, which defaults to the Limit. It is not used by the sliding window.
+	Burst int
+}
+
+// burst returns the size of the token bucket
+func (p RateLimitPolicy) burst() int {
+	if p.Burst <= 0 {
+		return p.Limit
+	}
+
+	return p.Burst
+}
+
+// RateLimitDecision is the outcome of counting a request against a rate limit
+type RateLimitDecision struct {
+	// Allowed is whether the request may go ahead
+	Allowed bool
+	// Limit is the most requests the key can make in a burst
+	Limit int
+	// Remaining is the number of requests the key can make right now
+	Remaining int
+	// Reset is how long until the key's quota is fully restored
+	Reset time.Duration
+	// RetryAfter is how long until the key may make another request when it isn't allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore counts requests against rate limits. Implementations must be safe for concurrent use; a store shared
+// between several servers lets them enforce a single limit.
+type RateLimitStore interface {
+	// Take counts a request made by the key at the given time against the policy
+	Take(key string, policy RateLimitPolicy, now time.Time) (RateLimitDecision, error)
+}
+
+// RateLimitKeyFunc returns the key a request is counted against. Requests with an empty key aren't rate limited.
+type RateLimitKeyFunc func(request Request) string
+
+// RateLimitByIP counts requests against the IP address of the client that sent them, resolved through any trusted
+// proxies
+func RateLimitByIP() RateLimitKeyFunc {
+	return func(request Request) string {
+		return "ip:" + request.ClientIP()
+	}
+}
+
+// RateLimitByHeader counts requests against the value of a header, e.g. an API key. Requests without the header are
+// counted against their IP address instead.
+func RateLimitByHeader(header string) RateLimitKeyFunc {
+	byIP := RateLimitByIP()
+
+	return func(request Request) string {
+		value, err := request.Headers().GetHeader(header)
+		if err != nil || value == "" {
+			return byIP(request)
+		}
+
+		return "header:" + value
+	}
+}
+
+// RateLimitOptions configures the rate limiting middleware
+type RateLimitOptions struct {
+	// Policy is the rate limit applied to each key
+	Policy RateLimitPolicy
+	// KeyFunc returns the key each request is counted against
+	KeyFunc RateLimitKeyFunc
+	// Store counts the requests. Defaults to an in-memory store that evicts idle keys as requests arrive, so it needs
+	// no closing.
+	Store RateLimitStore
+}
+
+// DefaultRateLimitOptions returns options that allow each IP address 60 requests a minute using a token bucket
+func DefaultRateLimitOptions() RateLimitOptions {
+	return RateLimitOptions{
+		Policy: RateLimitPolicy{
+			Algorithm: RateLimitTokenBucket,
+			Limit:     60,
+			Window:    time.Minute,
+		},
+		KeyFunc: RateLimitByIP(),
+	}
+}
+
+// NewRateLimitMiddleware creates a middleware that responds with a 429 when a client makes too many requests. Every
+// response carries the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers. If the store
+// fails, the error is logged and requests are allowed through rather than the whole service going down with it. It
+// panics with ErrInvalidRateLimitPolicy if the policy doesn't have a positive limit and window.
+func NewRateLimitMiddleware(options RateLimitOptions) Middleware {
+	// An invalid policy would make every Take fail, which quietly lets every request through
+	if options.Policy.Limit <= 0 || options.Policy.Window <= 0 {
+		panic(ErrInvalidRateLimitPolicy)
+	}
+
+	if options.KeyFunc == nil {
+		options.KeyFunc = RateLimitByIP()
+	}
+
+	if options.Store == nil {
+		// Nothing could close a store with a background goroutine, so the default one evicts while taking instead
+		options.Store = newMemoryRateLimitStore(options.Policy.Window)
+	}
+
+	policyHeader := fmt.Sprintf("%d;w=%d", options.Policy.Limit, int(math.Ceil(options.Policy.Window.Seconds())))
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(request Request) Response {
+			key := options.KeyFunc(request)
+			if key == "" {
+				return next(request)
+			}
+
+			decision, err := options.Store.Take(key, options.Policy, time.Now())
+			if err != nil {
+				RequestLogger(request).Error("Rate limit store failed, allowing the request", "error", err)
+				return next(request)
+			}
+
+			var response Response
+			if decision.Allowed {
+				response = next(request)
+			} else {
+				response = RenderError(request, NewHTTPError(429, "Too many requests, please try again later.", ErrRateLimited))
+				response.Headers().SetHeader("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+			}
+
+			response.Headers().SetHeader("RateLimit-Limit", strconv.Itoa(decision.Limit))
+			response.Headers().SetHeader("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+			response.Headers().SetHeader("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+			response.Headers().SetHeader("RateLimit-Policy", policyHeader)
+
+			return response
+		}
+	}
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryRateLimitStore is a RateLimitStore that keeps each key's state in memory. Keys that have been idle long enough
+// to have their full quota back are evicted in the background.
+type MemoryRateLimitStore struct {
+	mutex   sync.Mutex
+	entries map[string]*rateLimitEntry
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// evictInterval is how often Take evicts idle keys, or zero if it doesn't
+	evictInterval time.Duration
+	lastEvicted   time.Time
+}
+
+// rateLimitEntry holds the state of a single key for either algorithm
+type rateLimitEntry struct {
+	// tokens and updated hold the token bucket's state
+	tokens  float64
+	updated time.Time
+	// windowStart, current and previous hold the sliding window's state
+	windowStart time.Time
+	current     int
+	previous    int
+	// expires is when the key will have its full quota back, so it can be evicted
+	expires time.Time
+}
+
+// NewMemoryRateLimitStore creates an in-memory store that evicts idle keys at the given interval until Close is called
+func NewMemoryRateLimitStore(cleanupInterval time.Duration) *MemoryRateLimitStore {
+	store := &MemoryRateLimitStore{
+		entries: make(map[string]*rateLimitEntry),
+		stop:    make(chan struct{}),
+	}
+
+	if cleanupInterval > 0 {
+		go store.cleanup(cleanupInterval)
+	}
+
+	return store
+}
+
+// newMemoryRateLimitStore creates an in-memory store that evicts idle keys while taking, at most once per interval, so
+// it has no goroutine to stop
+func newMemoryRateLimitStore(evictInterval time.Duration) *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		entries:       make(map[string]*rateLimitEntry),
+		stop:          make(chan struct{}),
+		evictInterval: evictInterval,
+	}
+}
+
+// Take counts a request made by the key at the given time against the policy
+func (s *MemoryRateLimitStore) Take(key string, policy RateLimitPolicy, now time.Time) (RateLimitDecision, error) {
+	if policy.Limit <= 0 || policy.Window <= 0 {
+		return RateLimitDecision{}, ErrInvalidRateLimitPolicy
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.evictInterval > 0 && now.Sub(s.lastEvicted) >= s.evictInterval {
+		s.evictLocked(now)
+		s.lastEvicted = now
+	}
+
+	entry, found := s.entries[key]
+	if !found {
+		entry = &rateLimitEntry{tokens: float64(policy.burst()), updated: now}
+		s.entries[key] = entry
+	}
+
+	if policy.Algorithm == RateLimitSlidingWindow {
+		return entry.takeSlidingWindow(policy, now), nil
+	}
+
+	return entry.takeTokenBucket(policy, now), nil
+}
+
+// Len returns the number of keys the store holds
+func (s *MemoryRateLimitStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.entries)
+}
+
+// Close stops evicting idle keys in the background
+func (s *MemoryRateLimitStore) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// cleanup evicts idle keys at the interval until the store is closed
+func (s *MemoryRateLimitStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.evict(now)
+		}
+	}
+}
+
+// evict removes every key that has its full quota back by the given time
+func (s *MemoryRateLimitStore) evict(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.evictLocked(now)
+}
+
+// evictLocked removes every key that has its full quota back by the given time. The mutex must be held.
+func (s *MemoryRateLimitStore) evictLocked(now time.Time) {
+	for key, entry := range s.entries {
+		if !now.Before(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// takeTokenBucket refills the bucket for the time since it was last used, then takes a token if there is one
+func (e *rateLimitEntry) takeTokenBucket(policy RateLimitPolicy, now time.Time) RateLimitDecision {
+	burst := policy.burst()
+
+	// The rate is in tokens per second
+	rate := float64(policy.Limit) / policy.Window.Seconds()
+
+	elapsed := max(now.Sub(e.updated).Seconds(), 0)
+	e.tokens = min(float64(burst), e.tokens+elapsed*rate)
+	e.updated = now
+
+	decision := RateLimitDecision{Limit: burst}
+	if e.tokens >= 1 {
+		e.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = secondsToDuration((1 - e.tokens) / rate)
+	}
+
+	decision.Remaining = int(e.tokens)
+	decision.Reset = secondsToDuration((float64(burst) - e.tokens) / rate)
+	e.expires = now.Add(decision.Reset)
+
+	return decision
+}
+
+// takeSlidingWindow moves the windows along to the given time, then counts the request if the estimated number of
+// requests in the sliding window is below the limit
+func (e *rateLimitEntry) takeSlidingWindow(policy RateLimitPolicy, now time.Time) RateLimitDecision {
+	window := policy.Window
+	limit := float64(policy.Limit)
+
+	windowStart := now.Truncate(window)
+	if !windowStart.Equal(e.windowStart) {
+		if windowStart.Sub(e.windowStart) == window {
+			e.previous = e.current
+		} else {
+			e.previous = 0
+		}
+
+		e.current = 0
+		e.windowStart = windowStart
+	}
+
+	elapsed := now.Sub(windowStart)
+	untilNextWindow := window - elapsed
+	previousWeight := 1 - elapsed.Seconds()/window.Seconds()
+	estimate := float64(e.previous)*previousWeight + float64(e.current)
+
+	decision := RateLimitDecision{Limit: policy.Limit}
+	if estimate+1 <= limit {
+		e.current++
+		estimate++
+		decision.Allowed = true
+	} else if float64(e.current)+1 <= limit {
+		// Wait for enough of the previous window to slide out of view
+		needed := 1 - (limit-float64(e.current)-1)/float64(e.previous)
+		decision.RetryAfter = secondsToDuration(needed*window.Seconds()) - elapsed
+	} else {
+		// Wait for the next window, then for enough of this one to slide out of view
+		needed := 1 - (limit-1)/float64(e.current)
+		decision.RetryAfter = untilNextWindow + secondsToDuration(needed*window.Seconds())
+	}
+
+	decision.Remaining = max(int(limit-estimate), 0)
+	decision.Reset = untilNextWindow + window
+	e.expires = windowStart.Add(2 * window)
+
+	return decision
+}
+
+// secondsToDuration converts a number of seconds to a duration
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,259 @@
+package webserver
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStore_TokenBucket(t *testing.T) {
+	store := NewMemoryRateLimitStore(0)
+	policy := RateLimitPolicy{Algorithm: RateLimitTokenBucket, Limit: 2, Window: time.Second, Burst: 3}
+	start := time.Unix(1700000000, 0)
+
+	var tests = []struct {
+		name       string
+		at         time.Duration
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}{
+		{"First request", 0, true, 2, 0},
+		{"Second request", 0, true, 1, 0},
+		{"Burst request", 0, true, 0, 0},
+		{"Bucket empty", 0, false, 0, 500 * time.Millisecond},
+		{"Refilled one token", 500 * time.Millisecond, true, 0, 0},
+		{"Fully refilled", 10 * time.Second, true, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := store.Take("client", policy, start.Add(tt.at))
+			if err != nil {
+				t.Fatalf("Received an error taking a token: %v", err)
+			}
+
+			if decision.Allowed != tt.allowed || decision.Remaining != tt.remaining || decision.RetryAfter != tt.retryAfter {
+				t.Errorf("Expected allowed %v, remaining %d and retry after %v but received %+v", tt.allowed, tt.remaining, tt.retryAfter, decision)
+			}
+
+			if decision.Limit != 3 {
+				t.Errorf("Expected the limit to be the burst but received %d", decision.Limit)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStore_SlidingWindow(t *testing.T) {
+	store := NewMemoryRateLimitStore(0)
+	policy := RateLimitPolicy{Algorithm: RateLimitSlidingWindow, Limit: 4, Window: 10 * time.Second}
+	start := time.Unix(1700000000, 0)
+
+	var tests = []struct {
+		name       string
+		at         time.Duration
+		allowed    bool
+		retryAfter time.Duration
+	}{
+		{"First request", 0, true, 0},
+		{"Second request", time.Second, true, 0},
+		{"Third request", 2 * time.Second, true, 0},
+		{"Fourth request", 3 * time.Second, true, 0},
+		// The next window starts in 6 seconds, and 2.5 seconds after that only 3 of these requests still count
+		{"Window full", 4 * time.Second, false, 8500 * time.Millisecond},
+		// Half way through the next window, half of the previous window's 4 requests still count
+		{"Previous window half counted", 15 * time.Second, true, 0},
+		{"Previous window half counted again", 15 * time.Second, true, 0},
+		{"Limit reached with overlap", 15 * time.Second, false, 2500 * time.Millisecond},
+		{"Idle for two windows", 40 * time.Second, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := store.Take("client", policy, start.Add(tt.at))
+			if err != nil {
+				t.Fatalf("Received an error taking a request: %v", err)
+			}
+
+			if decision.Allowed != tt.allowed || decision.RetryAfter != tt.retryAfter {
+				t.Errorf("Expected allowed %v and retry after %v but received %+v", tt.allowed, tt.retryAfter, decision)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStore_Evict(t *testing.T) {
+	store := NewMemoryRateLimitStore(0)
+	policy := RateLimitPolicy{Algorithm: RateLimitTokenBucket, Limit: 10, Window: 10 * time.Second}
+	start := time.Unix(1700000000, 0)
+
+	_, _ = store.Take("busy", policy, start)
+	_, _ = store.Take("busy", policy, start)
+	_, _ = store.Take("idle", policy, start)
+
+	// Each token takes a second to come back, so only the idle key is full again after a second
+	store.evict(start.Add(time.Second))
+	if store.Len() != 1 {
+		t.Fatalf("Expected only the idle key to be evicted but %d keys remain", store.Len())
+	}
+
+	store.evict(start.Add(2 * time.Second))
+	if store.Len() != 0 {
+		t.Fatalf("Expected every key to be evicted but %d keys remain", store.Len())
+	}
+}
+
+func TestMemoryRateLimitStore_EvictWhileTaking(t *testing.T) {
+	store := newMemoryRateLimitStore(time.Minute)
+	policy := RateLimitPolicy{Algorithm: RateLimitTokenBucket, Limit: 10, Window: 10 * time.Second}
+	start := time.Unix(1700000000, 0)
+
+	_, _ = store.Take("idle", policy, start)
+	_, _ = store.Take("busy", policy, start.Add(30*time.Second))
+	if store.Len() != 2 {
+		t.Fatalf("Expected no keys to be evicted within the interval but %d keys remain", store.Len())
+	}
+
+	_, _ = store.Take("busy", policy, start.Add(time.Minute))
+	if store.Len() != 1 {
+		t.Fatalf("Expected the idle key to be evicted but %d keys remain", store.Len())
+	}
+}
+
+func TestMemoryRateLimitStore_InvalidPolicy(t *testing.T) {
+	store := NewMemoryRateLimitStore(0)
+
+	if _, err := store.Take("client", RateLimitPolicy{Limit: 0, Window: time.Second}, time.Now()); !errors.Is(err, ErrInvalidRateLimitPolicy) {
+		t.Fatalf("Expected ErrInvalidRateLimitPolicy but received %v", err)
+	}
+}
+
+func TestRateLimitKeyFuncs(t *testing.T) {
+	request := newTestRequest(MethodGet, "/", map[string]string{"X-Api-Key": "secret"})
+	request.remoteAddr = "192.0.2.1:54321"
+
+	withoutHeader := newTestRequest(MethodGet, "/", nil)
+	withoutHeader.remoteAddr = "[2001:db8::1]:443"
+
+	var tests = []struct {
+		name     string
+		keyFunc  RateLimitKeyFunc
+		request  Request
+		expected string
+	}{
+		{"By IP", RateLimitByIP(), request, "ip:192.0.2.1"},
+		{"By IPv6", RateLimitByIP(), withoutHeader, "ip:2001:db8::1"},
+		{"By header", RateLimitByHeader("X-Api-Key"), request, "header:secret"},
+		{"By missing header", RateLimitByHeader("X-Api-Key"), withoutHeader, "ip:2001:db8::1"},
+	}
+
+	for _, test := range tests {
+		if key := test.keyFunc(test.request); key != test.expected {
+			t.Errorf("%s: expected %q but received %q", test.name, test.expected, key)
+		}
+	}
+}
+
+func TestNewRateLimitMiddleware(t *testing.T) {
+	options := DefaultRateLimitOptions()
+	options.Policy = RateLimitPolicy{Algorithm: RateLimitTokenBucket, Limit: 2, Window: time.Minute}
+	options.Store = NewMemoryRateLimitStore(0)
+
+	calls := 0
+	handler := NewRateLimitMiddleware(options)(func(request Request) Response {
+		calls++
+		return OkResponse()
+	})
+
+	request := newTestRequest(MethodGet, "/api/person", nil)
+	request.remoteAddr = "192.0.2.1:54321"
+
+	for i := 0; i < 2; i++ {
+		response := handler(request)
+		if response.StatusCode() != 200 {
+			t.Fatalf("Expected request %d to be allowed but received %d", i+1, response.StatusCode())
+		}
+	}
+
+	response := handler(request)
+	if response.StatusCode() != 429 || calls != 2 {
+		t.Fatalf("Expected a 429 without calling the handler but received %d after %d calls", response.StatusCode(), calls)
+	}
+
+	var expectedHeaders = map[string]string{
+		"Retry-After":         "30",
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "60",
+		"RateLimit-Policy":    "2;w=60",
+	}
+	for header, expected := range expectedHeaders {
+		if value, _ := response.Headers().GetHeader(header); value != expected {
+			t.Errorf("Expected %s to be %q but received %q", header, expected, value)
+		}
+	}
+
+	if !strings.Contains(string(response.Body()), "Too many requests") {
+		t.Errorf("Expected the body to describe the problem but received %q", response.Body())
+	}
+
+	other := newTestRequest(MethodGet, "/api/person", nil)
+	other.remoteAddr = "192.0.2.2:54321"
+	if response := handler(other); response.StatusCode() != 200 {
+		t.Errorf("Expected another client to be allowed but received %d", response.StatusCode())
+	}
+}
+
+func TestNewRateLimitMiddleware_InvalidPolicy(t *testing.T) {
+	var tests = []struct {
+		name   string
+		policy RateLimitPolicy
+	}{
+		{"No limit", RateLimitPolicy{Limit: 0, Window: time.Minute}},
+		{"Negative limit", RateLimitPolicy{Limit: -1, Window: time.Minute}},
+		{"No window", RateLimitPolicy{Limit: 10}},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if r := recover(); r != ErrInvalidRateLimitPolicy {
+					t.Errorf("%s: expected a panic with ErrInvalidRateLimitPolicy but received %v", test.name, r)
+				}
+			}()
+
+			NewRateLimitMiddleware(RateLimitOptions{Policy: test.policy})
+		}()
+	}
+}
+
+// failingRateLimitStore is a RateLimitStore that always fails
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(string, RateLimitPolicy, time.Time) (RateLimitDecision, error) {
+	return RateLimitDecision{}, errors.New("the store is unavailable")
+}
+
+func TestNewRateLimitMiddleware_StoreError(t *testing.T) {
+	options := DefaultRateLimitOptions()
+	options.Store = failingRateLimitStore{}
+
+	handler := NewRateLimitMiddleware(options)(func(request Request) Response {
+		return OkResponse()
+	})
+
+	var buffer bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buffer, nil))
+	request := newTestRequest(MethodGet, "/api/person", nil).WithValue(loggerKey{}, logger)
+
+	if response := handler(request); response.StatusCode() != 200 {
+		t.Fatalf("Expected the request to be allowed but received %d", response.StatusCode())
+	}
+
+	if !strings.Contains(buffer.String(), "the store is unavailable") {
+		t.Errorf("Expected the store error to be logged but received %q", buffer.String())
+	}
+}
@@ -0,0 +1,41 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// PanicError is the error passed to the ErrorHandler when a handler or middleware panics
+type PanicError struct {
+	// Value is the value the handler panicked with
+	Value any
+	// Stack is the stack trace of the goroutine at the time of the panic
+	Stack []byte
+}
+
+// Error returns a description of the panic
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// Unwrap returns the value the handler panicked with if it was an error
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+
+	return nil
+}
+
+// handleError runs the error handler, falling back to the DefaultErrorHandler if it panics too
+func handleError(logger *slog.Logger, errorHandler ErrorHandler, request Request, err error) (response Response) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Error handler panicked", "error", err, "panic", r)
+			response = DefaultErrorHandler(request, errors.Join(err, fmt.Errorf("error handler panicked: %v", r)))
+		}
+	}()
+
+	return errorHandler(request, err)
+}
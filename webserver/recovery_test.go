@@ -0,0 +1,40 @@
+package webserver
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestPanicError_Unwrap(t *testing.T) {
+	err := &PanicError{Value: io.ErrUnexpectedEOF}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected the PanicError to unwrap to the error it panicked with")
+	}
+
+	err = &PanicError{Value: "not an error"}
+	if err.Unwrap() != nil {
+		t.Fatalf("Expected Unwrap() to return nil when the panic value isn't an error but received %v", err.Unwrap())
+	}
+}
+
+func TestHandleError(t *testing.T) {
+	response := handleError(slog.Default(), func(request Request, err error) Response {
+		return BadRequestResponseWithBody([]byte(err.Error()))
+	}, newTestRequest(MethodGet, "/", nil), errors.New("bad input"))
+
+	if response.StatusCode() != 400 || string(response.Body()) != "bad input" {
+		t.Fatalf("Expected the error handler's 400 response but received %d %q", response.StatusCode(), string(response.Body()))
+	}
+}
+
+func TestHandleErrorWithPanickingErrorHandler(t *testing.T) {
+	response := handleError(slog.Default(), func(request Request, err error) Response {
+		panic("error handler failed")
+	}, newTestRequest(MethodGet, "/", nil), errors.New("bad input"))
+
+	if response.StatusCode() != 500 {
+		t.Fatalf("Expected a 500 when the error handler panics but received %d", response.StatusCode())
+	}
+}
@@ -2,6 +2,7 @@ package webserver
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
 	"strconv"
@@ -15,16 +16,48 @@ var ErrUnsupportedBody = errors.New("body format is not supported")
 type Request interface {
 	Path() string
 	Method() Method
+	// Protocol returns the HTTP version from the request line, e.g. `HTTP/1.1`
+	Protocol() string
 	Headers() RequestHeaders
+	// RemoteAddr returns the address of the connection the request arrived on, e.g. `192.0.2.1:51234`, which is the
+	// nearest proxy when the web server is behind one
+	RemoteAddr() string
+	// ClientIP returns the IP address of the client that sent the request. Behind trusted proxies it comes from the
+	// forwarding headers, otherwise it is the host of RemoteAddr.
+	ClientIP() string
+	// Cookie returns the cookie with the given name from the Cookie header, or ErrCookieNotFound
+	Cookie(name string) (Cookie, error)
+	// Cookies returns every cookie from the Cookie header
+	Cookies() []Cookie
 	Body() []byte
 	BodyAsString() string
+	// SpanContext returns the span context of the server span covering the request, which is invalid when tracing isn't
+	// enabled. Pass its TraceParent() on to other services to continue the trace.
+	SpanContext() SpanContext
+	// Context returns the request's context. It is cancelled when the client disconnects, the server shuts down or the
+	// handler times out, and context.Cause reports which.
+	Context() context.Context
+	// WithContext returns a shallow copy of the request that uses the given context
+	WithContext(ctx context.Context) Request
+	// WithValue returns a shallow copy of the request whose context carries the value, e.g. so that middleware can pass
+	// the authenticated user on to handlers
+	WithValue(key any, value any) Request
 }
 
 type request struct {
-	path    string
-	method  Method
-	headers Headers
-	body    []byte
+	path     string
+	method   Method
+	protocol string
+	headers  Headers
+	body     []byte
+	// remoteAddr is the address of the client connection, set by the web server
+	remoteAddr string
+	// clientIP is the client's IP address resolved through any trusted proxies, set by the web server
+	clientIP string
+	// spanContext identifies the server span covering the request, set by the web server
+	spanContext SpanContext
+	// ctx is the request's context, set by the web server
+	ctx context.Context
 }
 
 func (r *request) Path() string {
@@ -35,10 +68,49 @@ func (r *request) Method() Method {
 	return r.method
 }
 
+func (r *request) Protocol() string {
+	return r.protocol
+}
+
 func (r *request) Headers() RequestHeaders {
 	return r.headers
 }
 
+func (r *request) RemoteAddr() string {
+	return r.remoteAddr
+}
+
+func (r *request) ClientIP() string {
+	if r.clientIP == "" {
+		return remoteHost(r.remoteAddr)
+	}
+
+	return r.clientIP
+}
+
+func (r *request) Cookie(name string) (Cookie, error) {
+	for _, cookie := range r.Cookies() {
+		if cookie.Name == name {
+			return cookie, nil
+		}
+	}
+
+	return Cookie{}, ErrCookieNotFound
+}
+
+func (r *request) Cookies() []Cookie {
+	if r.headers == nil {
+		return make([]Cookie, 0)
+	}
+
+	header, err := r.headers.GetHeader("Cookie")
+	if err != nil {
+		return make([]Cookie, 0)
+	}
+
+	return parseCookies(header)
+}
+
 func (r *request) Body() []byte {
 	return r.body
 }
@@ -47,9 +119,45 @@ func (r *request) BodyAsString() string {
 	return string(r.body)
 }
 
+func (r *request) SpanContext() SpanContext {
+	return r.spanContext
+}
+
+func (r *request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+
+	return r.ctx
+}
+
+func (r *request) WithContext(ctx context.Context) Request {
+	copied := *r
+	copied.ctx = ctx
+	return &copied
+}
+
+func (r *request) WithValue(key any, value any) Request {
+	return r.WithContext(context.WithValue(r.Context(), key, value))
+}
+
 func parseRequest(requestStream io.Reader) (Request, error) {
 	reader := bufio.NewReader(requestStream)
 
+	head, err := parseRequestHead(reader)
+	if err != nil {
+		return head, err
+	}
+
+	if err := readRequestBody(head, reader); err != nil {
+		return &request{}, err
+	}
+
+	return head, nil
+}
+
+// parseRequestHead reads the request line and headers, leaving the body unread
+func parseRequestHead(reader *bufio.Reader) (*request, error) {
 	// Read the first line with the method and path
 	startLine, err := reader.ReadString('\n')
 	if err != nil {
@@ -58,6 +166,9 @@ func parseRequest(requestStream io.Reader) (Request, error) {
 
 	// Parse the first line
 	startLineParts := strings.Split(strings.TrimSpace(startLine), " ")
+	if len(startLineParts) != 3 {
+		return &request{}, ErrInvalidRequest
+	}
 
 	method, err := methodFromString(startLineParts[0])
 	if err != nil {
@@ -65,23 +176,30 @@ func parseRequest(requestStream io.Reader) (Request, error) {
 	}
 
 	path := startLineParts[1]
+	protocol := startLineParts[2]
 
 	headers, err := parseRequestHeaders(reader)
 	if err != nil {
 		return &request{}, err
 	}
 
-	body, err := retrieveBody(headers, reader)
+	return &request{
+		path:     path,
+		method:   method,
+		protocol: protocol,
+		headers:  headers,
+	}, nil
+}
+
+// readRequestBody reads the body of a request whose head has been parsed
+func readRequestBody(request *request, reader *bufio.Reader) error {
+	body, err := retrieveBody(request.headers, reader)
 	if err != nil {
-		return &request{}, err
+		return err
 	}
 
-	return &request{
-		path:    path,
-		method:  method,
-		headers: headers,
-		body:    body,
-	}, nil
+	request.body = body
+	return nil
 }
 
 func retrieveBody(headers Headers, reader *bufio.Reader) ([]byte, error) {
@@ -172,3 +290,24 @@ func retrieveWithContentLength(headers Headers, reader *bufio.Reader) ([]byte, e
 
 	return body, nil
 }
+
+// setRemoteAddr records the address of the client that sent the request
+func setRemoteAddr(r Request, remoteAddr string) {
+	if parsedRequest, ok := r.(*request); ok {
+		parsedRequest.remoteAddr = remoteAddr
+	}
+}
+
+// setClientIP records the IP address of the client that sent the request
+func setClientIP(r Request, clientIP string) {
+	if parsedRequest, ok := r.(*request); ok {
+		parsedRequest.clientIP = clientIP
+	}
+}
+
+// setSpanContext records the span context of the server span covering the request
+func setSpanContext(r Request, spanContext SpanContext) {
+	if parsedRequest, ok := r.(*request); ok {
+		parsedRequest.spanContext = spanContext
+	}
+}
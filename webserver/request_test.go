@@ -3,11 +3,25 @@ package webserver
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"strings"
 	"testing"
 )
 
+// newTestRequest creates a request with the given method, path and headers for use in tests
+func newTestRequest(method Method, path string, headersMap map[string]string) *request {
+	if headersMap == nil {
+		headersMap = make(map[string]string)
+	}
+
+	return &request{
+		path:    path,
+		method:  method,
+		headers: &headers{headersMap: headersMap},
+	}
+}
+
 func TestRequest_GetPath(t *testing.T) {
 	request := request{
 		path: "/hello",
@@ -54,6 +68,35 @@ func TestRequest_GetBodyAsString(t *testing.T) {
 	}
 }
 
+func TestRequest_Context(t *testing.T) {
+	request := newTestRequest(MethodGet, "/", nil)
+	if request.Context() != context.Background() {
+		t.Fatalf("Expected a request without a context to use the background context")
+	}
+
+	type userKey struct{}
+	withValue := request.WithValue(userKey{}, "alice")
+
+	if withValue.Context().Value(userKey{}) != "alice" {
+		t.Errorf("Expected the copy's context to carry the value but received %v", withValue.Context().Value(userKey{}))
+	}
+
+	if request.Context().Value(userKey{}) != nil {
+		t.Errorf("Expected the original request to be unchanged")
+	}
+
+	if withValue.Path() != "/" || withValue.Method() != MethodGet {
+		t.Errorf("Expected the copy to keep the request's path and method")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if request.WithContext(ctx).Context().Err() == nil {
+		t.Errorf("Expected the copy to use the given context")
+	}
+}
+
 func TestParseRequestValid(t *testing.T) {
 	requestStream := strings.NewReader("GET /hello HTTP/2\r\nHost: www.bing.com\r\nUser-Agent: curl/7.54.0\r\nContent-Length: 13\r\n\r\nHello, World!")
 
@@ -72,6 +115,11 @@ func TestParseRequestValid(t *testing.T) {
 		t.Fatalf("Expected path is %v but received %v", wantPath, parsedRequest.Path())
 	}
 
+	wantProtocol := "HTTP/2"
+	if parsedRequest.Protocol() != wantProtocol {
+		t.Fatalf("Expected protocol is %v but received %v", wantProtocol, parsedRequest.Protocol())
+	}
+
 	if !parsedRequest.Headers().HasHeader("Host") || !parsedRequest.Headers().HasHeader("User-Agent") || !parsedRequest.Headers().HasHeader("Content-Length") {
 		t.Fatalf("Expected the Host, User-Agent and Content-Length headers but didn't receive all of them")
 	}
@@ -92,6 +140,16 @@ func TestParseRequestInvalidMethod(t *testing.T) {
 	}
 }
 
+func TestParseRequestInvalidRequestLine(t *testing.T) {
+	requestStream := strings.NewReader("GET\r\nHost: www.bing.com\r\n\r\n")
+
+	_, err := parseRequest(requestStream)
+
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("Expected an ErrInvalidRequest error but received %v", err)
+	}
+}
+
 func TestParseRequestInvalidHeader(t *testing.T) {
 	requestStream := strings.NewReader("GET /hello HTTP/2\r\nHost: www.bing.com\r\nUser-Agent-curl/7.54.0\r\nContent-Length: 13\r\n\r\nHello, World!")
 
@@ -13,6 +13,11 @@ type Response interface {
 	Body() []byte
 	// SetBody sets the content of the response
 	SetBody(content []byte)
+	// SetCookie adds a Set-Cookie header to the response, replacing any cookie already set with the same name, path
+	// and domain. Unlike other headers, a response can set several cookies.
+	SetCookie(cookie Cookie)
+	// Cookies returns the cookies the response sets
+	Cookies() []Cookie
 }
 
 // response is a local struct that implements the Response interface. It contains fields for the status code, headers,
@@ -24,6 +29,8 @@ type response struct {
 	headers ResponseHeaders
 	// The content of the response
 	body []byte
+	// The cookies the response sets
+	cookies []Cookie
 }
 
 // StatusCode returns the status code of the response
@@ -51,6 +58,24 @@ func (r *response) SetBody(content []byte) {
 	r.body = content
 }
 
+// SetCookie adds a Set-Cookie header to the response, replacing any cookie already set with the same name, path and
+// domain
+func (r *response) SetCookie(cookie Cookie) {
+	for i, existing := range r.cookies {
+		if existing.Name == cookie.Name && existing.Path == cookie.Path && existing.Domain == cookie.Domain {
+			r.cookies[i] = cookie
+			return
+		}
+	}
+
+	r.cookies = append(r.cookies, cookie)
+}
+
+// Cookies returns the cookies the response sets
+func (r *response) Cookies() []Cookie {
+	return r.cookies
+}
+
 // NewResponse creates a new response with the given status code and no body
 func NewResponse(statusCode int) Response {
 	return &response{
@@ -88,6 +113,16 @@ func BadRequestResponseWithBody(body []byte) Response {
 	return NewResponseWithBody(400, body)
 }
 
+// ForbiddenResponse creates a new response with a status code of 403
+func ForbiddenResponse() Response {
+	return NewResponse(403)
+}
+
+// ForbiddenResponseWithBody creates a new response with a status code of 403 and the given body
+func ForbiddenResponseWithBody(body []byte) Response {
+	return NewResponseWithBody(403, body)
+}
+
 // NotFoundResponse creates a new response with a status code of 404
 func NotFoundResponse() Response {
 	return NewResponse(404)
@@ -139,6 +139,32 @@ func TestBadRequestResponseWithBody(t *testing.T) {
 	}
 }
 
+func TestForbiddenResponse(t *testing.T) {
+	response := ForbiddenResponse()
+	if response.StatusCode() != 403 {
+		t.Fatalf("Expected ForbiddenResponse() to set the status code to 403 but received %d", response.StatusCode())
+	}
+
+	if response.Headers() == nil {
+		t.Fatalf("Expected ForbiddenResponse() to set the headers to a non-nil value but received nil")
+	}
+}
+
+func TestForbiddenResponseWithBody(t *testing.T) {
+	response := ForbiddenResponseWithBody([]byte("Hello World!"))
+	if response.StatusCode() != 403 {
+		t.Fatalf("Expected ForbiddenResponseWithBody() to set the status code to 403 but received %d", response.StatusCode())
+	}
+
+	if string(response.Body()) != "Hello World!" {
+		t.Fatalf("Expected ForbiddenResponseWithBody() to set the body to \"Hello World!\" but received \"%s\"", string(response.Body()))
+	}
+
+	if response.Headers() == nil {
+		t.Fatalf("Expected ForbiddenResponseWithBody() to set the headers to a non-nil value but received nil")
+	}
+}
+
 func TestNotFoundResponse(t *testing.T) {
 	response := NotFoundResponse()
 	if response.StatusCode() != 404 {
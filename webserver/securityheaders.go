@@ -0,0 +1,396 @@
+package webserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CSPNonceSource stands in for the request's nonce in a Content-Security-Policy source list. It is replaced with
+// `'nonce-<value>'` when the header is written.
+const CSPNonceSource = "'nonce'"
+
+// maxCSPReportsPerRequest limits how many reports a single request to the report endpoint can add
+const maxCSPReportsPerRequest = 100
+
+// maxCSPReportBodySize is the largest body the report endpoint parses, which is checked once the body has been read.
+// Real reports are a few kilobytes at most.
+const maxCSPReportBodySize = 64 << 10
+
+// defaultMaxCSPReports is how many reports a collector keeps when it isn't given a positive maximum
+const defaultMaxCSPReports = 1000
+
+// ContentSecurityPolicy builds a Content-Security-Policy header from its directives
+type ContentSecurityPolicy struct {
+	directives []cspDirective
+}
+
+// cspDirective is a directive name with its source list
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+// NewContentSecurityPolicy creates an empty policy
+func NewContentSecurityPolicy() *ContentSecurityPolicy {
+	return &ContentSecurityPolicy{}
+}
+
+// DefaultContentSecurityPolicy returns a strict policy that only allows resources from the same origin, scripts and
+// styles that carry the request's nonce, and no framing, plugins or changes to the base URI
+func DefaultContentSecurityPolicy() *ContentSecurityPolicy {
+	return NewContentSecurityPolicy().
+		Directive("default-src", "'self'").
+		Directive("script-src", "'self'", CSPNonceSource).
+		Directive("style-src", "'self'", CSPNonceSource).
+		Directive("img-src", "'self'", "data:").
+		Directive("object-src", "'none'").
+		Directive("base-uri", "'self'").
+		Directive("form-action", "'self'").
+		Directive("frame-ancestors", "'none'")
+}
+
+// Directive adds sources to a directive, creating it if the policy doesn't have it yet. Directives without sources,
+// like `upgrade-insecure-requests`, can be added by passing none.
+func (p *ContentSecurityPolicy) Directive(name string, sources ...string) *ContentSecurityPolicy {
+	for i := range p.directives {
+		if strings.EqualFold(p.directives[i].name, name) {
+			p.directives[i].sources = append(p.directives[i].sources, sources...)
+			return p
+		}
+	}
+
+	p.directives = append(p.directives, cspDirective{name: name, sources: sources})
+	return p
+}
+
+// clone returns a copy of the policy that can be changed without affecting the original
+func (p *ContentSecurityPolicy) clone() *ContentSecurityPolicy {
+	cloned := &ContentSecurityPolicy{directives: make([]cspDirective, len(p.directives))}
+	for i, directive := range p.directives {
+		cloned.directives[i] = cspDirective{name: directive.name, sources: append([]string(nil), directive.sources...)}
+	}
+
+	return cloned
+}
+
+// usesNonce returns whether any directive contains CSPNonceSource
+func (p *ContentSecurityPolicy) usesNonce() bool {
+	for _, directive := range p.directives {
+		for _, source := range directive.sources {
+			if source == CSPNonceSource {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Header renders the policy with the nonce in place of CSPNonceSource
+func (p *ContentSecurityPolicy) Header(nonce string) string {
+	directives := make([]string, 0, len(p.directives))
+	for _, directive := range p.directives {
+		parts := []string{directive.name}
+		for _, source := range directive.sources {
+			if source == CSPNonceSource {
+				source = "'nonce-" + nonce + "'"
+			}
+			parts = append(parts, source)
+		}
+
+		directives = append(directives, strings.Join(parts, " "))
+	}
+
+	return strings.Join(directives, "; ")
+}
+
+// SecurityHeadersOptions configures the security headers middleware. Headers with an empty value aren't sent.
+type SecurityHeadersOptions struct {
+	// HSTSMaxAge is how long browsers should only use HTTPS for the site, or zero to not send Strict-Transport-Security
+	HSTSMaxAge time.Duration
+	// HSTSIncludeSubdomains applies HSTS to every subdomain too
+	HSTSIncludeSubdomains bool
+	// HSTSPreload asks for the site to be included in browsers' preload lists
+	HSTSPreload bool
+	// ContentTypeOptions is the X-Content-Type-Options header, which stops browsers guessing content types
+	ContentTypeOptions string
+	// FrameOptions is the X-Frame-Options header, which older browsers use instead of CSP's frame-ancestors
+	FrameOptions string
+	// ReferrerPolicy is the Referrer-Policy header
+	ReferrerPolicy string
+	// PermissionsPolicy is the Permissions-Policy header, which disables browser features the site doesn't use
+	PermissionsPolicy string
+	// CrossOriginOpenerPolicy is the Cross-Origin-Opener-Policy header
+	CrossOriginOpenerPolicy string
+	// CrossOriginEmbedderPolicy is the Cross-Origin-Embedder-Policy header. `require-corp` stops the page loading
+	// cross-origin resources that don't opt in, so it isn't sent by default.
+	CrossOriginEmbedderPolicy string
+	// CrossOriginResourcePolicy is the Cross-Origin-Resource-Policy header
+	CrossOriginResourcePolicy string
+	// ContentSecurityPolicy is the policy to send, or nil to not send one
+	ContentSecurityPolicy *ContentSecurityPolicy
+	// CSPReportOnly sends the policy as Content-Security-Policy-Report-Only, so violations are reported but not blocked
+	CSPReportOnly bool
+	// CSPReportURI is where browsers send violation reports, e.g. the path of a NewCSPReportHandler
+	CSPReportURI string
+}
+
+// DefaultSecurityHeadersOptions returns options with a year of HSTS, no content type sniffing or framing, a strict
+// referrer policy, the common browser features disabled, an isolated browsing context and the default CSP
+func DefaultSecurityHeadersOptions() SecurityHeadersOptions {
+	return SecurityHeadersOptions{
+		HSTSMaxAge:                365 * 24 * time.Hour,
+		HSTSIncludeSubdomains:     true,
+		ContentTypeOptions:        "nosniff",
+		FrameOptions:              "DENY",
+		ReferrerPolicy:            "strict-origin-when-cross-origin",
+		PermissionsPolicy:         "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginResourcePolicy: "same-origin",
+		ContentSecurityPolicy:     DefaultContentSecurityPolicy(),
+	}
+}
+
+// cspNonceKey is the context key the request's CSP nonce is stored under
+type cspNonceKey struct{}
+
+// CSPNonce returns the nonce for the request's Content-Security-Policy, which inline scripts and styles must carry in
+// their nonce attribute. It returns an empty string if the policy doesn't use a nonce.
+func CSPNonce(request Request) string {
+	nonce, _ := request.Context().Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// NewSecurityHeadersMiddleware creates a middleware that adds security headers to every response, including those
+// from the static file handler. Headers the handler has already set are left alone. When the Content-Security-Policy
+// uses CSPNonceSource, each request gets a new nonce that handlers can read with CSPNonce.
+func NewSecurityHeadersMiddleware(options SecurityHeadersOptions) Middleware {
+	headers := make([][2]string, 0)
+	add := func(name string, value string) {
+		if value != "" {
+			headers = append(headers, [2]string{name, value})
+		}
+	}
+
+	if options.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.Itoa(int(options.HSTSMaxAge.Seconds()))
+		if options.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if options.HSTSPreload {
+			hsts += "; preload"
+		}
+		add("Strict-Transport-Security", hsts)
+	}
+
+	add("X-Content-Type-Options", options.ContentTypeOptions)
+	add("X-Frame-Options", options.FrameOptions)
+	add("Referrer-Policy", options.ReferrerPolicy)
+	add("Permissions-Policy", options.PermissionsPolicy)
+	add("Cross-Origin-Opener-Policy", options.CrossOriginOpenerPolicy)
+	add("Cross-Origin-Embedder-Policy", options.CrossOriginEmbedderPolicy)
+	add("Cross-Origin-Resource-Policy", options.CrossOriginResourcePolicy)
+
+	policy := options.ContentSecurityPolicy
+	if policy != nil && options.CSPReportURI != "" {
+		// Copy the policy so that the report URI isn't added to one shared with other middleware
+		policy = policy.clone().Directive("report-uri", options.CSPReportURI)
+	}
+
+	cspHeader := "Content-Security-Policy"
+	if options.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+
+	usesNonce := policy != nil && policy.usesNonce()
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(request Request) Response {
+			nonce := ""
+			if usesNonce {
+				nonce = base64.StdEncoding.EncodeToString(randomBytes(16))
+				request = request.WithValue(cspNonceKey{}, nonce)
+			}
+
+			response := next(request)
+
+			for _, header := range headers {
+				if !response.Headers().HasHeader(header[0]) {
+					response.Headers().SetHeader(header[0], header[1])
+				}
+			}
+
+			if policy != nil && !response.Headers().HasHeader(cspHeader) {
+				response.Headers().SetHeader(cspHeader, policy.Header(nonce))
+			}
+
+			return response
+		}
+	}
+}
+
+// CSPViolationReport is a Content-Security-Policy violation reported by a browser
+type CSPViolationReport struct {
+	DocumentURL        string    `json:"documentURL"`
+	Referrer           string    `json:"referrer,omitempty"`
+	BlockedURL         string    `json:"blockedURL"`
+	EffectiveDirective string    `json:"effectiveDirective"`
+	OriginalPolicy     string    `json:"originalPolicy"`
+	Disposition        string    `json:"disposition"`
+	SourceFile         string    `json:"sourceFile,omitempty"`
+	LineNumber         int       `json:"lineNumber,omitempty"`
+	ColumnNumber       int       `json:"columnNumber,omitempty"`
+	StatusCode         int       `json:"statusCode,omitempty"`
+	Sample             string    `json:"sample,omitempty"`
+	Received           time.Time `json:"received"`
+}
+
+// CSPReportCollector keeps the most recent violation reports in memory
+type CSPReportCollector struct {
+	mutex      sync.Mutex
+	reports    []CSPViolationReport
+	maxReports int
+}
+
+// NewCSPReportCollector creates a collector that keeps up to maxReports of the most recent reports. The reports come
+// from anyone who can reach the endpoint, so there is always a limit: zero or less keeps 1000.
+func NewCSPReportCollector(maxReports int) *CSPReportCollector {
+	if maxReports <= 0 {
+		maxReports = defaultMaxCSPReports
+	}
+
+	return &CSPReportCollector{maxReports: maxReports}
+}
+
+// Reports returns a copy of the collected reports, oldest first
+func (c *CSPReportCollector) Reports() []CSPViolationReport {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]CSPViolationReport(nil), c.reports...)
+}
+
+// Reset removes every collected report
+func (c *CSPReportCollector) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reports = nil
+}
+
+// add records reports, dropping the oldest ones once there are more than the maximum
+func (c *CSPReportCollector) add(reports []CSPViolationReport) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reports = append(c.reports, reports...)
+	if len(c.reports) > c.maxReports {
+		c.reports = append([]CSPViolationReport(nil), c.reports[len(c.reports)-c.maxReports:]...)
+	}
+}
+
+// NewCSPReportHandler creates a handler that accepts violation reports POSTed by browsers, in either the
+// `application/csp-report` format sent to report-uri or the `application/reports+json` format of the Reporting API,
+// and adds them to the collector. Bodies over 64 KiB are rejected with a 413 rather than parsed, though the server has
+// already read the whole body by then, so the limit doesn't bound how much a client can send.
+func NewCSPReportHandler(path Path, collector *CSPReportCollector) *Handler {
+	return NewHandlerWithError(MethodPost, path, func(request Request) (Response, error) {
+		if len(request.Body()) > maxCSPReportBodySize {
+			return nil, NewHTTPError(413, "The violation report is too large.", nil)
+		}
+
+		reports, err := parseCSPReports(request.Body())
+		if err != nil {
+			return nil, BadRequestError("The violation report is in the incorrect format.", err)
+		}
+
+		now := time.Now()
+		for i := range reports {
+			reports[i].Received = now
+		}
+
+		collector.add(reports)
+		return NewResponse(204), nil
+	})
+}
+
+// parseCSPReports parses a body in either report format
+func parseCSPReports(body []byte) ([]CSPViolationReport, error) {
+	trimmed := strings.TrimSpace(string(body))
+
+	// The Reporting API sends an array of reports of different types, of which only CSP violations are kept
+	if strings.HasPrefix(trimmed, "[") {
+		var batch []struct {
+			Type string          `json:"type"`
+			Body json.RawMessage `json:"body"`
+		}
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, err
+		}
+
+		if len(batch) > maxCSPReportsPerRequest {
+			return nil, fmt.Errorf("too many reports in one request: %d", len(batch))
+		}
+
+		reports := make([]CSPViolationReport, 0, len(batch))
+		for _, item := range batch {
+			if item.Type != "csp-violation" {
+				continue
+			}
+
+			var report CSPViolationReport
+			if err := json.Unmarshal(item.Body, &report); err != nil {
+				return nil, err
+			}
+
+			reports = append(reports, report)
+		}
+
+		return reports, nil
+	}
+
+	// The older report-uri format uses hyphenated names inside a csp-report object
+	var legacy struct {
+		Report struct {
+			DocumentURI        string `json:"document-uri"`
+			Referrer           string `json:"referrer"`
+			BlockedURI         string `json:"blocked-uri"`
+			ViolatedDirective  string `json:"violated-directive"`
+			EffectiveDirective string `json:"effective-directive"`
+			OriginalPolicy     string `json:"original-policy"`
+			Disposition        string `json:"disposition"`
+			SourceFile         string `json:"source-file"`
+			LineNumber         int    `json:"line-number"`
+			ColumnNumber       int    `json:"column-number"`
+			StatusCode         int    `json:"status-code"`
+			ScriptSample       string `json:"script-sample"`
+		} `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, err
+	}
+
+	report := legacy.Report
+	if report.EffectiveDirective == "" {
+		report.EffectiveDirective = report.ViolatedDirective
+	}
+
+	return []CSPViolationReport{{
+		DocumentURL:        report.DocumentURI,
+		Referrer:           report.Referrer,
+		BlockedURL:         report.BlockedURI,
+		EffectiveDirective: report.EffectiveDirective,
+		OriginalPolicy:     report.OriginalPolicy,
+		Disposition:        report.Disposition,
+		SourceFile:         report.SourceFile,
+		LineNumber:         report.LineNumber,
+		ColumnNumber:       report.ColumnNumber,
+		StatusCode:         report.StatusCode,
+		Sample:             report.ScriptSample,
+	}}, nil
+}
@@ -0,0 +1,182 @@
+package webserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContentSecurityPolicy_Header(t *testing.T) {
+	policy := NewContentSecurityPolicy().
+		Directive("default-src", "'self'").
+		Directive("script-src", "'self'", CSPNonceSource).
+		Directive("script-src", "https://cdn.example.com").
+		Directive("upgrade-insecure-requests")
+
+	expected := "default-src 'self'; script-src 'self' 'nonce-abc' https://cdn.example.com; upgrade-insecure-requests"
+	if header := policy.Header("abc"); header != expected {
+		t.Fatalf("Expected %q but received %q", expected, header)
+	}
+
+	if !policy.usesNonce() || NewContentSecurityPolicy().Directive("default-src", "'self'").usesNonce() {
+		t.Fatalf("Expected only the policy with a nonce source to use a nonce")
+	}
+}
+
+func TestNewSecurityHeadersMiddleware(t *testing.T) {
+	var nonces []string
+	handler := NewSecurityHeadersMiddleware(DefaultSecurityHeadersOptions())(func(request Request) Response {
+		nonces = append(nonces, CSPNonce(request))
+
+		response := OkResponse()
+		response.Headers().SetHeader("X-Frame-Options", "SAMEORIGIN")
+		return response
+	})
+
+	response := handler(newTestRequest(MethodGet, "/", nil))
+	handler(newTestRequest(MethodGet, "/", nil))
+
+	var expectedHeaders = map[string]string{
+		"Strict-Transport-Security":    "max-age=31536000; includeSubDomains",
+		"X-Content-Type-Options":       "nosniff",
+		"X-Frame-Options":              "SAMEORIGIN",
+		"Referrer-Policy":              "strict-origin-when-cross-origin",
+		"Cross-Origin-Opener-Policy":   "same-origin",
+		"Cross-Origin-Resource-Policy": "same-origin",
+	}
+	for header, expected := range expectedHeaders {
+		if value, _ := response.Headers().GetHeader(header); value != expected {
+			t.Errorf("Expected %s to be %q but received %q", header, expected, value)
+		}
+	}
+
+	if response.Headers().HasHeader("Cross-Origin-Embedder-Policy") {
+		t.Errorf("Expected COEP not to be sent by default")
+	}
+
+	if len(nonces) != 2 || nonces[0] == "" || nonces[0] == nonces[1] {
+		t.Fatalf("Expected a different nonce for each request but received %v", nonces)
+	}
+
+	csp, _ := response.Headers().GetHeader("Content-Security-Policy")
+	if !strings.Contains(csp, "script-src 'self' 'nonce-"+nonces[0]+"'") || !strings.Contains(csp, "frame-ancestors 'none'") {
+		t.Fatalf("Expected the policy to carry the request's nonce but received %q", csp)
+	}
+}
+
+func TestNewSecurityHeadersMiddleware_ReportOnly(t *testing.T) {
+	options := SecurityHeadersOptions{
+		ContentSecurityPolicy: NewContentSecurityPolicy().Directive("default-src", "'self'"),
+		CSPReportOnly:         true,
+		CSPReportURI:          "/csp-reports",
+	}
+
+	handler := NewSecurityHeadersMiddleware(options)(func(request Request) Response {
+		if CSPNonce(request) != "" {
+			t.Errorf("Expected no nonce when the policy doesn't use one")
+		}
+		return OkResponse()
+	})
+
+	response := handler(newTestRequest(MethodGet, "/", nil))
+	if response.Headers().HasHeader("Content-Security-Policy") || response.Headers().HasHeader("Strict-Transport-Security") {
+		t.Fatalf("Expected only the report-only policy to be sent")
+	}
+
+	if csp, _ := response.Headers().GetHeader("Content-Security-Policy-Report-Only"); csp != "default-src 'self'; report-uri /csp-reports" {
+		t.Fatalf("Expected a report-only policy with the report URI but received %q", csp)
+	}
+
+	if header := options.ContentSecurityPolicy.Header(""); header != "default-src 'self'" {
+		t.Fatalf("Expected the original policy to be left alone but received %q", header)
+	}
+}
+
+func TestWebServer_SecurityHeadersOnStaticFiles(t *testing.T) {
+	ws := NewWebServer()
+	ws.Use(NewSecurityHeadersMiddleware(DefaultSecurityHeadersOptions()))
+	ws.StaticFiles(t.TempDir())
+
+	rawResponse := serveTestRequest(t, &ws, "GET /missing.html HTTP/1.1\r\n\r\n")
+	if !strings.Contains(rawResponse, "X-Content-Type-Options: nosniff\r\n") {
+		t.Fatalf("Expected the static file handler's responses to carry security headers but received %q", rawResponse)
+	}
+}
+
+func TestNewCSPReportHandler(t *testing.T) {
+	collector := NewCSPReportCollector(2)
+	handler := NewCSPReportHandler(StringPath("/csp-reports"), collector)
+
+	var tests = []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{
+			name:     "Report URI format",
+			body:     `{"csp-report":{"document-uri":"https://example.com/","blocked-uri":"https://evil.test/x.js","violated-directive":"script-src","original-policy":"script-src 'self'","disposition":"enforce","line-number":3}}`,
+			expected: 204,
+		},
+		{
+			name:     "Reporting API format",
+			body:     `[{"type":"csp-violation","body":{"documentURL":"https://example.com/a","blockedURL":"inline","effectiveDirective":"style-src-elem","disposition":"report"}},{"type":"deprecation","body":{}}]`,
+			expected: 204,
+		},
+		{"Invalid JSON", `{"csp-report":`, 400},
+		{"Too large", `{"csp-report":{"script-sample":"` + strings.Repeat("x", maxCSPReportBodySize) + `"}}`, 413},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newTestRequest(MethodPost, "/csp-reports", nil)
+			request.body = []byte(tt.body)
+
+			response, err := handler.executeWithError(request)
+			status := 0
+			if err != nil {
+				status = err.(*HTTPError).StatusCode
+			} else {
+				status = response.StatusCode()
+			}
+
+			if status != tt.expected {
+				t.Fatalf("Expected %d but received %d", tt.expected, status)
+			}
+		})
+	}
+
+	reports := collector.Reports()
+	if len(reports) != 2 {
+		t.Fatalf("Expected 2 reports but received %d", len(reports))
+	}
+
+	if reports[0].BlockedURL != "https://evil.test/x.js" || reports[0].EffectiveDirective != "script-src" || reports[0].LineNumber != 3 {
+		t.Errorf("Expected the report-uri report to be parsed but received %+v", reports[0])
+	}
+
+	if reports[1].DocumentURL != "https://example.com/a" || reports[1].Disposition != "report" || reports[1].Received.IsZero() {
+		t.Errorf("Expected the Reporting API report to be parsed but received %+v", reports[1])
+	}
+
+	// Only the most recent reports are kept
+	collector.add([]CSPViolationReport{{BlockedURL: "third"}})
+	if reports := collector.Reports(); len(reports) != 2 || reports[1].BlockedURL != "third" {
+		t.Fatalf("Expected the oldest report to be dropped but received %+v", reports)
+	}
+
+	collector.Reset()
+	if len(collector.Reports()) != 0 {
+		t.Fatalf("Expected no reports after a reset")
+	}
+}
+
+func TestNewCSPReportCollector_DefaultLimit(t *testing.T) {
+	collector := NewCSPReportCollector(0)
+	for i := 0; i < defaultMaxCSPReports+10; i++ {
+		collector.add([]CSPViolationReport{{LineNumber: i}})
+	}
+
+	reports := collector.Reports()
+	if len(reports) != defaultMaxCSPReports || reports[0].LineNumber != 10 {
+		t.Fatalf("Expected only the %d most recent reports to be kept but received %d", defaultMaxCSPReports, len(reports))
+	}
+}
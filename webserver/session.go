@@ -0,0 +1,533 @@
+package webserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotFound is returned by session stores when there is no session for a reference
+var ErrSessionNotFound = errors.New("the session could not be found")
+
+// SessionData is everything a store keeps about a session
+type SessionData struct {
+	// ID identifies the session. It changes when the ID is regenerated.
+	ID string
+	// Values holds the values handlers have stored in the session. Stores that serialise sessions use encoding/gob, so
+	// values of types other than the basic ones must be registered with gob.Register.
+	Values map[string]any
+	// Created is when the session was created, which the absolute timeout counts from
+	Created time.Time
+	// Accessed is when the session was last used, which the idle timeout counts from
+	Accessed time.Time
+	// Expires is when the session expires if it isn't used again, so that stores can evict it. The zero time means it
+	// never expires.
+	Expires time.Time
+}
+
+// SessionStore keeps sessions between requests. The session cookie holds a reference the store returns when a session
+// is saved, which is usually the session ID but can be the session itself. Implementations must be safe for
+// concurrent use.
+type SessionStore interface {
+	// Load returns the session the reference points to, or ErrSessionNotFound
+	Load(reference string) (SessionData, error)
+	// Save stores the session and returns the reference to put in the session cookie
+	Save(data SessionData) (string, error)
+	// Delete removes the session the reference points to
+	Delete(reference string) error
+}
+
+// SessionOptions configures the session middleware
+type SessionOptions struct {
+	// Store keeps the sessions. Defaults to an in-memory store that evicts expired sessions as requests arrive, so it
+	// needs no closing.
+	Store SessionStore
+	// Secrets are the keys session cookies are signed with. The first signs new cookies and all of them are accepted,
+	// so that secrets can be rotated. If there are none, a random secret is used and sessions don't survive a restart.
+	Secrets [][]byte
+	// Cookie holds the name and attributes of the session cookie
+	Cookie Cookie
+	// IdleTimeout is how long a session lasts without being used, or zero for no limit
+	IdleTimeout time.Duration
+	// AbsoluteTimeout is how long a session lasts after it is created however much it is used, or zero for no limit
+	AbsoluteTimeout time.Duration
+}
+
+// DefaultSessionOptions returns options that keep sessions in memory, expiring them after 30 minutes of inactivity
+// or a day at most, with an HttpOnly cookie called `session`
+func DefaultSessionOptions() SessionOptions {
+	return SessionOptions{
+		Cookie: Cookie{
+			Name:     "session",
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: SameSiteLax,
+		},
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 24 * time.Hour,
+	}
+}
+
+// sessionKey is the context key the session is stored under
+type sessionKey struct{}
+
+// SessionFromRequest returns the session the session middleware attached to the request
+func SessionFromRequest(request Request) (*Session, bool) {
+	session, found := request.Context().Value(sessionKey{}).(*Session)
+	return session, found
+}
+
+// NewSessionMiddleware creates a middleware that gives each request a session. The session is only loaded from the
+// store when a handler first uses it, and only saved when it has been changed, so requests that don't touch it cost
+// nothing. The session cookie is signed so that clients can't guess or forge references.
+func NewSessionMiddleware(options SessionOptions) Middleware {
+	if options.Store == nil {
+		// Nothing could close a store with a background goroutine, so the default one evicts while it is used instead
+		options.Store = newMemorySessionStore(time.Minute)
+	}
+
+	if len(options.Secrets) == 0 {
+		options.Secrets = [][]byte{randomBytes(32)}
+	}
+
+	if options.Cookie.Name == "" {
+		options.Cookie.Name = DefaultSessionOptions().Cookie.Name
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(request Request) Response {
+			session := &Session{options: options}
+			if cookie, err := request.Cookie(options.Cookie.Name); err == nil {
+				session.reference, _ = verifySessionCookie(cookie.Value, options)
+			}
+
+			response := next(request.WithValue(sessionKey{}, session))
+
+			if err := session.commit(response); err != nil {
+				return RenderError(request, InternalError(err))
+			}
+
+			return response
+		}
+	}
+}
+
+// Session holds values that persist across a client's requests. It is loaded from the store the first time it is
+// used.
+type Session struct {
+	options SessionOptions
+
+	mutex sync.Mutex
+	// reference is what the session cookie points to, or empty if the session hasn't been saved
+	reference string
+	loaded    bool
+	data      SessionData
+	isNew     bool
+	// modified is set when the session needs saving
+	modified bool
+	// stale is a reference that must be deleted from the store, after the session is destroyed or its ID regenerated
+	stale string
+	// loadErr is why the session couldn't be loaded from the store, which fails the request once the handler returns
+	loadErr error
+}
+
+// ID returns the session's ID
+func (s *Session) ID() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.load()
+	return s.data.ID
+}
+
+// IsNew returns whether the session was created by this request rather than loaded from the store
+func (s *Session) IsNew() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.load()
+	return s.isNew
+}
+
+// Get returns the value stored under the key
+func (s *Session) Get(key string) (any, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.load()
+	value, found := s.data.Values[key]
+	return value, found
+}
+
+// Set stores a value under the key
+func (s *Session) Set(key string, value any) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.load()
+	s.data.Values[key] = value
+	s.modified = true
+}
+
+// Delete removes the value stored under the key
+func (s *Session) Delete(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.load()
+	if _, found := s.data.Values[key]; found {
+		delete(s.data.Values, key)
+		s.modified = true
+	}
+}
+
+// RegenerateID gives the session a new ID, keeping its values. Call it when a user logs in so that an ID an attacker
+// planted before then is no longer any use to them.
+func (s *Session) RegenerateID() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.load()
+	if s.reference != "" {
+		s.stale = s.reference
+		s.reference = ""
+	}
+
+	s.data.ID = newSessionID()
+	s.modified = true
+}
+
+// Destroy deletes the session, e.g. when the user logs out. If the request stores anything in the session
+// afterwards, it starts a new one.
+func (s *Session) Destroy() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.load()
+	if s.reference != "" {
+		s.stale = s.reference
+		s.reference = ""
+	}
+
+	s.data = newSessionData(time.Now())
+	s.isNew = true
+	s.modified = false
+}
+
+// load fetches the session from the store the first time it is needed, starting a new session if there isn't a valid
+// one. The session is only saved again for being accessed once the access time is noticeably out of date. If the store
+// fails, the handler is given an empty session, which is never saved so that the stored one isn't overwritten.
+func (s *Session) load() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+
+	now := time.Now()
+	if s.reference != "" {
+		data, err := s.options.Store.Load(s.reference)
+		if err != nil && !errors.Is(err, ErrSessionNotFound) {
+			s.loadErr = fmt.Errorf("failed to load session: %w", err)
+			s.data = newSessionData(now)
+			return
+		}
+
+		if err == nil && !s.expired(data, now) {
+			if data.Values == nil {
+				data.Values = make(map[string]any)
+			}
+
+			s.modified = now.Sub(data.Accessed) >= s.touchInterval()
+			data.Accessed = now
+			s.data = data
+			return
+		}
+
+		if err == nil {
+			_ = s.options.Store.Delete(s.reference)
+		}
+
+		s.reference = ""
+	}
+
+	s.data = newSessionData(now)
+	s.isNew = true
+}
+
+// commit saves the session if it was changed and sets the session cookie on the response. Sessions that were never
+// used are left alone.
+func (s *Session) commit(response Response) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.loaded {
+		return nil
+	}
+
+	if s.loadErr != nil {
+		return s.loadErr
+	}
+
+	if s.stale != "" {
+		if err := s.options.Store.Delete(s.stale); err != nil {
+			return err
+		}
+	}
+
+	cookie := s.options.Cookie
+	if !s.modified {
+		if s.stale != "" {
+			cookie.MaxAge = -1
+			cookie.Expires = time.Time{}
+			response.SetCookie(cookie)
+		}
+
+		return nil
+	}
+
+	s.data.Expires = s.expiry()
+
+	reference, err := s.options.Store.Save(s.data)
+	if err != nil {
+		return err
+	}
+
+	s.reference = reference
+	cookie.Value = signSessionCookie(reference, s.options.Cookie.Name, s.options.Secrets[0])
+	response.SetCookie(cookie)
+
+	return nil
+}
+
+// expired returns whether the session has been idle for too long or has reached its absolute timeout
+func (s *Session) expired(data SessionData, now time.Time) bool {
+	if s.options.IdleTimeout > 0 && !now.Before(data.Accessed.Add(s.options.IdleTimeout)) {
+		return true
+	}
+
+	return s.options.AbsoluteTimeout > 0 && !now.Before(data.Created.Add(s.options.AbsoluteTimeout))
+}
+
+// expiry returns when the session expires if it isn't used again
+func (s *Session) expiry() time.Time {
+	var expires time.Time
+	if s.options.IdleTimeout > 0 {
+		expires = s.data.Accessed.Add(s.options.IdleTimeout)
+	}
+
+	if s.options.AbsoluteTimeout > 0 {
+		absolute := s.data.Created.Add(s.options.AbsoluteTimeout)
+		if expires.IsZero() || absolute.Before(expires) {
+			expires = absolute
+		}
+	}
+
+	return expires
+}
+
+// touchInterval is how out of date the stored access time may get before an unmodified session is saved to update
+// it, which keeps sessions that are only read from being saved on every request
+func (s *Session) touchInterval() time.Duration {
+	if s.options.IdleTimeout <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return min(s.options.IdleTimeout/10, time.Minute)
+}
+
+// newSessionData creates an empty session with a new ID
+func newSessionData(now time.Time) SessionData {
+	return SessionData{
+		ID:       newSessionID(),
+		Values:   make(map[string]any),
+		Created:  now,
+		Accessed: now,
+	}
+}
+
+// newSessionID returns a random 256 bit session ID
+func newSessionID() string {
+	return base64.RawURLEncoding.EncodeToString(randomBytes(32))
+}
+
+// randomBytes returns n bytes from the cryptographic random number generator
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
+
+// signSessionCookie appends a signature to the reference, which also covers the cookie name so that a value can't be
+// moved to a different cookie
+func signSessionCookie(reference string, name string, secret []byte) string {
+	return reference + "." + base64.RawURLEncoding.EncodeToString(sessionSignature(reference, name, secret))
+}
+
+// verifySessionCookie checks the cookie's signature against each of the secrets, returning the reference if one
+// matches
+func verifySessionCookie(value string, options SessionOptions) (string, bool) {
+	separator := strings.LastIndexByte(value, '.')
+	if separator < 0 {
+		return "", false
+	}
+
+	reference := value[:separator]
+	signature, err := base64.RawURLEncoding.DecodeString(value[separator+1:])
+	if err != nil {
+		return "", false
+	}
+
+	for _, secret := range options.Secrets {
+		if hmac.Equal(signature, sessionSignature(reference, options.Cookie.Name, secret)) {
+			return reference, true
+		}
+	}
+
+	return "", false
+}
+
+// sessionSignature returns the HMAC-SHA256 of the cookie name and reference
+func sessionSignature(reference string, name string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(name + "=" + reference))
+	return mac.Sum(nil)
+}
+
+// MemorySessionStore is a SessionStore that keeps sessions in memory, so they are lost when the server restarts.
+// Expired sessions are evicted in the background.
+type MemorySessionStore struct {
+	mutex    sync.Mutex
+	sessions map[string]SessionData
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// evictInterval is how often Load and Save evict expired sessions, or zero if they don't
+	evictInterval time.Duration
+	lastEvicted   time.Time
+}
+
+// NewMemorySessionStore creates an in-memory store that evicts expired sessions at the given interval until Close is
+// called
+func NewMemorySessionStore(cleanupInterval time.Duration) *MemorySessionStore {
+	store := &MemorySessionStore{
+		sessions: make(map[string]SessionData),
+		stop:     make(chan struct{}),
+	}
+
+	if cleanupInterval > 0 {
+		go store.cleanup(cleanupInterval)
+	}
+
+	return store
+}
+
+// newMemorySessionStore creates an in-memory store that evicts expired sessions while loading and saving, at most once
+// per interval, so it has no goroutine to stop
+func newMemorySessionStore(evictInterval time.Duration) *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions:      make(map[string]SessionData),
+		stop:          make(chan struct{}),
+		evictInterval: evictInterval,
+	}
+}
+
+// Load returns a copy of the session with the ID, so that changes aren't seen by other requests until it is saved
+func (s *MemorySessionStore) Load(reference string) (SessionData, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.maybeEvictLocked(time.Now())
+
+	data, found := s.sessions[reference]
+	if !found {
+		return SessionData{}, ErrSessionNotFound
+	}
+
+	data.Values = maps.Clone(data.Values)
+	return data, nil
+}
+
+// Save stores a copy of the session under its ID
+func (s *MemorySessionStore) Save(data SessionData) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.maybeEvictLocked(time.Now())
+
+	data.Values = maps.Clone(data.Values)
+	s.sessions[data.ID] = data
+	return data.ID, nil
+}
+
+// Delete removes the session with the ID
+func (s *MemorySessionStore) Delete(reference string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, reference)
+	return nil
+}
+
+// Len returns the number of sessions the store holds
+func (s *MemorySessionStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.sessions)
+}
+
+// Close stops evicting expired sessions in the background
+func (s *MemorySessionStore) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// cleanup evicts expired sessions at the interval until the store is closed
+func (s *MemorySessionStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.evict(now)
+		}
+	}
+}
+
+// evict removes every session that has expired by the given time
+func (s *MemorySessionStore) evict(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.evictLocked(now)
+}
+
+// maybeEvictLocked evicts expired sessions if the evict interval has passed since it last did. The mutex must be held.
+func (s *MemorySessionStore) maybeEvictLocked(now time.Time) {
+	if s.evictInterval > 0 && now.Sub(s.lastEvicted) >= s.evictInterval {
+		s.evictLocked(now)
+		s.lastEvicted = now
+	}
+}
+
+// evictLocked removes every session that has expired by the given time. The mutex must be held.
+func (s *MemorySessionStore) evictLocked(now time.Time) {
+	for id, data := range s.sessions {
+		if !data.Expires.IsZero() && !now.Before(data.Expires) {
+			delete(s.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package webserver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSessionTooLarge is returned by the cookie session store when a session is too large to fit in a cookie
+var ErrSessionTooLarge = errors.New("the session is too large to store in a cookie")
+
+// maxSessionCookieLength leaves room for the cookie's name, signature and attributes within the 4096 bytes browsers
+// allow each cookie
+const maxSessionCookieLength = 3800
+
+// sessionFileExtension is added to the session ID to name each session's file
+const sessionFileExtension = ".session"
+
+// FileSessionStore is a SessionStore that keeps each session in a file named after its ID, so sessions survive
+// restarts. Expired sessions are deleted in the background.
+type FileSessionStore struct {
+	dir string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewFileSessionStore creates a store that keeps sessions in the directory, creating it if needed, and deletes expired
+// sessions at the given interval until Close is called
+func NewFileSessionStore(dir string, cleanupInterval time.Duration) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+
+	store := &FileSessionStore{
+		dir:  dir,
+		stop: make(chan struct{}),
+	}
+
+	if cleanupInterval > 0 {
+		go store.cleanup(cleanupInterval)
+	}
+
+	return store, nil
+}
+
+// Load reads the session with the ID from its file
+func (s *FileSessionStore) Load(reference string) (SessionData, error) {
+	path, valid := s.path(reference)
+	if !valid {
+		return SessionData{}, ErrSessionNotFound
+	}
+
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return SessionData{}, ErrSessionNotFound
+	} else if err != nil {
+		return SessionData{}, fmt.Errorf("failed to read session file: %w", err)
+	}
+
+	return decodeSessionData(contents)
+}
+
+// Save writes the session to its file. The file is written under a temporary name and then renamed, so a session is
+// never read half-written.
+func (s *FileSessionStore) Save(data SessionData) (string, error) {
+	path, valid := s.path(data.ID)
+	if !valid {
+		return "", fmt.Errorf("invalid session ID %q", data.ID)
+	}
+
+	contents, err := encodeSessionData(data)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.CreateTemp(s.dir, "*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to write session file: %w", err)
+	}
+
+	_, err = file.Write(contents)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write session file: %w", err)
+	}
+
+	return data.ID, nil
+}
+
+// Delete removes the session's file
+func (s *FileSessionStore) Delete(reference string) error {
+	path, valid := s.path(reference)
+	if !valid {
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete session file: %w", err)
+	}
+
+	return nil
+}
+
+// Close stops deleting expired sessions in the background
+func (s *FileSessionStore) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// path returns the file the session with the ID is kept in. IDs are restricted to the base64url alphabet so that they
+// can't point outside the directory.
+func (s *FileSessionStore) path(id string) (string, bool) {
+	if id == "" {
+		return "", false
+	}
+
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+			return "", false
+		}
+	}
+
+	return filepath.Join(s.dir, id+sessionFileExtension), true
+}
+
+// cleanup deletes expired sessions at the interval until the store is closed
+func (s *FileSessionStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.evict(now)
+		}
+	}
+}
+
+// evict deletes every session that has expired by the given time, along with any that can't be read
+func (s *FileSessionStore) evict(now time.Time) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		id, isSession := strings.CutSuffix(entry.Name(), sessionFileExtension)
+		if !isSession || entry.IsDir() {
+			continue
+		}
+
+		data, err := s.Load(id)
+		if errors.Is(err, ErrSessionNotFound) {
+			continue
+		}
+
+		if err != nil || (!data.Expires.IsZero() && !now.Before(data.Expires)) {
+			_ = s.Delete(id)
+		}
+	}
+}
+
+// CookieSessionStore is a SessionStore that keeps the whole session in the session cookie, encrypted and
+// authenticated with AES-GCM so that clients can neither read nor change it. Sessions must stay small. The IDs of
+// destroyed and regenerated sessions are remembered in memory until the sessions would have expired, so that their
+// old cookies stop working; that list is lost on restart and isn't shared between servers.
+type CookieSessionStore struct {
+	ciphers []cipher.AEAD
+
+	mutex sync.Mutex
+	// revoked holds the IDs of deleted sessions along with when they stop being remembered
+	revoked map[string]time.Time
+	// revocationTTL is how long the IDs of deleted sessions that never expire are remembered for
+	revocationTTL time.Duration
+}
+
+// defaultCookieRevocationTTL is how long a cookie session store remembers deleted sessions that never expire, which
+// matches the default absolute timeout
+const defaultCookieRevocationTTL = 24 * time.Hour
+
+// NewCookieSessionStore creates a store that encrypts sessions with the first key and decrypts them with any of the
+// keys, so that keys can be rotated. Keys must be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256.
+func NewCookieSessionStore(keys ...[]byte) (*CookieSessionStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("the cookie session store needs at least one key")
+	}
+
+	store := &CookieSessionStore{revoked: make(map[string]time.Time), revocationTTL: defaultCookieRevocationTTL}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookie session key: %w", err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookie session key: %w", err)
+		}
+
+		store.ciphers = append(store.ciphers, aead)
+	}
+
+	return store, nil
+}
+
+// SetRevocationTTL sets how long the IDs of deleted sessions without an expiry are remembered for, which should be at
+// least the session cookie's MaxAge. By default, they are remembered for a day.
+func (s *CookieSessionStore) SetRevocationTTL(ttl time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.revocationTTL = ttl
+}
+
+// Load decrypts the session from the cookie's reference. References that were tampered with, encrypted with an
+// unknown key or belong to a deleted session are treated as missing sessions.
+func (s *CookieSessionStore) Load(reference string) (SessionData, error) {
+	contents, err := s.open(reference)
+	if err != nil {
+		return SessionData{}, err
+	}
+
+	data, err := decodeSessionData(contents)
+	if err != nil {
+		return SessionData{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if expires, revoked := s.revoked[data.ID]; revoked && time.Now().Before(expires) {
+		return SessionData{}, ErrSessionNotFound
+	}
+
+	return data, nil
+}
+
+// open decrypts a reference with whichever key it was encrypted with
+func (s *CookieSessionStore) open(reference string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(reference)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+
+	for _, aead := range s.ciphers {
+		if len(sealed) < aead.NonceSize() {
+			continue
+		}
+
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		contents, err := aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			continue
+		}
+
+		return contents, nil
+	}
+
+	return nil, ErrSessionNotFound
+}
+
+// Save encrypts the session into the reference that the cookie holds
+func (s *CookieSessionStore) Save(data SessionData) (string, error) {
+	contents, err := encodeSessionData(data)
+	if err != nil {
+		return "", err
+	}
+
+	aead := s.ciphers[0]
+	nonce := randomBytes(aead.NonceSize())
+	reference := base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, contents, nil))
+
+	if len(reference) > maxSessionCookieLength {
+		return "", ErrSessionTooLarge
+	}
+
+	return reference, nil
+}
+
+// Delete revokes the session's ID, so that every cookie holding the session is rejected from then on. The middleware
+// also expires the cookie.
+func (s *CookieSessionStore) Delete(reference string) error {
+	contents, err := s.open(reference)
+	if err != nil {
+		return nil
+	}
+
+	data, err := decodeSessionData(contents)
+	if err != nil {
+		return nil
+	}
+
+	s.revoke(data, time.Now())
+	return nil
+}
+
+// revoke remembers the session's ID until it would have expired, or for the revocation TTL if it never expires, and
+// forgets the IDs whose time is up
+func (s *CookieSessionStore) revoke(data SessionData, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Sessions that have expired by now would be rejected anyway, so they needn't be remembered
+	for id, expires := range s.revoked {
+		if !now.Before(expires) {
+			delete(s.revoked, id)
+		}
+	}
+
+	expires := data.Expires
+	if expires.IsZero() {
+		expires = now.Add(s.revocationTTL)
+	}
+
+	s.revoked[data.ID] = expires
+}
+
+// encodeSessionData serialises a session with encoding/gob
+func encodeSessionData(data SessionData) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(data); err != nil {
+		return nil, fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// decodeSessionData deserialises a session written by encodeSessionData
+func decodeSessionData(contents []byte) (SessionData, error) {
+	var data SessionData
+	if err := gob.NewDecoder(bytes.NewReader(contents)).Decode(&data); err != nil {
+		return SessionData{}, fmt.Errorf("failed to decode session: %w", err)
+	}
+
+	return data, nil
+}
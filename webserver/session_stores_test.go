@@ -0,0 +1,192 @@
+package webserver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSessionStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	store, err := NewFileSessionStore(dir, 0)
+	if err != nil {
+		t.Fatalf("Received an error creating the store: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now().Truncate(time.Second)
+	data := SessionData{ID: newSessionID(), Values: map[string]any{"user": "alice", "visits": 3}, Created: now, Accessed: now}
+
+	reference, err := store.Save(data)
+	if err != nil || reference != data.ID {
+		t.Fatalf("Expected the session to be saved under its ID but received %q, %v", reference, err)
+	}
+
+	loaded, err := store.Load(reference)
+	if err != nil {
+		t.Fatalf("Received an error loading the session: %v", err)
+	}
+
+	if loaded.Values["user"] != "alice" || loaded.Values["visits"] != 3 || !loaded.Created.Equal(now) {
+		t.Fatalf("Expected the session to round trip but received %+v", loaded)
+	}
+
+	if err := store.Delete(reference); err != nil {
+		t.Fatalf("Received an error deleting the session: %v", err)
+	}
+
+	if _, err := store.Load(reference); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected ErrSessionNotFound after deleting but received %v", err)
+	}
+
+	if err := store.Delete(reference); err != nil {
+		t.Fatalf("Expected deleting a missing session to succeed but received %v", err)
+	}
+}
+
+func TestFileSessionStore_RejectsPathsOutsideTheDirectory(t *testing.T) {
+	store, err := NewFileSessionStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Received an error creating the store: %v", err)
+	}
+	defer store.Close()
+
+	if _, err := store.Save(SessionData{ID: "../escape"}); err == nil {
+		t.Fatalf("Expected an ID with a path in it to be rejected")
+	}
+
+	if _, err := store.Load("../../etc/passwd"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected ErrSessionNotFound but received %v", err)
+	}
+}
+
+func TestFileSessionStore_Evict(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileSessionStore(dir, 0)
+	if err != nil {
+		t.Fatalf("Received an error creating the store: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	_, _ = store.Save(SessionData{ID: "expired", Expires: now.Add(-time.Second)})
+	_, _ = store.Save(SessionData{ID: "active", Expires: now.Add(time.Second)})
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.session"), []byte("not gob"), 0o600); err != nil {
+		t.Fatalf("Could not write the corrupt session: %v", err)
+	}
+
+	store.evict(now)
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "active.session" {
+		t.Fatalf("Expected only the active session to remain but found %v", entries)
+	}
+}
+
+func TestCookieSessionStore(t *testing.T) {
+	oldKey := []byte("0123456789abcdef0123456789abcdef")
+	newKey := []byte("fedcba9876543210fedcba9876543210")
+
+	oldStore, err := NewCookieSessionStore(oldKey)
+	if err != nil {
+		t.Fatalf("Received an error creating the store: %v", err)
+	}
+
+	data := SessionData{ID: newSessionID(), Values: map[string]any{"user": "alice"}}
+	reference, err := oldStore.Save(data)
+	if err != nil {
+		t.Fatalf("Received an error saving the session: %v", err)
+	}
+
+	// Rotating the key keeps existing cookies readable
+	store, err := NewCookieSessionStore(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("Received an error creating the store: %v", err)
+	}
+
+	loaded, err := store.Load(reference)
+	if err != nil || loaded.ID != data.ID || loaded.Values["user"] != "alice" {
+		t.Fatalf("Expected the session to round trip but received %+v, %v", loaded, err)
+	}
+
+	tampered := []byte(reference)
+	tampered[len(tampered)/2] ^= 1
+	if _, err := store.Load(string(tampered)); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected a tampered cookie to be rejected but received %v", err)
+	}
+
+	newOnly, _ := NewCookieSessionStore(newKey)
+	if _, err := newOnly.Load(reference); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected a cookie encrypted with an unknown key to be rejected but received %v", err)
+	}
+
+	if _, err := NewCookieSessionStore([]byte("short")); err == nil {
+		t.Fatalf("Expected a key of the wrong length to be rejected")
+	}
+}
+
+func TestCookieSessionStore_RevocationsExpire(t *testing.T) {
+	store, err := NewCookieSessionStore([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("Received an error creating the store: %v", err)
+	}
+	store.SetRevocationTTL(time.Hour)
+
+	now := time.Now()
+	store.revoke(SessionData{ID: "forever"}, now)
+	store.revoke(SessionData{ID: "expiring", Expires: now.Add(2 * time.Hour)}, now)
+
+	// Sessions without an expiry are forgotten once the TTL is up, while the rest are kept until they expire
+	store.revoke(SessionData{ID: "later", Expires: now.Add(3 * time.Hour)}, now.Add(time.Hour))
+	if _, found := store.revoked["forever"]; found {
+		t.Fatalf("Expected the session without an expiry to be forgotten after the TTL")
+	}
+
+	if _, found := store.revoked["expiring"]; !found {
+		t.Fatalf("Expected the session to be remembered until it expires")
+	}
+
+	store.revoke(SessionData{ID: "last"}, now.Add(2*time.Hour))
+	if _, found := store.revoked["expiring"]; found || len(store.revoked) != 2 {
+		t.Fatalf("Expected the expired session to be forgotten but found %v", store.revoked)
+	}
+}
+
+func TestNewSessionMiddleware_CookieStore(t *testing.T) {
+	store, err := NewCookieSessionStore([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("Received an error creating the store: %v", err)
+	}
+
+	options := DefaultSessionOptions()
+	options.Store = store
+
+	client := newSessionTestClient(t, options, sessionTestHandler)
+	client.send("/set")
+
+	if response := client.send("/get"); string(response.Body()) != "alice" {
+		t.Fatalf("Expected the session to be read back from the cookie but received %q", string(response.Body()))
+	}
+
+	// Cookies from before the ID was regenerated or the session destroyed must stop working
+	beforeLogin := client.cookie
+	client.send("/login")
+	if response := client.send("/get"); string(response.Body()) != "alice" {
+		t.Fatalf("Expected the values to survive the new ID but received %q", string(response.Body()))
+	}
+	beforeLogout := client.cookie
+
+	client.send("/logout")
+	if client.cookie != "" {
+		t.Fatalf("Expected the cookie to be expired on logout")
+	}
+
+	for _, cookie := range []string{beforeLogin, beforeLogout} {
+		client.cookie = cookie
+		if response := client.send("/get"); string(response.Body()) != "" {
+			t.Fatalf("Expected an old cookie to be rejected but received %q", string(response.Body()))
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package webserver
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingSessionStore wraps a store, counting how often it is used
+type countingSessionStore struct {
+	SessionStore
+	loads, saves, deletes int
+}
+
+func (s *countingSessionStore) Load(reference string) (SessionData, error) {
+	s.loads++
+	return s.SessionStore.Load(reference)
+}
+
+func (s *countingSessionStore) Save(data SessionData) (string, error) {
+	s.saves++
+	return s.SessionStore.Save(data)
+}
+
+func (s *countingSessionStore) Delete(reference string) error {
+	s.deletes++
+	return s.SessionStore.Delete(reference)
+}
+
+// sessionTestClient sends requests through the session middleware, keeping the session cookie between them like a
+// browser would
+type sessionTestClient struct {
+	t       *testing.T
+	handler HandlerFunc
+	cookie  string
+}
+
+func newSessionTestClient(t *testing.T, options SessionOptions, handler HandlerFunc) *sessionTestClient {
+	return &sessionTestClient{t: t, handler: NewSessionMiddleware(options)(handler)}
+}
+
+func (c *sessionTestClient) send(path string) Response {
+	headers := map[string]string{}
+	if c.cookie != "" {
+		headers["Cookie"] = "session=" + c.cookie
+	}
+
+	response := c.handler(newTestRequest(MethodGet, path, headers))
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == "session" {
+			c.cookie = cookie.Value
+			if cookie.MaxAge < 0 {
+				c.cookie = ""
+			}
+		}
+	}
+
+	return response
+}
+
+// sessionTestHandler reads and writes the session depending on the path
+func sessionTestHandler(request Request) Response {
+	session, found := SessionFromRequest(request)
+	if !found {
+		return InternalErrorResponse()
+	}
+
+	switch request.Path() {
+	case "/set":
+		session.Set("user", "alice")
+	case "/login":
+		session.RegenerateID()
+	case "/logout":
+		session.Destroy()
+	case "/get":
+		user, _ := session.Get("user")
+		name, _ := user.(string)
+		return OkResponseWithBody([]byte(name))
+	case "/id":
+		return OkResponseWithBody([]byte(session.ID()))
+	}
+
+	return OkResponse()
+}
+
+func TestNewSessionMiddleware(t *testing.T) {
+	store := &countingSessionStore{SessionStore: NewMemorySessionStore(0)}
+
+	options := DefaultSessionOptions()
+	options.Store = store
+	options.Secrets = [][]byte{[]byte("secret")}
+
+	client := newSessionTestClient(t, options, sessionTestHandler)
+
+	// Requests that don't use the session don't load or save it
+	if response := client.send("/"); len(response.Cookies()) != 0 || store.loads != 0 || store.saves != 0 {
+		t.Fatalf("Expected an unused session to be left alone but received %d cookies", len(response.Cookies()))
+	}
+
+	// Reading a new session doesn't save it
+	if response := client.send("/get"); len(response.Cookies()) != 0 || store.saves != 0 {
+		t.Fatalf("Expected an unmodified session not to be saved")
+	}
+
+	response := client.send("/set")
+	if store.saves != 1 || client.cookie == "" {
+		t.Fatalf("Expected a modified session to be saved")
+	}
+
+	cookie := response.Cookies()[0]
+	if !cookie.HttpOnly || cookie.Path != "/" || cookie.SameSite != SameSiteLax {
+		t.Errorf("Expected the cookie attributes from the options but received %+v", cookie)
+	}
+
+	if response := client.send("/get"); string(response.Body()) != "alice" || store.saves != 1 {
+		t.Fatalf("Expected the value to be read without saving but received %q after %d saves", string(response.Body()), store.saves)
+	}
+
+	// Regenerating the ID keeps the values but deletes the old session
+	oldID := string(client.send("/id").Body())
+	client.send("/login")
+	newID := string(client.send("/id").Body())
+	if oldID == newID || store.deletes != 1 {
+		t.Fatalf("Expected a new ID and the old session to be deleted")
+	}
+
+	if _, err := store.SessionStore.Load(oldID); err == nil {
+		t.Fatalf("Expected the old session to be gone")
+	}
+
+	if response := client.send("/get"); string(response.Body()) != "alice" {
+		t.Fatalf("Expected the values to survive regeneration but received %q", string(response.Body()))
+	}
+
+	response = client.send("/logout")
+	if len(response.Cookies()) != 1 || response.Cookies()[0].MaxAge >= 0 || store.SessionStore.(*MemorySessionStore).Len() != 0 {
+		t.Fatalf("Expected the session to be deleted and the cookie expired")
+	}
+}
+
+func TestNewSessionMiddleware_RejectsForgedCookies(t *testing.T) {
+	store := NewMemorySessionStore(0)
+
+	options := DefaultSessionOptions()
+	options.Store = store
+	options.Secrets = [][]byte{[]byte("secret")}
+
+	client := newSessionTestClient(t, options, sessionTestHandler)
+	client.send("/set")
+
+	id := string(client.send("/id").Body())
+	validCookie := client.cookie
+
+	var tests = []struct {
+		name   string
+		cookie string
+	}{
+		{"Unsigned ID", id},
+		{"Wrong signature", id + ".AAAA"},
+		{"Signed with another secret", signSessionCookie(id, "session", []byte("other"))},
+		{"Signed for another cookie", signSessionCookie(id, "other", []byte("secret"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client.cookie = tt.cookie
+			if response := client.send("/get"); string(response.Body()) != "" {
+				t.Errorf("Expected the forged cookie to be ignored")
+			}
+		})
+	}
+
+	// Rotating the secret keeps existing cookies valid
+	options.Secrets = [][]byte{[]byte("new secret"), []byte("secret")}
+	rotated := newSessionTestClient(t, options, sessionTestHandler)
+	rotated.cookie = validCookie
+	if response := rotated.send("/get"); string(response.Body()) != "alice" {
+		t.Fatalf("Expected a cookie signed with an old secret to be accepted")
+	}
+}
+
+func TestSession_Expiry(t *testing.T) {
+	now := time.Now()
+
+	var tests = []struct {
+		name     string
+		data     SessionData
+		expected bool
+	}{
+		{"Fresh", SessionData{Created: now.Add(-time.Hour), Accessed: now.Add(-time.Minute)}, false},
+		{"Idle", SessionData{Created: now.Add(-time.Hour), Accessed: now.Add(-31 * time.Minute)}, true},
+		{"Too old", SessionData{Created: now.Add(-25 * time.Hour), Accessed: now}, true},
+	}
+
+	session := &Session{options: DefaultSessionOptions()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if session.expired(tt.data, now) != tt.expected {
+				t.Errorf("Expected %v", tt.expected)
+			}
+		})
+	}
+
+	session.data = SessionData{Created: now.Add(-23*time.Hour - 50*time.Minute), Accessed: now}
+	if expires := session.expiry(); !expires.Equal(now.Add(10 * time.Minute)) {
+		t.Errorf("Expected the absolute timeout to cap the expiry but received %v", expires.Sub(now))
+	}
+}
+
+func TestNewSessionMiddleware_ExpiredSessionsStartAgain(t *testing.T) {
+	store := NewMemorySessionStore(0)
+
+	options := DefaultSessionOptions()
+	options.Store = store
+	options.IdleTimeout = 50 * time.Millisecond
+
+	client := newSessionTestClient(t, options, sessionTestHandler)
+	client.send("/set")
+
+	time.Sleep(60 * time.Millisecond)
+
+	if response := client.send("/get"); string(response.Body()) != "" {
+		t.Fatalf("Expected the idle session to have expired")
+	}
+
+	if store.Len() != 0 {
+		t.Fatalf("Expected the expired session to be deleted")
+	}
+}
+
+func TestNewSessionMiddleware_TouchesIdleSessions(t *testing.T) {
+	store := &countingSessionStore{SessionStore: NewMemorySessionStore(0)}
+
+	options := DefaultSessionOptions()
+	options.Store = store
+	options.IdleTimeout = 100 * time.Millisecond
+
+	client := newSessionTestClient(t, options, sessionTestHandler)
+	client.send("/set")
+
+	// Reads soon after a save don't save again
+	client.send("/get")
+	if store.saves != 1 {
+		t.Fatalf("Expected no save for a recently accessed session but received %d", store.saves)
+	}
+
+	// Reads once the access time is out of date save it, keeping the session alive
+	for i := 0; i < 4; i++ {
+		time.Sleep(40 * time.Millisecond)
+		if response := client.send("/get"); string(response.Body()) != "alice" {
+			t.Fatalf("Expected the session to be kept alive by being used")
+		}
+	}
+
+	if store.saves < 3 {
+		t.Fatalf("Expected the access time to be saved but received %d saves", store.saves)
+	}
+}
+
+func TestNewSessionMiddleware_SaveError(t *testing.T) {
+	store, err := NewCookieSessionStore(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("Received an error creating the store: %v", err)
+	}
+
+	options := DefaultSessionOptions()
+	options.Store = store
+
+	handler := NewSessionMiddleware(options)(func(request Request) Response {
+		session, _ := SessionFromRequest(request)
+		session.Set("large", strings.Repeat("x", 5000))
+		return OkResponse()
+	})
+
+	if response := handler(newTestRequest(MethodGet, "/", nil)); response.StatusCode() != 500 {
+		t.Fatalf("Expected a 500 when the session can't be saved but received %d", response.StatusCode())
+	}
+}
+
+// failingSessionStore is a store whose backend is unavailable
+type failingSessionStore struct {
+	SessionStore
+}
+
+func (s *failingSessionStore) Load(reference string) (SessionData, error) {
+	return SessionData{}, errors.New("the backend is unavailable")
+}
+
+func TestNewSessionMiddleware_LoadError(t *testing.T) {
+	store := &countingSessionStore{SessionStore: NewMemorySessionStore(0)}
+
+	options := DefaultSessionOptions()
+	options.Store = store
+
+	client := newSessionTestClient(t, options, sessionTestHandler)
+	client.send("/set")
+	saves := store.saves
+
+	// The stored session must not be replaced by the empty one the handler was given
+	store.SessionStore = &failingSessionStore{SessionStore: store.SessionStore}
+	if response := client.send("/set"); response.StatusCode() != 500 {
+		t.Fatalf("Expected a 500 when the session can't be loaded but received %d", response.StatusCode())
+	}
+
+	if store.saves != saves {
+		t.Fatalf("Expected the session not to be saved after failing to load")
+	}
+}
+
+func TestMemorySessionStore_Evict(t *testing.T) {
+	store := NewMemorySessionStore(0)
+	now := time.Now()
+
+	_, _ = store.Save(SessionData{ID: "expired", Expires: now.Add(-time.Second)})
+	_, _ = store.Save(SessionData{ID: "active", Expires: now.Add(time.Second)})
+	_, _ = store.Save(SessionData{ID: "forever"})
+
+	store.evict(now)
+
+	if store.Len() != 2 {
+		t.Fatalf("Expected only the expired session to be evicted but %d remain", store.Len())
+	}
+
+	if _, err := store.Load("expired"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected ErrSessionNotFound but received %v", err)
+	}
+}
+
+func TestMemorySessionStore_EvictWhileUsed(t *testing.T) {
+	store := newMemorySessionStore(50 * time.Millisecond)
+
+	_, _ = store.Save(SessionData{ID: "expired", Expires: time.Now().Add(-time.Second)})
+	_, _ = store.Save(SessionData{ID: "active", Expires: time.Now().Add(time.Hour)})
+	if store.Len() != 2 {
+		t.Fatalf("Expected no sessions to be evicted within the interval but %d remain", store.Len())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := store.Load("active"); err != nil {
+		t.Fatalf("Received an error loading the active session: %v", err)
+	}
+
+	if store.Len() != 1 {
+		t.Fatalf("Expected the expired session to be evicted but %d remain", store.Len())
+	}
+}
+
+func TestNewSessionMiddleware_DefaultStoreHasNoGoroutine(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 10; i++ {
+		NewSessionMiddleware(DefaultSessionOptions())
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("Expected no goroutines to be started but there were %d before and %d after", before, after)
+	}
+}
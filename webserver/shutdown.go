@@ -0,0 +1,140 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Run and Serve once Shutdown has been called. It is also the cause of a request's
+// context being cancelled when the server shuts down before the request is handled.
+var ErrServerClosed = errors.New("the web server has been shut down")
+
+// serverState tracks the listeners and connections the web server is serving so that it can shut them down
+type serverState struct {
+	// ctx is the parent of every request's context and is cancelled when shutdown runs out of time
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	// closing is closed when shutdown starts, waking anything waiting to accept a connection
+	closing chan struct{}
+
+	mutex        sync.Mutex
+	listeners    map[net.Listener]struct{}
+	shuttingDown bool
+	connections  sync.WaitGroup
+	// idle are the connections waiting for their request, which shutdown doesn't wait for
+	idle map[net.Conn]struct{}
+}
+
+// newServerState creates the state for a web server that hasn't started serving yet
+func newServerState() *serverState {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	return &serverState{
+		ctx:       ctx,
+		cancel:    cancel,
+		closing:   make(chan struct{}),
+		listeners: make(map[net.Listener]struct{}),
+		idle:      make(map[net.Conn]struct{}),
+	}
+}
+
+// addListener records a listener so that shutdown can close it, returning false if the server is shutting down
+func (s *serverState) addListener(listener net.Listener) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.shuttingDown {
+		return false
+	}
+
+	s.listeners[listener] = struct{}{}
+	return true
+}
+
+// removeListener stops tracking a listener
+func (s *serverState) removeListener(listener net.Listener) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.listeners, listener)
+}
+
+// addConnection records a connection being served, returning false if the server is shutting down. Each successful
+// call must be followed by a call to connections.Done.
+func (s *serverState) addConnection() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.shuttingDown {
+		return false
+	}
+
+	s.connections.Add(1)
+	return true
+}
+
+// waitForRequest records that a connection is waiting for its request, so that shutdown can stop it waiting by
+// expiring its read deadline. The returned function stops tracking it and must be called once the request is read.
+func (s *serverState) waitForRequest(conn net.Conn) (done func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.shuttingDown {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	s.idle[conn] = struct{}{}
+
+	return func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		delete(s.idle, conn)
+	}
+}
+
+// isShuttingDown returns whether Shutdown has been called
+func (s *serverState) isShuttingDown() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.shuttingDown
+}
+
+// Shutdown stops the web server accepting connections and waits for the requests it is handling to finish. Connections
+// that haven't sent a request yet are closed straight away. If the context is done first, the remaining requests have
+// their contexts cancelled with ErrServerClosed as the cause and the context's error is returned without waiting any
+// longer.
+func (w *WebServer) Shutdown(ctx context.Context) error {
+	w.state.mutex.Lock()
+	if !w.state.shuttingDown {
+		w.state.shuttingDown = true
+		close(w.state.closing)
+	}
+	for listener := range w.state.listeners {
+		_ = listener.Close()
+	}
+	for conn := range w.state.idle {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	w.state.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.state.connections.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		w.state.cancel(ErrServerClosed)
+		return nil
+	case <-ctx.Done():
+		w.logger.Warn("Cancelling requests still being handled at shutdown")
+		w.state.cancel(ErrServerClosed)
+		return ctx.Err()
+	}
+}
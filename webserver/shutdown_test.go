@@ -0,0 +1,182 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestServer serves the web server on a random local port, returning the address and a channel that receives
+// Serve's result
+func startTestServer(t *testing.T, w *WebServer) (string, chan error) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- w.Serve(ln)
+	}()
+
+	return ln.Addr().String(), served
+}
+
+// sendTestRequest sends a raw request to the address and returns the raw response
+func sendTestRequest(t *testing.T, addr string, rawRequest string) string {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Errorf("Could not connect: %v", err)
+		return ""
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _ = conn.Write([]byte(rawRequest))
+
+	rawResponse, _ := io.ReadAll(conn)
+	return string(rawResponse)
+}
+
+func TestWebServer_ShutdownWaitsForRequests(t *testing.T) {
+	started := make(chan struct{})
+
+	ws := NewWebServer()
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/"), func(request Request) Response {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		return OkResponse()
+	}))
+
+	addr, served := startTestServer(t, &ws)
+
+	responses := make(chan string, 1)
+	go func() {
+		responses <- sendTestRequest(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ws.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected the shutdown to complete but received %v", err)
+	}
+
+	if rawResponse := <-responses; !strings.HasPrefix(rawResponse, "HTTP/1.1 200 OK\r\n") {
+		t.Fatalf("Expected the request to finish with a 200 but received %q", rawResponse)
+	}
+
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Expected Serve to return ErrServerClosed but received %v", err)
+	}
+
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatalf("Expected the listener to be closed")
+	}
+}
+
+func TestWebServer_ShutdownCancelsRequestsWhenContextDone(t *testing.T) {
+	started := make(chan struct{})
+	cause := make(chan error, 1)
+
+	ws := NewWebServer()
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/"), func(request Request) Response {
+		close(started)
+
+		select {
+		case <-request.Context().Done():
+			cause <- context.Cause(request.Context())
+		case <-time.After(5 * time.Second):
+			cause <- nil
+		}
+
+		return OkResponse()
+	}))
+
+	addr, served := startTestServer(t, &ws)
+
+	go sendTestRequest(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := ws.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the shutdown to run out of time but received %v", err)
+	}
+
+	if err := <-cause; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Expected the request's context to be cancelled by the shutdown but received %v", err)
+	}
+
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Expected Serve to return ErrServerClosed but received %v", err)
+	}
+}
+
+func TestWebServer_ServeAfterShutdown(t *testing.T) {
+	ws := NewWebServer()
+	if err := ws.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected shutting down an idle server to succeed but received %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+
+	if err := ws.Serve(ln); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Expected ErrServerClosed but received %v", err)
+	}
+}
+
+func TestWebServer_ShutdownClosesIdleConnections(t *testing.T) {
+	ws := NewWebServer()
+	addr, served := startTestServer(t, &ws)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Wait for the server to start reading from the connection
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ws.state.mutex.Lock()
+		idle := len(ws.state.idle)
+		ws.state.mutex.Unlock()
+
+		if idle == 1 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expected the connection to be waiting for a request")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ws.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected the shutdown not to wait for the idle connection but received %v", err)
+	}
+
+	if rawResponse, err := io.ReadAll(conn); err != nil || len(rawResponse) != 0 {
+		t.Fatalf("Expected the connection to be closed without a response but received %q, %v", rawResponse, err)
+	}
+
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Expected Serve to return ErrServerClosed but received %v", err)
+	}
+}
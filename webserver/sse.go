@@ -0,0 +1,253 @@
+package webserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSSEStreamClosed is returned when sending to an event stream whose client has gone or whose server is shutting down
+var ErrSSEStreamClosed = errors.New("the event stream is closed")
+
+// SSEEvent is a server-sent event
+type SSEEvent struct {
+	// ID is sent back by the browser in the Last-Event-ID header when it reconnects. The hub sets it when publishing.
+	ID string
+	// Event is the event's type, which browsers dispatch to listeners of that name. Empty events are `message` events.
+	Event string
+	// Data is the event's payload. It may span several lines.
+	Data string
+	// Retry tells the browser how long to wait before reconnecting, or zero to leave it unchanged
+	Retry time.Duration
+}
+
+// SSEOptions configures event streams
+type SSEOptions struct {
+	// HeartbeatInterval is how often to send a comment while no events are being sent, which stops proxies closing
+	// the connection and finds clients that have gone. Zero disables heartbeats.
+	HeartbeatInterval time.Duration
+	// Retry is sent when the stream opens to tell the browser how long to wait before reconnecting, or zero to use
+	// the browser's default
+	Retry time.Duration
+	// WriteTimeout is how long writing an event may take before the client is treated as gone, or zero for no limit
+	WriteTimeout time.Duration
+}
+
+// DefaultSSEOptions returns options that send a heartbeat every 15 seconds and give up on clients that can't accept an
+// event within 10 seconds
+func DefaultSSEOptions() SSEOptions {
+	return SSEOptions{
+		HeartbeatInterval: 15 * time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+}
+
+// SSEHandlerFunc sends events to a stream. The stream is closed when it returns.
+type SSEHandlerFunc func(stream *SSEStream)
+
+// NewSSEHandler creates a handler that opens an event stream for GET requests on the path
+func NewSSEHandler(path Path, options SSEOptions, handler SSEHandlerFunc, handlerOptions ...HandlerOption) *Handler {
+	return NewHandler(MethodGet, path, func(request Request) Response {
+		return StreamSSE(request, options, handler)
+	}, handlerOptions...)
+}
+
+// StreamSSE returns a response that opens an event stream, so that handlers can check the request first. Once the
+// response's headers have been written the handler sends events until it returns or the client goes.
+func StreamSSE(request Request, options SSEOptions, handler SSEHandlerFunc) Response {
+	response := OkResponse()
+	response.Headers().SetHeader("Content-Type", "text/event-stream; charset=utf-8")
+	response.Headers().SetHeader("Cache-Control", "no-cache")
+	// Stops nginx buffering the stream
+	response.Headers().SetHeader("X-Accel-Buffering", "no")
+
+	return &takeoverResponse{
+		Response: response,
+		takeover: func(ctx context.Context, conn net.Conn, reader *bufio.Reader) {
+			stream := newSSEStream(conn, request, options)
+			stream.serve(ctx, reader, handler)
+		},
+	}
+}
+
+// SSEStream is an open event stream. Its methods may be called from any goroutine.
+type SSEStream struct {
+	conn    net.Conn
+	request Request
+	options SSEOptions
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+
+	// mutex serialises writes
+	mutex sync.Mutex
+	// lastWrite is when anything was last sent, so heartbeats are only sent on quiet streams
+	lastWrite time.Time
+}
+
+// newSSEStream creates a stream on a connection whose response headers have been written
+func newSSEStream(conn net.Conn, request Request, options SSEOptions) *SSEStream {
+	// The request's context was cancelled when its handler returned, but the values it carries are still useful
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(request.Context()))
+
+	return &SSEStream{
+		conn:      conn,
+		request:   request.WithContext(ctx),
+		options:   options,
+		ctx:       ctx,
+		cancel:    cancel,
+		lastWrite: time.Now(),
+	}
+}
+
+// Request returns the request that opened the stream. Its context is the stream's.
+func (s *SSEStream) Request() Request {
+	return s.request
+}
+
+// Context returns a context that is cancelled when the client goes or the server shuts down. context.Cause reports
+// ErrClientDisconnected or ErrServerClosed.
+func (s *SSEStream) Context() context.Context {
+	return s.ctx
+}
+
+// LastEventID returns the ID of the last event the browser received before reconnecting, or an empty string if this
+// is its first connection
+func (s *SSEStream) LastEventID() string {
+	lastEventID, _ := s.request.Headers().GetHeader("Last-Event-ID")
+	return lastEventID
+}
+
+// Send writes an event to the client straight away
+func (s *SSEStream) Send(event SSEEvent) error {
+	return s.write(formatSSEEvent(event))
+}
+
+// Comment writes a comment, which browsers ignore
+func (s *SSEStream) Comment(text string) error {
+	var builder strings.Builder
+	for _, line := range sseLines(text) {
+		builder.WriteString(": " + line + "\n")
+	}
+	builder.WriteString("\n")
+
+	return s.write(builder.String())
+}
+
+// write sends part of the stream, closing the stream if the client can't take it
+func (s *SSEStream) write(text string) error {
+	if s.ctx.Err() != nil {
+		return ErrSSEStreamClosed
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.options.WriteTimeout > 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(s.options.WriteTimeout))
+	}
+
+	if _, err := s.conn.Write([]byte(text)); err != nil {
+		s.cancel(ErrClientDisconnected)
+		return fmt.Errorf("%w: %w", ErrSSEStreamClosed, err)
+	}
+
+	s.lastWrite = time.Now()
+	return nil
+}
+
+// serve runs the handler, sending heartbeats alongside it, until it returns
+func (s *SSEStream) serve(serverCtx context.Context, reader *bufio.Reader, handler SSEHandlerFunc) {
+	defer s.cancel(nil)
+
+	stop := context.AfterFunc(serverCtx, func() {
+		s.cancel(context.Cause(serverCtx))
+	})
+	defer stop()
+
+	// Clients don't send anything on an event stream, so a read only returns once the client has gone
+	go func() {
+		_, _ = io.Copy(io.Discard, reader)
+		s.cancel(ErrClientDisconnected)
+	}()
+
+	if s.options.Retry > 0 {
+		_ = s.write("retry: " + strconv.FormatInt(s.options.Retry.Milliseconds(), 10) + "\n\n")
+	}
+
+	if s.options.HeartbeatInterval > 0 {
+		go s.heartbeat()
+	}
+
+	handler(s)
+}
+
+// heartbeat sends a comment whenever the stream has been quiet for the heartbeat interval, until it closes
+func (s *SSEStream) heartbeat() {
+	ticker := time.NewTicker(s.options.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			quiet := now.Sub(s.lastWrite) >= s.options.HeartbeatInterval
+			s.mutex.Unlock()
+
+			if quiet && s.write(":\n\n") != nil {
+				return
+			}
+		}
+	}
+}
+
+// formatSSEEvent renders an event in the text/event-stream format. Line breaks in the ID and type would end those
+// fields early, so they are removed, while each line of the data gets its own field.
+func formatSSEEvent(event SSEEvent) string {
+	var builder strings.Builder
+
+	if event.ID != "" {
+		builder.WriteString("id: " + stripSSELineBreaks(event.ID) + "\n")
+	}
+
+	if event.Event != "" {
+		builder.WriteString("event: " + stripSSELineBreaks(event.Event) + "\n")
+	}
+
+	if event.Retry > 0 {
+		builder.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	for _, line := range sseLines(event.Data) {
+		builder.WriteString("data: " + line + "\n")
+	}
+	builder.WriteString("\n")
+
+	return builder.String()
+}
+
+// sseLines splits text on any of the line breaks the format recognises
+func sseLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return strings.Split(text, "\n")
+}
+
+// stripSSELineBreaks removes line breaks and NUL, which aren't allowed in a single-line field
+func stripSSELineBreaks(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == 0 {
+			return -1
+		}
+
+		return r
+	}, value)
+}
@@ -0,0 +1,227 @@
+package webserver
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sseSubscriberBuffer is how many events may wait for a slow subscriber before it is disconnected. It reconnects and
+// catches up from the hub's history.
+const sseSubscriberBuffer = 64
+
+// sseTopicRetention is how long a topic nobody is subscribed to keeps its history after its last event. Browsers
+// reconnect within seconds, so by then there is no one left to replay it to.
+const sseTopicRetention = 10 * time.Minute
+
+// SSEHub fans events published to topics out to the event streams subscribed to them. It keeps the most recent
+// events of each topic, so that browsers which reconnect with a Last-Event-ID receive the events they missed. The
+// history of a topic is dropped once it has had no events for 10 minutes and no streams are subscribed to it, so
+// topics can be created freely, e.g. one per user.
+type SSEHub struct {
+	historySize int
+
+	mutex       sync.Mutex
+	lastID      uint64
+	topics      map[string]*sseTopic
+	subscribers map[*sseSubscriber]struct{}
+	// lastPruned is when topics were last checked for being unused
+	lastPruned time.Time
+}
+
+// sseTopic holds a topic's recent events, oldest first
+type sseTopic struct {
+	history []sseHubEvent
+	// lastPublished is when the newest event was published
+	lastPublished time.Time
+}
+
+// sseHubEvent is a published event along with its position in the hub's sequence
+type sseHubEvent struct {
+	id    uint64
+	event SSEEvent
+}
+
+// sseSubscriber is a stream waiting for events from some topics
+type sseSubscriber struct {
+	topics map[string]bool
+	events chan SSEEvent
+	// dropped is closed when the subscriber fell too far behind
+	dropped chan struct{}
+}
+
+// NewSSEHub creates a hub that keeps the given number of recent events for each topic to replay
+func NewSSEHub(historySize int) *SSEHub {
+	return &SSEHub{
+		historySize: historySize,
+		topics:      make(map[string]*sseTopic),
+		subscribers: make(map[*sseSubscriber]struct{}),
+	}
+}
+
+// Publish sends an event to every stream subscribed to the topic and returns it with the ID the hub gave it. IDs
+// increase across all topics, so a stream can resume several topics from one Last-Event-ID.
+func (h *SSEHub) Publish(topic string, event SSEEvent) SSEEvent {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastID++
+	event.ID = strconv.FormatUint(h.lastID, 10)
+
+	now := time.Now()
+	if now.Sub(h.lastPruned) >= sseTopicRetention {
+		h.prune(now)
+		h.lastPruned = now
+	}
+
+	if h.historySize > 0 {
+		t, found := h.topics[topic]
+		if !found {
+			t = &sseTopic{}
+			h.topics[topic] = t
+		}
+
+		t.lastPublished = now
+		t.history = append(t.history, sseHubEvent{id: h.lastID, event: event})
+		if excess := len(t.history) - h.historySize; excess > 0 {
+			t.history = append(t.history[:0], t.history[excess:]...)
+		}
+	}
+
+	for subscriber := range h.subscribers {
+		if !subscriber.topics[topic] {
+			continue
+		}
+
+		select {
+		case subscriber.events <- event:
+		default:
+			// Publishing never waits for a slow stream
+			delete(h.subscribers, subscriber)
+			close(subscriber.dropped)
+		}
+	}
+
+	return event
+}
+
+// Subscribe sends the topics' events to the stream until it closes. If the browser is reconnecting, the events
+// published since its Last-Event-ID that are still in the history are sent first. It returns the error that ended the
+// stream.
+func (h *SSEHub) Subscribe(stream *SSEStream, topics ...string) error {
+	subscriber := &sseSubscriber{
+		topics:  make(map[string]bool, len(topics)),
+		events:  make(chan SSEEvent, sseSubscriberBuffer),
+		dropped: make(chan struct{}),
+	}
+	for _, topic := range topics {
+		subscriber.topics[topic] = true
+	}
+
+	// Subscribing and reading the history happen together so that no event is missed or sent twice
+	h.mutex.Lock()
+	missed := h.missedEvents(stream.LastEventID(), topics)
+	h.subscribers[subscriber] = struct{}{}
+	h.mutex.Unlock()
+
+	defer h.unsubscribe(subscriber)
+
+	for _, event := range missed {
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return ErrSSEStreamClosed
+		case <-subscriber.dropped:
+			return ErrSSEStreamClosed
+		case event := <-subscriber.events:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Subscribers returns how many streams are subscribed to the hub
+func (h *SSEHub) Subscribers() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return len(h.subscribers)
+}
+
+// unsubscribe stops sending events to a subscriber
+func (h *SSEHub) unsubscribe(subscriber *sseSubscriber) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(h.subscribers, subscriber)
+}
+
+// prune drops the history of every topic that has had no events for the retention period and has no subscribers. The
+// mutex must be held.
+func (h *SSEHub) prune(now time.Time) {
+	for topic, t := range h.topics {
+		if now.Sub(t.lastPublished) < sseTopicRetention {
+			continue
+		}
+
+		subscribed := false
+		for subscriber := range h.subscribers {
+			if subscriber.topics[topic] {
+				subscribed = true
+				break
+			}
+		}
+
+		if !subscribed {
+			delete(h.topics, topic)
+		}
+	}
+}
+
+// missedEvents returns the events in the topics' history published after the given ID, in the order they were
+// published. Nothing is replayed for streams that aren't reconnecting. The mutex must be held.
+func (h *SSEHub) missedEvents(lastEventID string, topics []string) []SSEEvent {
+	if lastEventID == "" {
+		return nil
+	}
+
+	// An ID the hub didn't give out, such as one from before a restart, replays the whole history
+	after, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil || after > h.lastID {
+		after = 0
+	}
+
+	missed := make([]sseHubEvent, 0)
+	seen := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		t, found := h.topics[topic]
+		if !found || seen[topic] {
+			continue
+		}
+		seen[topic] = true
+
+		for _, published := range t.history {
+			if published.id > after {
+				missed = append(missed, published)
+			}
+		}
+	}
+
+	sort.Slice(missed, func(i, j int) bool {
+		return missed[i].id < missed[j].id
+	})
+
+	events := make([]SSEEvent, len(missed))
+	for i, published := range missed {
+		events[i] = published.event
+	}
+
+	return events
+}
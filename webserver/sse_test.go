@@ -0,0 +1,319 @@
+package webserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseTestClient reads an event stream from a test server
+type sseTestClient struct {
+	t        *testing.T
+	conn     net.Conn
+	reader   *bufio.Reader
+	response string
+}
+
+// dialSSE opens an event stream with the extra headers, returning once the response head has been read
+func dialSSE(t *testing.T, addr string, extraHeaders string) *sseTestClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte("GET /events HTTP/1.1\r\nHost: localhost\r\n" + extraHeaders + "\r\n")); err != nil {
+		t.Fatalf("Could not send the request: %v", err)
+	}
+
+	client := &sseTestClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	for {
+		line, err := client.reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Could not read the response head: %v", err)
+		}
+
+		client.response += line
+		if line == "\r\n" {
+			return client
+		}
+	}
+}
+
+// readBlock reads up to the blank line that ends an event or comment
+func (c *sseTestClient) readBlock() string {
+	c.t.Helper()
+
+	var block strings.Builder
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("Could not read from the stream: %v", err)
+		}
+
+		if line == "\n" {
+			return block.String()
+		}
+		block.WriteString(line)
+	}
+}
+
+// startSSETestServer serves an event stream handler on /events and returns its address
+func startSSETestServer(t *testing.T, options SSEOptions, handler SSEHandlerFunc) string {
+	t.Helper()
+
+	ws := NewWebServer()
+	ws.AddHandler(NewSSEHandler(StringPath("/events"), options, handler))
+
+	addr, _ := startTestServer(t, &ws)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = ws.Shutdown(ctx)
+	})
+
+	return addr
+}
+
+// waitForSubscribers waits until the hub has the given number of subscribers
+func waitForSubscribers(t *testing.T, hub *SSEHub, count int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.Subscribers() != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d subscribers but there are %d", count, hub.Subscribers())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFormatSSEEvent(t *testing.T) {
+	var tests = []struct {
+		name     string
+		event    SSEEvent
+		expected string
+	}{
+		{"Data only", SSEEvent{Data: "hello"}, "data: hello\n\n"},
+		{"Empty data", SSEEvent{}, "data: \n\n"},
+		{"All fields", SSEEvent{ID: "7", Event: "update", Data: "hello", Retry: 3 * time.Second}, "id: 7\nevent: update\nretry: 3000\ndata: hello\n\n"},
+		{"Multiline data", SSEEvent{Data: "one\ntwo\r\nthree\rfour"}, "data: one\ndata: two\ndata: three\ndata: four\n\n"},
+		{"Line breaks in fields", SSEEvent{ID: "1\n2", Event: "up\r\ndate", Data: "x"}, "id: 12\nevent: update\ndata: x\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if formatted := formatSSEEvent(tt.event); formatted != tt.expected {
+				t.Errorf("Expected %q but received %q", tt.expected, formatted)
+			}
+		})
+	}
+}
+
+func TestSSEStream(t *testing.T) {
+	options := DefaultSSEOptions()
+	options.Retry = 2 * time.Second
+
+	addr := startSSETestServer(t, options, func(stream *SSEStream) {
+		_ = stream.Send(SSEEvent{Event: "greeting", Data: "hello\nworld"})
+		_ = stream.Comment("just checking")
+	})
+
+	client := dialSSE(t, addr, "")
+	if !strings.HasPrefix(client.response, "HTTP/1.1 200 OK\r\n") || !strings.Contains(client.response, "Content-Type: text/event-stream; charset=utf-8\r\n") {
+		t.Fatalf("Expected an event stream response but received %q", client.response)
+	}
+
+	if !strings.Contains(client.response, "Cache-Control: no-cache\r\n") {
+		t.Errorf("Expected the stream not to be cached in %q", client.response)
+	}
+
+	var expected = []string{
+		"retry: 2000\n",
+		"event: greeting\ndata: hello\ndata: world\n",
+		": just checking\n",
+	}
+	for _, block := range expected {
+		if received := client.readBlock(); received != block {
+			t.Fatalf("Expected %q but received %q", block, received)
+		}
+	}
+
+	// The stream ends when the handler returns
+	if _, err := client.reader.ReadByte(); err == nil {
+		t.Fatalf("Expected the server to close the stream")
+	}
+}
+
+func TestSSEStream_Heartbeat(t *testing.T) {
+	options := DefaultSSEOptions()
+	options.HeartbeatInterval = 20 * time.Millisecond
+
+	addr := startSSETestServer(t, options, func(stream *SSEStream) {
+		<-stream.Context().Done()
+	})
+
+	client := dialSSE(t, addr, "")
+	if block := client.readBlock(); block != ":\n" {
+		t.Fatalf("Expected a heartbeat comment but received %q", block)
+	}
+}
+
+func TestSSEStream_DetectsDisconnect(t *testing.T) {
+	causes := make(chan error, 1)
+	addr := startSSETestServer(t, DefaultSSEOptions(), func(stream *SSEStream) {
+		<-stream.Context().Done()
+		causes <- context.Cause(stream.Context())
+
+		if err := stream.Send(SSEEvent{Data: "too late"}); !errors.Is(err, ErrSSEStreamClosed) {
+			t.Errorf("Expected sending to a closed stream to fail but received %v", err)
+		}
+	})
+
+	client := dialSSE(t, addr, "")
+	_ = client.conn.Close()
+
+	select {
+	case cause := <-causes:
+		if !errors.Is(cause, ErrClientDisconnected) {
+			t.Fatalf("Expected the client's disconnect to be the cause but received %v", cause)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the disconnect to be detected")
+	}
+}
+
+func TestSSEHub(t *testing.T) {
+	hub := NewSSEHub(3)
+	addr := startSSETestServer(t, DefaultSSEOptions(), func(stream *SSEStream) {
+		_ = hub.Subscribe(stream, "news", "sport")
+	})
+
+	// Events from before anyone subscribed are only replayed to reconnecting browsers
+	hub.Publish("news", SSEEvent{Data: "old news"})
+
+	client := dialSSE(t, addr, "")
+	waitForSubscribers(t, hub, 1)
+
+	hub.Publish("news", SSEEvent{Event: "headline", Data: "first"})
+	hub.Publish("weather", SSEEvent{Data: "not subscribed"})
+	hub.Publish("sport", SSEEvent{Data: "second"})
+
+	for _, expected := range []string{"id: 2\nevent: headline\ndata: first\n", "id: 4\ndata: second\n"} {
+		if block := client.readBlock(); block != expected {
+			t.Fatalf("Expected %q but received %q", expected, block)
+		}
+	}
+
+	_ = client.conn.Close()
+	waitForSubscribers(t, hub, 0)
+
+	t.Run("Replays missed events", func(t *testing.T) {
+		hub.Publish("sport", SSEEvent{Data: "missed"})
+
+		reconnected := dialSSE(t, addr, "Last-Event-ID: 2\r\n")
+		for _, expected := range []string{"id: 4\ndata: second\n", "id: 5\ndata: missed\n"} {
+			if block := reconnected.readBlock(); block != expected {
+				t.Fatalf("Expected %q but received %q", expected, block)
+			}
+		}
+
+		waitForSubscribers(t, hub, 1)
+		hub.Publish("news", SSEEvent{Data: "live"})
+		if block := reconnected.readBlock(); block != "id: 6\ndata: live\n" {
+			t.Fatalf("Expected the live event after the replay but received %q", block)
+		}
+	})
+}
+
+func TestSSEHub_MissedEvents(t *testing.T) {
+	hub := NewSSEHub(2)
+	for _, data := range []string{"a1", "b2", "a3", "a4", "b5"} {
+		hub.Publish(data[:1], SSEEvent{Data: data})
+	}
+
+	var tests = []struct {
+		name        string
+		lastEventID string
+		topics      []string
+		expected    []string
+	}{
+		{"First connection", "", []string{"a", "b"}, nil},
+		{"Resumes across topics", "2", []string{"a", "b"}, []string{"a3", "a4", "b5"}},
+		{"Older than the history", "1", []string{"a"}, []string{"a3", "a4"}},
+		{"Up to date", "5", []string{"a", "b"}, nil},
+		{"Unknown ID", "99", []string{"b"}, []string{"b2", "b5"}},
+		{"Invalid ID", "abc", []string{"b"}, []string{"b2", "b5"}},
+		{"Unknown topic", "0", []string{"c"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := hub.missedEvents(tt.lastEventID, tt.topics)
+
+			data := make([]string, 0)
+			for _, event := range events {
+				data = append(data, event.Data)
+			}
+
+			if strings.Join(data, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v but received %v", tt.expected, data)
+			}
+		})
+	}
+}
+
+func TestSSEHub_DropsSlowSubscribers(t *testing.T) {
+	hub := NewSSEHub(0)
+
+	// Nothing reads from the other end of the pipe, so the subscriber falls behind
+	server, client := net.Pipe()
+	defer client.Close()
+	stream := newSSEStream(server, newTestRequest(MethodGet, "/events", nil), SSEOptions{})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- hub.Subscribe(stream, "news")
+	}()
+	waitForSubscribers(t, hub, 1)
+
+	for i := 0; i < sseSubscriberBuffer+2; i++ {
+		hub.Publish("news", SSEEvent{Data: "flood"})
+	}
+
+	if hub.Subscribers() != 0 {
+		t.Fatalf("Expected the slow subscriber to be dropped")
+	}
+
+	// Unblock the pending write so that Subscribe can notice
+	_ = server.Close()
+	if err := <-done; !errors.Is(err, ErrSSEStreamClosed) {
+		t.Fatalf("Expected the stream to end but received %v", err)
+	}
+}
+
+func TestSSEHub_PrunesUnusedTopics(t *testing.T) {
+	hub := NewSSEHub(3)
+	hub.Publish("old", SSEEvent{Data: "a"})
+	hub.Publish("watched", SSEEvent{Data: "b"})
+	hub.Publish("recent", SSEEvent{Data: "c"})
+
+	hub.subscribers[&sseSubscriber{topics: map[string]bool{"watched": true}}] = struct{}{}
+
+	now := time.Now().Add(sseTopicRetention)
+	hub.topics["recent"].lastPublished = now.Add(-time.Minute)
+	hub.prune(now)
+
+	for topic, expected := range map[string]bool{"old": false, "watched": true, "recent": true} {
+		if _, found := hub.topics[topic]; found != expected {
+			t.Errorf("Expected topic %q to be kept %v", topic, expected)
+		}
+	}
+}
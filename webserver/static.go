@@ -0,0 +1,367 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ErrInvalidStaticPath is returned when a request path cannot be mapped safely onto the file system
+var ErrInvalidStaticPath = errors.New("the requested path is not a valid static file path")
+
+// ErrStaticFileNotFound is returned when a request path does not map to a file that can be served
+var ErrStaticFileNotFound = errors.New("the requested static file could not be found")
+
+// ErrStaticFileForbidden is returned when a request path maps to a file that may not be served
+var ErrStaticFileForbidden = errors.New("the requested static file may not be served")
+
+// DotFilePolicy determines how the static file handler treats files and folders whose names start with a dot
+type DotFilePolicy int
+
+const (
+	// DotFilesIgnore responds as if dotfiles do not exist
+	DotFilesIgnore DotFilePolicy = iota
+	// DotFilesDeny responds with a 403 when a dotfile is requested
+	DotFilesDeny
+	// DotFilesAllow serves dotfiles like any other file
+	DotFilesAllow
+)
+
+// StaticFileOptions configures how the static file handler maps request paths onto the file system
+type StaticFileOptions struct {
+	// DotFiles determines how files and folders starting with a dot are treated
+	DotFiles DotFilePolicy
+	// FollowSymlinks allows symlinks to be followed as long as their target stays inside the root folder. When false,
+	// any symlink in the requested path is refused.
+	FollowSymlinks bool
+	// Precompressed lists the content codings, in order of preference, to look for precompressed siblings of a file.
+	// For example, with `gzip` listed a request for `app.js` is answered with `app.js.gz` if the client accepts it.
+	Precompressed []string
+	// SPAFallback serves the SPA index document for GET requests that don't match a file, accept HTML and have no file
+	// extension, so that client-side routes like `/dashboard/42` load a single-page application
+	SPAFallback bool
+	// SPAIndex is the request path of the document served by the SPA fallback. Defaults to `/index.html`.
+	SPAIndex string
+	// SPAExcludePrefixes lists path prefixes, e.g. `/api`, that never fall back to the SPA index document
+	SPAExcludePrefixes []string
+	// Cache keeps the contents of recently served files in memory. Leave it nil to read every file from disk.
+	Cache *StaticFileCache
+}
+
+// precompressedExtensions maps the content codings supported by the static file handler to their file extensions
+var precompressedExtensions = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+// DefaultStaticFileOptions returns the options used by NewStaticFileHandler, which serve precompressed `.br`, `.zst`
+// and `.gz` siblings of files to clients that accept them
+func DefaultStaticFileOptions() StaticFileOptions {
+	return StaticFileOptions{
+		DotFiles:       DotFilesIgnore,
+		FollowSymlinks: true,
+		Precompressed:  []string{"br", "zstd", "gzip"},
+	}
+}
+
+// NewStaticFileHandler creates a handler that serves files from the given folder using the default options
+func NewStaticFileHandler(wwwFilePath string) *Handler {
+	return NewStaticFileHandlerWithOptions(wwwFilePath, DefaultStaticFileOptions())
+}
+
+// NewStaticFileHandlerWithOptions creates a handler that serves files from the given folder using the given options
+func NewStaticFileHandlerWithOptions(wwwFilePath string, options StaticFileOptions) *Handler {
+	return NewHandlerWithError(MethodGet, AnyPath(), func(request Request) (Response, error) {
+		filePath, err := resolveStaticFilePath(wwwFilePath, request.Path(), options)
+		if errors.Is(err, ErrStaticFileNotFound) && shouldUseSPAFallback(request, options) {
+			spaIndex := options.SPAIndex
+			if spaIndex == "" {
+				spaIndex = "/index.html"
+			}
+
+			filePath, err = resolveStaticFilePath(wwwFilePath, spaIndex, options)
+		}
+
+		if errors.Is(err, ErrStaticFileNotFound) {
+			return NotFoundResponse(), nil
+		} else if errors.Is(err, ErrStaticFileForbidden) {
+			return ForbiddenResponse(), nil
+		} else if errors.Is(err, ErrInvalidStaticPath) {
+			return BadRequestResponse(), nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to find a static file: %w", err)
+		}
+
+		return serveStaticFile(request, filePath, options)
+	})
+}
+
+// serveStaticFile builds the response for a file that has already been resolved by resolveStaticFilePath
+func serveStaticFile(request Request, filePath string, options StaticFileOptions) (Response, error) {
+	// Swap to a precompressed sibling if the client accepts it
+	encoding := ""
+	servedFilePath := filePath
+	if len(options.Precompressed) > 0 {
+		acceptEncoding, _ := request.Headers().GetHeader("Accept-Encoding")
+		encoding, servedFilePath = findPrecompressedFile(filePath, acceptEncoding, options.Precompressed)
+	}
+
+	// Read the file, going through the cache if there is one
+	var file staticFile
+	var err error
+	if options.Cache != nil {
+		file, err = options.Cache.load(servedFilePath, encoding)
+	} else {
+		file, err = readStaticFile(servedFilePath)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read a static file: %w", err)
+	}
+
+	response := newConditionalResponse(request, file.contents, newETag(file.size, file.modTime, encoding))
+	if len(options.Precompressed) > 0 {
+		addVary(response.Headers(), "Accept-Encoding")
+	}
+
+	if response.StatusCode() == 304 {
+		return response, nil
+	}
+
+	// The content type always comes from the original file, not the precompressed sibling
+	if contentType := mime.TypeByExtension(filepath.Ext(filePath)); contentType != "" {
+		response.Headers().SetHeader("Content-Type", contentType)
+	}
+
+	if encoding != "" {
+		response.Headers().SetHeader("Content-Encoding", encoding)
+	}
+
+	return response, nil
+}
+
+// shouldUseSPAFallback returns whether a request for a missing file should be answered with the SPA index document.
+// Only navigations to extensionless paths outside the excluded prefixes fall back, so missing assets like `/app.js`
+// still get a 404.
+func shouldUseSPAFallback(request Request, options StaticFileOptions) bool {
+	if !options.SPAFallback || request.Method() != MethodGet {
+		return false
+	}
+
+	cleanedRequestPath, err := cleanStaticRequestPath(request.Path())
+	if err != nil || path.Ext(cleanedRequestPath) != "" {
+		return false
+	}
+
+	for _, prefix := range options.SPAExcludePrefixes {
+		prefix = "/" + strings.Trim(prefix, "/")
+		if cleanedRequestPath == prefix || strings.HasPrefix(cleanedRequestPath, prefix+"/") || prefix == "/" {
+			return false
+		}
+	}
+
+	accept, err := request.Headers().GetHeader("Accept")
+	if err != nil {
+		return false
+	}
+
+	// Only navigations name HTML explicitly, as scripts fetching missing files send `*/*`
+	for _, mediaType := range []string{"text/html", "application/xhtml+xml"} {
+		if q, specificity := mediaTypeQuality(accept, mediaType); q > 0 && specificity == 2 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findPrecompressedFile looks for a precompressed sibling of filePath in one of the given content codings that the
+// client accepts. It returns the chosen coding and the file to serve, or an empty coding and filePath if there isn't a
+// suitable sibling.
+func findPrecompressedFile(filePath string, acceptEncoding string, encodings []string) (string, string) {
+	available := make([]string, 0, len(encodings))
+	for _, encoding := range encodings {
+		extension, supported := precompressedExtensions[encoding]
+		if !supported {
+			continue
+		}
+
+		// Siblings must be regular files so a symlink can't be used to escape the root folder
+		fileInfo, err := os.Lstat(filePath + extension)
+		if err == nil && fileInfo.Mode().IsRegular() {
+			available = append(available, encoding)
+		}
+	}
+
+	encoding := negotiateEncoding(acceptEncoding, available)
+	if encoding == "" {
+		return "", filePath
+	}
+
+	return encoding, filePath + precompressedExtensions[encoding]
+}
+
+// resolveStaticFilePath maps a request path onto a regular file inside the root folder. The returned path has all
+// symlinks resolved and is guaranteed to be inside the real path of the root folder.
+func resolveStaticFilePath(root string, requestPath string, options StaticFileOptions) (string, error) {
+	cleanedRequestPath, err := cleanStaticRequestPath(requestPath)
+	if err != nil {
+		return "", err
+	}
+
+	return resolveCleanedStaticFilePath(root, cleanedRequestPath, options)
+}
+
+// resolveCleanedStaticFilePath does the work for resolveStaticFilePath once the request path has been cleaned
+func resolveCleanedStaticFilePath(root string, cleanedRequestPath string, options StaticFileOptions) (string, error) {
+	if err := checkDotFiles(cleanedRequestPath, options.DotFiles); err != nil {
+		return "", err
+	}
+
+	// Account for `/`
+	if cleanedRequestPath == "/" {
+		cleanedRequestPath = "/index.html"
+	}
+
+	rootPath, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+
+	realRootPath, err := filepath.EvalSymlinks(rootPath)
+	if err != nil {
+		return "", err
+	}
+
+	filePath := filepath.Join(realRootPath, filepath.FromSlash(cleanedRequestPath))
+
+	if !options.FollowSymlinks {
+		if err := checkNoSymlinks(realRootPath, filePath); err != nil {
+			return "", err
+		}
+	}
+
+	realFilePath, err := filepath.EvalSymlinks(filePath)
+	if isNotExist(err) {
+		return "", ErrStaticFileNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	// Make sure the resolved file is still inside the root folder and that a symlink hasn't pointed us at a dotfile
+	relativePath, err := filepath.Rel(realRootPath, realFilePath)
+	if err != nil || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return "", ErrStaticFileForbidden
+	}
+
+	if err := checkDotFiles("/"+filepath.ToSlash(relativePath), options.DotFiles); err != nil {
+		return "", err
+	}
+
+	fileInfo, err := os.Stat(realFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	// Serve the index document when a folder is requested
+	if fileInfo.IsDir() {
+		indexPath := path.Join(cleanedRequestPath, "index.html")
+		if indexPath == cleanedRequestPath {
+			return "", ErrStaticFileNotFound
+		}
+
+		return resolveCleanedStaticFilePath(root, indexPath, options)
+	}
+
+	if !fileInfo.Mode().IsRegular() {
+		return "", ErrStaticFileNotFound
+	}
+
+	return realFilePath, nil
+}
+
+// cleanStaticRequestPath decodes the request path and normalises it into an absolute slash-separated path that cannot
+// climb above `/`
+func cleanStaticRequestPath(requestPath string) (string, error) {
+	// Drop the query string and fragment, they aren't part of the file name
+	if i := strings.IndexAny(requestPath, "?#"); i >= 0 {
+		requestPath = requestPath[:i]
+	}
+
+	decodedPath, err := url.PathUnescape(requestPath)
+	if err != nil {
+		return "", ErrInvalidStaticPath
+	}
+
+	// NUL bytes and backslashes have no business in a URL path and are commonly used to trick file system APIs
+	if strings.ContainsAny(decodedPath, "\x00\\") {
+		return "", ErrInvalidStaticPath
+	}
+
+	return path.Clean("/" + decodedPath), nil
+}
+
+// checkDotFiles applies the dotfile policy to every segment of a cleaned request path
+func checkDotFiles(cleanedRequestPath string, policy DotFilePolicy) error {
+	if policy == DotFilesAllow {
+		return nil
+	}
+
+	for _, segment := range strings.Split(cleanedRequestPath, "/") {
+		if !strings.HasPrefix(segment, ".") {
+			continue
+		}
+
+		if policy == DotFilesDeny {
+			return ErrStaticFileForbidden
+		}
+
+		return ErrStaticFileNotFound
+	}
+
+	return nil
+}
+
+// checkNoSymlinks walks each component of filePath below rootPath and refuses the path if any of them is a symlink
+func checkNoSymlinks(rootPath string, filePath string) error {
+	relativePath, err := filepath.Rel(rootPath, filePath)
+	if err != nil {
+		return ErrStaticFileForbidden
+	}
+
+	currentPath := rootPath
+	for _, segment := range strings.Split(relativePath, string(filepath.Separator)) {
+		if segment == "." {
+			continue
+		}
+
+		currentPath = filepath.Join(currentPath, segment)
+
+		fileInfo, err := os.Lstat(currentPath)
+		if isNotExist(err) {
+			return ErrStaticFileNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if fileInfo.Mode()&fs.ModeSymlink != 0 {
+			return ErrStaticFileForbidden
+		}
+	}
+
+	return nil
+}
+
+// isNotExist returns whether err means the path can't exist, including when a file is used as a folder or a name is
+// longer than the file system allows
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) || errors.Is(err, syscall.ENAMETOOLONG)
+}
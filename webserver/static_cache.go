@@ -0,0 +1,281 @@
+package webserver
+
+import (
+	"container/list"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StaticFileCacheOptions configures a StaticFileCache
+type StaticFileCacheOptions struct {
+	// MaxBytes is the total size of the file contents the cache may hold
+	MaxBytes int64
+	// MaxEntries is the number of files the cache may hold
+	MaxEntries int
+	// MaxFileSize is the size of the largest file that will be cached. Defaults to MaxBytes.
+	MaxFileSize int64
+	// PollInterval controls how the cache notices changed files. When zero, every hit checks the file's modification
+	// time and size. When set, hits are served straight from memory and the cache checks its files in the background
+	// at this interval instead.
+	PollInterval time.Duration
+}
+
+// StaticFileCacheStats holds counters describing how a StaticFileCache has performed
+type StaticFileCacheStats struct {
+	// Hits is the number of requests served from memory
+	Hits uint64
+	// Misses is the number of requests that had to read the file
+	Misses uint64
+	// Evictions is the number of entries removed to make space for others
+	Evictions uint64
+	// Invalidations is the number of entries removed because the file changed
+	Invalidations uint64
+	// Entries is the number of files currently cached
+	Entries int
+	// Bytes is the total size of the files currently cached
+	Bytes int64
+}
+
+// StaticFileCache is an in-memory LRU cache of static file contents, bounded by the total number of bytes and entries.
+// A cache is safe to share between static file handlers.
+type StaticFileCache struct {
+	options StaticFileCacheOptions
+
+	mutex   sync.Mutex
+	entries map[staticFileCacheKey]*list.Element
+	lru     *list.List
+	bytes   int64
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	evictions     atomic.Uint64
+	invalidations atomic.Uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// staticFileCacheKey identifies a cached file by its path and the precompressed variant it holds
+type staticFileCacheKey struct {
+	path     string
+	encoding string
+}
+
+// staticFile holds the contents of a static file along with the details used to validate it
+type staticFile struct {
+	contents []byte
+	size     int64
+	modTime  time.Time
+}
+
+// staticFileCacheEntry is the value stored in each element of the LRU list
+type staticFileCacheEntry struct {
+	key  staticFileCacheKey
+	file staticFile
+}
+
+// NewStaticFileCache creates a new static file cache. If a PollInterval is set, the cache polls its files until Close
+// is called.
+func NewStaticFileCache(options StaticFileCacheOptions) *StaticFileCache {
+	if options.MaxFileSize <= 0 || options.MaxFileSize > options.MaxBytes {
+		options.MaxFileSize = options.MaxBytes
+	}
+
+	cache := &StaticFileCache{
+		options: options,
+		entries: make(map[staticFileCacheKey]*list.Element),
+		lru:     list.New(),
+		stop:    make(chan struct{}),
+	}
+
+	if options.PollInterval > 0 {
+		go cache.poll()
+	}
+
+	return cache
+}
+
+// Stats returns the cache's counters
+func (c *StaticFileCache) Stats() StaticFileCacheStats {
+	c.mutex.Lock()
+	entries := len(c.entries)
+	bytes := c.bytes
+	c.mutex.Unlock()
+
+	return StaticFileCacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+		Entries:       entries,
+		Bytes:         bytes,
+	}
+}
+
+// Purge removes every entry from the cache
+func (c *StaticFileCache) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = make(map[staticFileCacheKey]*list.Element)
+	c.lru.Init()
+	c.bytes = 0
+}
+
+// Close stops background polling. The cache can still be used afterwards but won't notice changed files unless it was
+// created without a PollInterval.
+func (c *StaticFileCache) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+// load returns the contents of the file at filePath, which holds the given precompressed variant, from memory if it
+// can and from disk otherwise
+func (c *StaticFileCache) load(filePath string, encoding string) (staticFile, error) {
+	key := staticFileCacheKey{path: filePath, encoding: encoding}
+
+	c.mutex.Lock()
+	element, found := c.entries[key]
+	var cached staticFile
+	if found {
+		cached = element.Value.(*staticFileCacheEntry).file
+		c.lru.MoveToFront(element)
+	}
+	c.mutex.Unlock()
+
+	if found && c.options.PollInterval > 0 {
+		c.hits.Add(1)
+		return cached, nil
+	}
+
+	if found {
+		fileInfo, err := os.Stat(filePath)
+		if err == nil && fileInfo.Size() == cached.size && fileInfo.ModTime().Equal(cached.modTime) {
+			c.hits.Add(1)
+			return cached, nil
+		}
+
+		c.invalidate(key, element)
+	}
+
+	c.misses.Add(1)
+
+	file, err := readStaticFile(filePath)
+	if err != nil {
+		return staticFile{}, err
+	}
+
+	c.store(key, file)
+
+	return file, nil
+}
+
+// store adds a file to the cache, evicting the least recently used entries until it fits
+func (c *StaticFileCache) store(key staticFileCacheKey, file staticFile) {
+	if file.size > c.options.MaxFileSize || c.options.MaxEntries <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, found := c.entries[key]; found {
+		c.removeElement(element)
+	}
+
+	for c.lru.Len() > 0 && (c.lru.Len() >= c.options.MaxEntries || c.bytes+file.size > c.options.MaxBytes) {
+		c.removeElement(c.lru.Back())
+		c.evictions.Add(1)
+	}
+
+	c.entries[key] = c.lru.PushFront(&staticFileCacheEntry{key: key, file: file})
+	c.bytes += file.size
+}
+
+// invalidate removes an entry because its file changed, as long as it hasn't already been replaced
+func (c *StaticFileCache) invalidate(key staticFileCacheKey, element *list.Element) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries[key] != element {
+		return
+	}
+
+	c.removeElement(element)
+	c.invalidations.Add(1)
+}
+
+// removeElement removes an element from the LRU list and map. The mutex must be held.
+func (c *StaticFileCache) removeElement(element *list.Element) {
+	entry := element.Value.(*staticFileCacheEntry)
+
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.file.size
+}
+
+// poll checks every cached file at the poll interval, invalidating the ones that have changed
+func (c *StaticFileCache) poll() {
+	ticker := time.NewTicker(c.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.checkEntries()
+		}
+	}
+}
+
+// checkEntries invalidates every cached file whose modification time or size has changed. The files are checked
+// without holding the mutex so requests aren't held up by the file system.
+func (c *StaticFileCache) checkEntries() {
+	c.mutex.Lock()
+	elements := make([]*list.Element, 0, c.lru.Len())
+	for element := c.lru.Front(); element != nil; element = element.Next() {
+		elements = append(elements, element)
+	}
+	c.mutex.Unlock()
+
+	for _, element := range elements {
+		entry := element.Value.(*staticFileCacheEntry)
+
+		fileInfo, err := os.Stat(entry.key.path)
+		if err == nil && fileInfo.Size() == entry.file.size && fileInfo.ModTime().Equal(entry.file.modTime) {
+			continue
+		}
+
+		c.invalidate(entry.key, element)
+	}
+}
+
+// readStaticFile reads a file from disk along with its size and modification time
+func readStaticFile(filePath string) (staticFile, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return staticFile{}, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return staticFile{}, err
+	}
+
+	contents, err := io.ReadAll(file)
+	if err != nil {
+		return staticFile{}, err
+	}
+
+	return staticFile{
+		contents: contents,
+		size:     fileInfo.Size(),
+		modTime:  fileInfo.ModTime(),
+	}, nil
+}
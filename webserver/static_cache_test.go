@@ -0,0 +1,152 @@
+package webserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCacheTestFile writes a file with the given contents and modification time
+func writeCacheTestFile(t *testing.T, filePath string, contents string, modTime time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(filePath, []byte(contents), 0o644); err != nil {
+		t.Fatalf("Could not write file %s: %v", filePath, err)
+	}
+
+	if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+		t.Fatalf("Could not set the modification time of %s: %v", filePath, err)
+	}
+}
+
+func TestStaticFileCache_LoadHitsAndMisses(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "index.html")
+	writeCacheTestFile(t, filePath, "Hello World!", time.Unix(1000, 0))
+
+	cache := NewStaticFileCache(StaticFileCacheOptions{MaxBytes: 1024, MaxEntries: 10})
+
+	for i := 0; i < 3; i++ {
+		file, err := cache.load(filePath, "")
+		if err != nil {
+			t.Fatalf("Received an error loading a file: %v", err)
+		}
+
+		if string(file.contents) != "Hello World!" {
+			t.Fatalf("Expected the contents to be \"Hello World!\" but received %q", string(file.contents))
+		}
+	}
+
+	// A different precompressed variant is a different entry
+	if _, err := cache.load(filePath, "gzip"); err != nil {
+		t.Fatalf("Received an error loading a file: %v", err)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Entries != 2 || stats.Bytes != 24 {
+		t.Fatalf("Expected 2 hits, 2 misses, 2 entries and 24 bytes but received %+v", stats)
+	}
+}
+
+func TestStaticFileCache_LoadInvalidatesChangedFiles(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "index.html")
+	writeCacheTestFile(t, filePath, "Hello World!", time.Unix(1000, 0))
+
+	cache := NewStaticFileCache(StaticFileCacheOptions{MaxBytes: 1024, MaxEntries: 10})
+	if _, err := cache.load(filePath, ""); err != nil {
+		t.Fatalf("Received an error loading a file: %v", err)
+	}
+
+	// Same size, different modification time
+	writeCacheTestFile(t, filePath, "Hello There!", time.Unix(2000, 0))
+
+	file, err := cache.load(filePath, "")
+	if err != nil {
+		t.Fatalf("Received an error loading a file: %v", err)
+	}
+
+	if string(file.contents) != "Hello There!" {
+		t.Fatalf("Expected the changed contents \"Hello There!\" but received %q", string(file.contents))
+	}
+
+	if stats := cache.Stats(); stats.Invalidations != 1 || stats.Misses != 2 {
+		t.Fatalf("Expected 1 invalidation and 2 misses but received %+v", stats)
+	}
+}
+
+func TestStaticFileCache_StoreEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		writeCacheTestFile(t, filepath.Join(dir, name), "0123456789", time.Unix(1000, 0))
+	}
+
+	var tests = []struct {
+		name    string
+		options StaticFileCacheOptions
+	}{
+		{"entries", StaticFileCacheOptions{MaxBytes: 1024, MaxEntries: 2}},
+		{"bytes", StaticFileCacheOptions{MaxBytes: 25, MaxEntries: 10}},
+	}
+
+	for _, test := range tests {
+		cache := NewStaticFileCache(test.options)
+
+		for _, name := range []string{"a", "b", "a", "c"} {
+			if _, err := cache.load(filepath.Join(dir, name), ""); err != nil {
+				t.Fatalf("Received an error loading a file: %v", err)
+			}
+		}
+
+		stats := cache.Stats()
+		if stats.Entries != 2 || stats.Evictions != 1 {
+			t.Errorf("Limited by %s expected 2 entries and 1 eviction but received %+v", test.name, stats)
+		}
+
+		// `b` was the least recently used so it should have been evicted
+		if _, found := cache.entries[staticFileCacheKey{path: filepath.Join(dir, "b")}]; found {
+			t.Errorf("Limited by %s expected b to have been evicted", test.name)
+		}
+	}
+}
+
+func TestStaticFileCache_StoreSkipsLargeFiles(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "large.bin")
+	writeCacheTestFile(t, filePath, "0123456789", time.Unix(1000, 0))
+
+	cache := NewStaticFileCache(StaticFileCacheOptions{MaxBytes: 1024, MaxEntries: 10, MaxFileSize: 5})
+	if _, err := cache.load(filePath, ""); err != nil {
+		t.Fatalf("Received an error loading a file: %v", err)
+	}
+
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Fatalf("Expected the large file not to be cached but received %+v", stats)
+	}
+}
+
+func TestStaticFileCache_PollingInvalidatesChangedFiles(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "index.html")
+	writeCacheTestFile(t, filePath, "Hello World!", time.Unix(1000, 0))
+
+	cache := NewStaticFileCache(StaticFileCacheOptions{MaxBytes: 1024, MaxEntries: 10, PollInterval: 10 * time.Millisecond})
+	defer cache.Close()
+
+	if _, err := cache.load(filePath, ""); err != nil {
+		t.Fatalf("Received an error loading a file: %v", err)
+	}
+
+	writeCacheTestFile(t, filePath, "Changed!", time.Unix(2000, 0))
+
+	deadline := time.Now().Add(time.Second)
+	for cache.Stats().Invalidations == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	file, err := cache.load(filePath, "")
+	if err != nil {
+		t.Fatalf("Received an error loading a file: %v", err)
+	}
+
+	if string(file.contents) != "Changed!" {
+		t.Fatalf("Expected polling to pick up the changed contents but received %q", string(file.contents))
+	}
+}
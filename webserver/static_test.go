@@ -0,0 +1,365 @@
+package webserver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// createStaticTestFolder creates a `www` folder with a few files plus a secret file outside it, returning the path to
+// the `www` folder
+func createStaticTestFolder(t testing.TB) string {
+	t.Helper()
+
+	base := t.TempDir()
+	root := filepath.Join(base, "www")
+	outside := filepath.Join(base, "outside")
+
+	for _, dir := range []string{root, filepath.Join(root, "docs"), filepath.Join(root, ".git"), outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("Could not create folder %s: %v", dir, err)
+		}
+	}
+
+	files := map[string]string{
+		filepath.Join(root, "index.html"):         "index",
+		filepath.Join(root, "about.html"):         "about",
+		filepath.Join(root, "docs", "index.html"): "docs",
+		filepath.Join(root, ".env"):               "dotfile",
+		filepath.Join(root, ".git", "config"):     "dotfile",
+		filepath.Join(outside, "secret.txt"):      "TOP SECRET",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(name, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Could not create file %s: %v", name, err)
+		}
+	}
+
+	symlinks := map[string]string{
+		filepath.Join(root, "escape"):        outside,
+		filepath.Join(root, "escape.txt"):    filepath.Join(outside, "secret.txt"),
+		filepath.Join(root, "about-link"):    filepath.Join(root, "about.html"),
+		filepath.Join(root, "hidden-link"):   filepath.Join(root, ".env"),
+		filepath.Join(root, "relative-link"): filepath.Join("..", "outside", "secret.txt"),
+	}
+	for name, target := range symlinks {
+		if err := os.Symlink(target, name); err != nil {
+			t.Skipf("Symlinks are not supported: %v", err)
+		}
+	}
+
+	return root
+}
+
+func TestResolveStaticFilePath(t *testing.T) {
+	root := createStaticTestFolder(t)
+
+	var tests = []struct {
+		path     string
+		expected string
+		err      error
+	}{
+		{"/", "index.html", nil},
+		{"/about.html", "about.html", nil},
+		{"/about.html?version=2", "about.html", nil},
+		{"/docs", filepath.Join("docs", "index.html"), nil},
+		{"/docs/../about.html", "about.html", nil},
+		{"/%61bout.html", "about.html", nil},
+		{"/about-link", "about.html", nil},
+		{"/missing.html", "", ErrStaticFileNotFound},
+		{"/about.html/more", "", ErrStaticFileNotFound},
+		{"/../outside/secret.txt", "", ErrStaticFileNotFound},
+		{"/..%2f..%2foutside%2fsecret.txt", "", ErrStaticFileNotFound},
+		{"/escape/secret.txt", "", ErrStaticFileForbidden},
+		{"/escape.txt", "", ErrStaticFileForbidden},
+		{"/relative-link", "", ErrStaticFileForbidden},
+		{"/..\\outside\\secret.txt", "", ErrInvalidStaticPath},
+		{"/about.html%00.png", "", ErrInvalidStaticPath},
+		{"/%zz", "", ErrInvalidStaticPath},
+		{"/.env", "", ErrStaticFileNotFound},
+		{"/.git/config", "", ErrStaticFileNotFound},
+		{"/hidden-link", "", ErrStaticFileNotFound},
+	}
+
+	for _, test := range tests {
+		filePath, err := resolveStaticFilePath(root, test.path, DefaultStaticFileOptions())
+		if !errors.Is(err, test.err) {
+			t.Errorf("resolveStaticFilePath(%q) expected error %v, got %v", test.path, test.err, err)
+			continue
+		}
+
+		if test.err == nil && !strings.HasSuffix(filePath, string(filepath.Separator)+test.expected) {
+			t.Errorf("resolveStaticFilePath(%q) expected a path ending in %q, got %q", test.path, test.expected, filePath)
+		}
+	}
+}
+
+func TestResolveStaticFilePathDotFilePolicies(t *testing.T) {
+	root := createStaticTestFolder(t)
+
+	var tests = []struct {
+		policy DotFilePolicy
+		err    error
+	}{
+		{DotFilesIgnore, ErrStaticFileNotFound},
+		{DotFilesDeny, ErrStaticFileForbidden},
+		{DotFilesAllow, nil},
+	}
+
+	for _, test := range tests {
+		options := DefaultStaticFileOptions()
+		options.DotFiles = test.policy
+
+		for _, path := range []string{"/.env", "/.git/config", "/hidden-link"} {
+			_, err := resolveStaticFilePath(root, path, options)
+			if !errors.Is(err, test.err) {
+				t.Errorf("resolveStaticFilePath(%q) with policy %v expected error %v, got %v", path, test.policy, test.err, err)
+			}
+		}
+	}
+}
+
+func TestResolveStaticFilePathWithoutFollowingSymlinks(t *testing.T) {
+	root := createStaticTestFolder(t)
+	options := DefaultStaticFileOptions()
+	options.FollowSymlinks = false
+
+	if _, err := resolveStaticFilePath(root, "/about-link", options); !errors.Is(err, ErrStaticFileForbidden) {
+		t.Fatalf("Expected an ErrStaticFileForbidden error but received %v", err)
+	}
+
+	if _, err := resolveStaticFilePath(root, "/about.html", options); err != nil {
+		t.Fatalf("Received an error resolving a regular file: %v", err)
+	}
+}
+
+func TestStaticFileHandler(t *testing.T) {
+	root := createStaticTestFolder(t)
+	handler := NewStaticFileHandler(root)
+
+	var tests = []struct {
+		path       string
+		statusCode int
+		body       string
+	}{
+		{"/", 200, "index"},
+		{"/about.html", 200, "about"},
+		{"/missing.html", 404, ""},
+		{"/escape.txt", 403, ""},
+		{"/a%00b", 400, ""},
+	}
+
+	for _, test := range tests {
+		response := handler.Execute(newTestRequest(MethodGet, test.path, nil))
+		if response.StatusCode() != test.statusCode {
+			t.Errorf("GET %s expected status code %d, got %d", test.path, test.statusCode, response.StatusCode())
+		}
+
+		if string(response.Body()) != test.body {
+			t.Errorf("GET %s expected body %q, got %q", test.path, test.body, string(response.Body()))
+		}
+	}
+}
+
+func TestStaticFileHandlerPrecompressed(t *testing.T) {
+	root := createStaticTestFolder(t)
+	for name, contents := range map[string]string{"app.js": "plain", "app.js.gz": "gzipped", "app.js.zst": "zstd"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(contents), 0o644); err != nil {
+			t.Fatalf("Could not create file %s: %v", name, err)
+		}
+	}
+
+	options := DefaultStaticFileOptions()
+	options.Precompressed = []string{"zstd", "gzip"}
+	handler := NewStaticFileHandlerWithOptions(root, options)
+
+	var tests = []struct {
+		acceptEncoding  string
+		body            string
+		contentEncoding string
+	}{
+		{"", "plain", ""},
+		{"gzip, deflate", "gzipped", "gzip"},
+		{"gzip, zstd", "zstd", "zstd"},
+		{"gzip;q=1, zstd;q=0.5", "gzipped", "gzip"},
+		{"br", "plain", ""},
+		{"gzip;q=0", "plain", ""},
+	}
+
+	etags := make(map[string]string)
+	for _, test := range tests {
+		response := handler.Execute(newTestRequest(MethodGet, "/app.js", map[string]string{"Accept-Encoding": test.acceptEncoding}))
+		if string(response.Body()) != test.body {
+			t.Errorf("Accept-Encoding %q expected body %q, got %q", test.acceptEncoding, test.body, string(response.Body()))
+		}
+
+		contentEncoding, _ := response.Headers().GetHeader("Content-Encoding")
+		if contentEncoding != test.contentEncoding {
+			t.Errorf("Accept-Encoding %q expected Content-Encoding %q, got %q", test.acceptEncoding, test.contentEncoding, contentEncoding)
+		}
+
+		if contentType, _ := response.Headers().GetHeader("Content-Type"); !strings.Contains(contentType, "javascript") {
+			t.Errorf("Accept-Encoding %q expected a JavaScript Content-Type, got %q", test.acceptEncoding, contentType)
+		}
+
+		if vary, _ := response.Headers().GetHeader("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q expected Vary: Accept-Encoding, got %q", test.acceptEncoding, vary)
+		}
+
+		etag, _ := response.Headers().GetHeader("ETag")
+		if other, found := etags[etag]; found && other != test.body {
+			t.Errorf("Expected %q and %q to have different ETags but both had %s", other, test.body, etag)
+		}
+		etags[etag] = test.body
+	}
+
+	// Ranges and ETags apply to the encoded representation
+	response := handler.Execute(newTestRequest(MethodGet, "/app.js", map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-3"}))
+	if response.StatusCode() != 206 || string(response.Body()) != "gzip" {
+		t.Fatalf("Expected a 206 with the first 4 bytes of the gzipped file but received %d %q", response.StatusCode(), string(response.Body()))
+	}
+
+	etag, _ := response.Headers().GetHeader("ETag")
+	response = handler.Execute(newTestRequest(MethodGet, "/app.js", map[string]string{"Accept-Encoding": "gzip", "If-None-Match": etag}))
+	if response.StatusCode() != 304 {
+		t.Fatalf("Expected a 304 for a matching gzip ETag but received %d", response.StatusCode())
+	}
+
+	response = handler.Execute(newTestRequest(MethodGet, "/app.js", map[string]string{"If-None-Match": etag}))
+	if response.StatusCode() != 200 {
+		t.Fatalf("Expected a 200 when the gzip ETag is sent without accepting gzip but received %d", response.StatusCode())
+	}
+}
+
+func TestStaticFileHandlerPrecompressedByDefault(t *testing.T) {
+	root := createStaticTestFolder(t)
+	for name, contents := range map[string]string{"app.js": "plain", "app.js.gz": "gzipped"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(contents), 0o644); err != nil {
+			t.Fatalf("Could not create file %s: %v", name, err)
+		}
+	}
+
+	handler := NewStaticFileHandler(root)
+
+	response := handler.Execute(newTestRequest(MethodGet, "/app.js", map[string]string{"Accept-Encoding": "gzip, deflate, br"}))
+	if contentEncoding, _ := response.Headers().GetHeader("Content-Encoding"); string(response.Body()) != "gzipped" || contentEncoding != "gzip" {
+		t.Fatalf("Expected the default handler to serve app.js.gz but received %q with Content-Encoding %q", string(response.Body()), contentEncoding)
+	}
+
+	response = handler.Execute(newTestRequest(MethodGet, "/app.js", nil))
+	if string(response.Body()) != "plain" {
+		t.Fatalf("Expected the original file without Accept-Encoding but received %q", string(response.Body()))
+	}
+}
+
+func TestStaticFileHandlerSPAFallback(t *testing.T) {
+	root := createStaticTestFolder(t)
+
+	options := DefaultStaticFileOptions()
+	options.SPAFallback = true
+	options.SPAExcludePrefixes = []string{"/api/"}
+	handler := NewStaticFileHandlerWithOptions(root, options)
+
+	html := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	var tests = []struct {
+		path       string
+		accept     string
+		statusCode int
+		body       string
+	}{
+		{"/dashboard/42", html, 200, "index"},
+		{"/dashboard/42?tab=settings", html, 200, "index"},
+		{"/about.html", html, 200, "about"},
+		{"/app.js", html, 404, ""},
+		{"/dashboard/42", "application/json", 404, ""},
+		{"/dashboard/42", "", 404, ""},
+		{"/dashboard/42", "text/html;q=0, */*", 404, ""},
+		{"/dashboard/42", "*/*", 404, ""},
+		{"/dashboard/42", "application/xhtml+xml", 200, "index"},
+		{"/api", html, 404, ""},
+		{"/api/person", html, 404, ""},
+		{"/apidocs", html, 200, "index"},
+		{"/escape.txt", html, 403, ""},
+	}
+
+	for _, test := range tests {
+		headersMap := map[string]string{}
+		if test.accept != "" {
+			headersMap["Accept"] = test.accept
+		}
+
+		response := handler.Execute(newTestRequest(MethodGet, test.path, headersMap))
+		if response.StatusCode() != test.statusCode || string(response.Body()) != test.body {
+			t.Errorf("GET %s with Accept %q expected %d %q, got %d %q", test.path, test.accept, test.statusCode, test.body, response.StatusCode(), string(response.Body()))
+		}
+	}
+}
+
+func TestStaticFileHandlerSPAFallbackDisabled(t *testing.T) {
+	root := createStaticTestFolder(t)
+	handler := NewStaticFileHandler(root)
+
+	response := handler.Execute(newTestRequest(MethodGet, "/dashboard/42", map[string]string{"Accept": "text/html"}))
+	if response.StatusCode() != 404 {
+		t.Fatalf("Expected a 404 without the SPA fallback but received %d", response.StatusCode())
+	}
+}
+
+func TestStaticFileHandlerWithCache(t *testing.T) {
+	root := createStaticTestFolder(t)
+
+	options := DefaultStaticFileOptions()
+	options.Cache = NewStaticFileCache(StaticFileCacheOptions{MaxBytes: 1024, MaxEntries: 10})
+	handler := NewStaticFileHandlerWithOptions(root, options)
+
+	for i := 0; i < 3; i++ {
+		response := handler.Execute(newTestRequest(MethodGet, "/", nil))
+		if string(response.Body()) != "index" {
+			t.Fatalf("Expected the body to be \"index\" but received %q", string(response.Body()))
+		}
+	}
+
+	if stats := options.Cache.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("Expected 2 hits and 1 miss but received %+v", stats)
+	}
+}
+
+func FuzzStaticFileHandler(f *testing.F) {
+	for _, seed := range []string{
+		"/",
+		"/../outside/secret.txt",
+		"/..%2f..%2foutside%2fsecret.txt",
+		"/%2e%2e/outside/secret.txt",
+		"/%252e%252e/outside/secret.txt",
+		"/..\\outside\\secret.txt",
+		"/escape/secret.txt",
+		"/escape/../escape/secret.txt",
+		"/relative-link",
+		"/.env",
+		"/hidden-link",
+		"/about.html%00",
+		"//outside/secret.txt",
+		"/docs/../../outside/secret.txt",
+	} {
+		f.Add(seed)
+	}
+
+	root := createStaticTestFolder(f)
+	handler := NewStaticFileHandler(root)
+
+	f.Fuzz(func(t *testing.T, path string) {
+		response := handler.Execute(newTestRequest(MethodGet, path, nil))
+
+		body := string(response.Body())
+		if strings.Contains(body, "TOP SECRET") || strings.Contains(body, "dotfile") {
+			t.Fatalf("GET %q escaped the static root and returned %q", path, body)
+		}
+
+		if response.StatusCode() == 500 {
+			t.Fatalf("GET %q returned an internal error", path)
+		}
+	})
+}
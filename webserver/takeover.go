@@ -0,0 +1,41 @@
+package webserver
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"runtime/debug"
+)
+
+// takeoverResponse is a response that keeps the connection once its head has been written, either to switch to another
+// protocol such as WebSocket or to stream a body such as server-sent events
+type takeoverResponse struct {
+	Response
+	// takeover uses the connection. ctx is cancelled with ErrServerClosed as soon as the server starts shutting down,
+	// and reader holds any bytes the client sent after the request. The connection is closed once it returns.
+	takeover func(ctx context.Context, conn net.Conn, reader *bufio.Reader)
+}
+
+// takeOver hands the connection to a takeover response once it has been written. The connection still counts towards
+// the server's connection limit and shutdown waits for it, so the response must stop using it once ctx is cancelled.
+func (w *WebServer) takeOver(conn *connection, response *takeoverResponse) {
+	ctx, cancel := context.WithCancelCause(w.state.ctx)
+	defer cancel(nil)
+
+	go func() {
+		select {
+		case <-w.state.closing:
+			cancel(ErrServerClosed)
+		case <-ctx.Done():
+		}
+	}()
+
+	defer func() {
+		if r := recover(); r != nil {
+			conn.logger.Error("Handler panicked after the response was sent", "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+
+	conn.logger.Debug("Connection taken over by the response")
+	response.takeover(ctx, conn, conn.reader)
+}
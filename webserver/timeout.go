@@ -0,0 +1,101 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHandlerTimeout is the cause of a request's context being cancelled because its handler ran out of time
+var ErrHandlerTimeout = errors.New("the handler timed out")
+
+// handlerResult is the outcome of a handler run on its own goroutine
+type handlerResult struct {
+	response Response
+	err      error
+	panicErr *PanicError
+}
+
+// executeWithTimeout runs the handler, giving up on it once the timeout elapses. A timed out handler keeps running on
+// its own goroutine until it notices its context has been cancelled, but whatever it returns is discarded.
+func (w *WebServer) executeWithTimeout(logger *slog.Logger, handler *Handler, request Request) (Response, error) {
+	timeout := handler.timeout
+	if timeout == 0 {
+		timeout = w.handlerTimeout
+	}
+
+	if timeout <= 0 {
+		return handler.executeWithError(request)
+	}
+
+	ctx, cancel := context.WithTimeoutCause(request.Context(), timeout, ErrHandlerTimeout)
+	defer cancel()
+
+	timedOut := atomic.Bool{}
+
+	// The channel is buffered so that a handler which has been given up on can still return
+	results := make(chan handlerResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panicErr := &PanicError{Value: r, Stack: debug.Stack()}
+				if timedOut.Load() {
+					logger.Error("Handler panicked after timing out", "panic", r, "stack", string(panicErr.Stack))
+				}
+
+				results <- handlerResult{panicErr: panicErr}
+			}
+		}()
+
+		response, err := handler.executeWithError(request.WithContext(ctx))
+		results <- handlerResult{response: response, err: err}
+	}()
+
+	select {
+	case result := <-results:
+		return result.unwrap()
+	case <-ctx.Done():
+	}
+
+	// If the client disconnected or the server is shutting down, leave it to the handler to stop
+	if !errors.Is(context.Cause(ctx), ErrHandlerTimeout) {
+		return (<-results).unwrap()
+	}
+
+	timedOut.Store(true)
+	logger.Warn("Handler timed out", "timeout", timeout)
+
+	return nil, NewHTTPError(w.timeoutStatusCode, "The request took too long to handle.", ErrHandlerTimeout)
+}
+
+// unwrap returns the handler's response and error, panicking again if the handler panicked so that the panic is
+// handled as though the handler had run on the caller's goroutine
+func (r handlerResult) unwrap() (Response, error) {
+	if r.panicErr != nil {
+		panic(r.panicErr)
+	}
+
+	return r.response, r.err
+}
+
+// SetHandlerTimeout sets how long handlers may run for unless they were created with WithTimeout. By default, handlers
+// may run for as long as they like.
+func (w *WebServer) SetHandlerTimeout(timeout time.Duration) {
+	w.handlerTimeout = timeout
+}
+
+// SetReadHeaderTimeout sets how long a client has to send its request line and headers once it has connected. Clients
+// that take longer receive a 408. The body isn't covered, so slow uploads aren't cut off. By default, clients have 10
+// seconds, and zero removes the limit.
+func (w *WebServer) SetReadHeaderTimeout(timeout time.Duration) {
+	w.readHeaderTimeout = timeout
+}
+
+// SetTimeoutStatusCode sets the status code of the response sent when a handler times out, e.g. 504. By default, a
+// 503 is sent. The response is built by the ErrorHandler from an HTTPError whose cause is ErrHandlerTimeout.
+func (w *WebServer) SetTimeoutStatusCode(statusCode int) {
+	w.timeoutStatusCode = statusCode
+}
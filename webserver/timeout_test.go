@@ -0,0 +1,179 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebServer_HandlerTimeout(t *testing.T) {
+	cause := make(chan error, 1)
+
+	ws := NewWebServer()
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/slow"), func(request Request) Response {
+		<-request.Context().Done()
+		cause <- context.Cause(request.Context())
+		return OkResponse()
+	}, WithTimeout(50*time.Millisecond)))
+
+	rawResponse := serveTestRequest(t, &ws, "GET /slow HTTP/1.1\r\nAccept: application/json\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 503 Service Unavailable\r\n") || !strings.Contains(rawResponse, "\"status\":503") {
+		t.Fatalf("Expected a problem+json 503 but received %q", rawResponse)
+	}
+
+	if err := <-cause; !errors.Is(err, ErrHandlerTimeout) {
+		t.Fatalf("Expected the context to be cancelled with ErrHandlerTimeout but received %v", err)
+	}
+}
+
+func TestWebServer_HandlerTimeoutDefault(t *testing.T) {
+	var receivedErr error
+
+	ws := NewWebServer()
+	ws.SetHandlerTimeout(50 * time.Millisecond)
+	ws.SetTimeoutStatusCode(504)
+	ws.SetErrorHandler(func(request Request, err error) Response {
+		receivedErr = err
+		return DefaultErrorHandler(request, err)
+	})
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/slow"), func(request Request) Response {
+		<-request.Context().Done()
+		return OkResponse()
+	}))
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/fast"), func(request Request) Response {
+		return OkResponseWithBody([]byte("done"))
+	}))
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/patient"), func(request Request) Response {
+		time.Sleep(100 * time.Millisecond)
+		return OkResponseWithBody([]byte("done"))
+	}, WithTimeout(time.Second)))
+
+	rawResponse := serveTestRequest(t, &ws, "GET /slow HTTP/1.1\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 504 Gateway Timeout\r\n") {
+		t.Fatalf("Expected a 504 but received %q", rawResponse)
+	}
+
+	var httpErr *HTTPError
+	if !errors.As(receivedErr, &httpErr) || httpErr.StatusCode != 504 || !errors.Is(receivedErr, ErrHandlerTimeout) {
+		t.Fatalf("Expected the error handler to receive a 504 HTTPError caused by the timeout but received %v", receivedErr)
+	}
+
+	rawResponse = serveTestRequest(t, &ws, "GET /fast HTTP/1.1\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(rawResponse, "done") {
+		t.Fatalf("Expected a 200 but received %q", rawResponse)
+	}
+
+	rawResponse = serveTestRequest(t, &ws, "GET /patient HTTP/1.1\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 200 OK\r\n") {
+		t.Fatalf("Expected the handler's own timeout to override the default but received %q", rawResponse)
+	}
+}
+
+func TestWebServer_HandlerTimeoutDiscardsLateResponse(t *testing.T) {
+	returned := make(chan struct{})
+
+	ws := NewWebServer()
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/stubborn"), func(request Request) Response {
+		defer close(returned)
+
+		// Ignore the context and return long after the timeout
+		time.Sleep(150 * time.Millisecond)
+		response := OkResponseWithBody([]byte("too late"))
+		response.Headers().SetHeader("X-Late", "true")
+		return response
+	}, WithTimeout(20*time.Millisecond)))
+
+	rawResponse := serveTestRequest(t, &ws, "GET /stubborn HTTP/1.1\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 503 Service Unavailable\r\n") || strings.Contains(rawResponse, "too late") {
+		t.Fatalf("Expected a 503 without the late response but received %q", rawResponse)
+	}
+
+	<-returned
+}
+
+func TestWebServer_HandlerTimeoutPanic(t *testing.T) {
+	ws := NewWebServer()
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/panic"), func(request Request) Response {
+		panic("handler failed")
+	}, WithTimeout(time.Second)))
+
+	var receivedErr error
+	ws.SetErrorHandler(func(request Request, err error) Response {
+		receivedErr = err
+		return DefaultErrorHandler(request, err)
+	})
+
+	rawResponse := serveTestRequest(t, &ws, "GET /panic HTTP/1.1\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 500 Internal Server Error\r\n") {
+		t.Fatalf("Expected a 500 but received %q", rawResponse)
+	}
+
+	var panicErr *PanicError
+	if !errors.As(receivedErr, &panicErr) || panicErr.Value != "handler failed" || len(panicErr.Stack) == 0 {
+		t.Fatalf("Expected the error handler to receive the handler's PanicError but received %v", receivedErr)
+	}
+}
+
+func TestWebServer_ReadHeaderTimeout(t *testing.T) {
+	ws := NewWebServer()
+	ws.SetReadHeaderTimeout(50 * time.Millisecond)
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/slow"), func(request Request) Response {
+		time.Sleep(100 * time.Millisecond)
+		return OkResponse()
+	}))
+
+	addr, _ := startTestServer(t, &ws)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = ws.Shutdown(ctx)
+	})
+
+	// The headers are never finished
+	rawResponse := sendTestRequest(t, addr, "GET /slow HTTP/1.1\r\nHost: localhost\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 408 Request Timeout\r\n") {
+		t.Fatalf("Expected a 408 but received %q", rawResponse)
+	}
+
+	// The deadline no longer applies once the request has arrived
+	if rawResponse := sendTestRequest(t, addr, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"); !strings.HasPrefix(rawResponse, "HTTP/1.1 200 OK\r\n") {
+		t.Fatalf("Expected a 200 but received %q", rawResponse)
+	}
+}
+
+func TestWebServer_ReadHeaderTimeoutSkipsBody(t *testing.T) {
+	ws := NewWebServer()
+	ws.SetReadHeaderTimeout(50 * time.Millisecond)
+	ws.AddHandler(NewHandler(MethodPost, StringPath("/upload"), func(request Request) Response {
+		return NewResponseWithBody(200, request.Body())
+	}))
+
+	addr, _ := startTestServer(t, &ws)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = ws.Shutdown(ctx)
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _ = conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n\r\nup"))
+
+	// The rest of the body arrives well after the header timeout
+	time.Sleep(100 * time.Millisecond)
+	_, _ = conn.Write([]byte("ld"))
+
+	rawResponse, _ := io.ReadAll(conn)
+	if !strings.HasPrefix(string(rawResponse), "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(string(rawResponse), "\r\n\r\nupld") {
+		t.Fatalf("Expected the whole body to be echoed but received %q", rawResponse)
+	}
+}
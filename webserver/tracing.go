@@ -0,0 +1,300 @@
+package webserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidTraceParent is returned when a traceparent header isn't in the W3C Trace Context format
+var ErrInvalidTraceParent = errors.New("the traceparent header is not valid")
+
+// TraceID identifies a trace across every service it passes through
+type TraceID [16]byte
+
+// String returns the trace ID as lower case hex
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid returns whether the trace ID is not all zeros
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the span ID as lower case hex
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid returns whether the span ID is not all zeros
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// traceFlagSampled is the trace flag set when the trace is being recorded
+const traceFlagSampled = 0x01
+
+// SpanContext identifies a span and carries the W3C Trace Context that is propagated to other services
+type SpanContext struct {
+	// TraceID identifies the trace the span belongs to
+	TraceID TraceID
+	// SpanID identifies the span
+	SpanID SpanID
+	// TraceFlags holds the trace flags, e.g. whether the trace is sampled
+	TraceFlags byte
+	// TraceState holds the vendor specific tracestate header, passed on unchanged
+	TraceState string
+	// Remote is true when the span context was received from another service
+	Remote bool
+}
+
+// IsValid returns whether the span context has a trace ID and span ID
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// IsSampled returns whether the trace is being recorded
+func (sc SpanContext) IsSampled() bool {
+	return sc.TraceFlags&traceFlagSampled != 0
+}
+
+// TraceParent returns the value of the traceparent header to send to other services so that their spans become
+// children of this one
+func (sc SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.TraceFlags)
+}
+
+// parseTraceParent parses a W3C Trace Context traceparent header, e.g.
+// `00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01`
+func parseTraceParent(header string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+
+	// Version 00 has exactly four fields, while future versions may add more after them
+	version := parts[0]
+	if len(version) != 2 || !isLowerHex(version) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+
+	var spanContext SpanContext
+	if !decodeLowerHex(parts[1], spanContext.TraceID[:]) || !decodeLowerHex(parts[2], spanContext.SpanID[:]) {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+
+	var flags [1]byte
+	if !decodeLowerHex(parts[3], flags[:]) {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+
+	spanContext.TraceFlags = flags[0]
+	spanContext.Remote = true
+
+	if !spanContext.IsValid() {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+
+	return spanContext, nil
+}
+
+// decodeLowerHex decodes lower case hex into dst, returning false unless it fills dst exactly
+func decodeLowerHex(s string, dst []byte) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || !isLowerHex(s) {
+		return false
+	}
+
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// isLowerHex returns whether s only contains lower case hex digits
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !(s[i] >= '0' && s[i] <= '9') && !(s[i] >= 'a' && s[i] <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SpanKind describes the relationship between a span and the work it covers
+type SpanKind int
+
+const (
+	// SpanKindInternal is used for a phase of the work within the server
+	SpanKindInternal SpanKind = 1
+	// SpanKindServer is used for the span covering the whole of a request the server handles
+	SpanKindServer SpanKind = 2
+)
+
+// SpanStatusCode describes whether the work a span covers succeeded
+type SpanStatusCode int
+
+const (
+	// SpanStatusUnset means the work completed without being marked as a success or failure
+	SpanStatusUnset SpanStatusCode = 0
+	// SpanStatusOk means the work succeeded
+	SpanStatusOk SpanStatusCode = 1
+	// SpanStatusError means the work failed
+	SpanStatusError SpanStatusCode = 2
+)
+
+// SpanData is a finished span, as passed to a SpanExporter
+type SpanData struct {
+	// Name describes the work the span covers
+	Name string
+	// SpanContext identifies the span
+	SpanContext SpanContext
+	// ParentSpanID identifies the span's parent, which may belong to another service
+	ParentSpanID SpanID
+	// Kind describes the relationship between the span and the work it covers
+	Kind SpanKind
+	// StartTime is when the work started
+	StartTime time.Time
+	// EndTime is when the work finished
+	EndTime time.Time
+	// Attributes describe the work. Values are strings, ints, float64s or bools.
+	Attributes map[string]any
+	// StatusCode describes whether the work succeeded
+	StatusCode SpanStatusCode
+	// StatusMessage describes why the work failed
+	StatusMessage string
+}
+
+// SpanExporter sends finished spans somewhere they can be viewed. Exporters are called from many goroutines at once.
+type SpanExporter interface {
+	// ExportSpans exports the spans of one request
+	ExportSpans(spans []SpanData) error
+}
+
+// requestTrace records the spans for a single request: a server span covering the whole request with a child span for
+// each phase. A nil requestTrace records nothing, so callers don't need to check whether tracing is enabled.
+type requestTrace struct {
+	server SpanData
+	phases []SpanData
+}
+
+// newRequestTrace starts the server span for a request. If the client sent a valid traceparent the span joins its
+// trace and inherits whether it is sampled; otherwise a new sampled trace is started.
+func newRequestTrace(traceParent string, traceState string, start time.Time) *requestTrace {
+	spanContext := SpanContext{TraceFlags: traceFlagSampled}
+	parentSpanID := SpanID{}
+
+	if parent, err := parseTraceParent(traceParent); err == nil {
+		spanContext.TraceID = parent.TraceID
+		spanContext.TraceFlags = parent.TraceFlags
+		spanContext.TraceState = strings.TrimSpace(traceState)
+		parentSpanID = parent.SpanID
+	} else {
+		spanContext.TraceID = newTraceID()
+	}
+
+	spanContext.SpanID = newSpanID()
+
+	return &requestTrace{
+		server: SpanData{
+			Name:         "HTTP request",
+			SpanContext:  spanContext,
+			ParentSpanID: parentSpanID,
+			Kind:         SpanKindServer,
+			StartTime:    start,
+			Attributes:   make(map[string]any),
+		},
+	}
+}
+
+// spanContext returns the span context of the server span
+func (t *requestTrace) spanContext() SpanContext {
+	if t == nil {
+		return SpanContext{}
+	}
+
+	return t.server.SpanContext
+}
+
+// setName sets the name of the server span
+func (t *requestTrace) setName(name string) {
+	if t != nil {
+		t.server.Name = name
+	}
+}
+
+// setAttribute sets an attribute on the server span
+func (t *requestTrace) setAttribute(key string, value any) {
+	if t != nil {
+		t.server.Attributes[key] = value
+	}
+}
+
+// phase records a child span for a phase of the request. A non-nil error marks the phase as failed.
+func (t *requestTrace) phase(name string, start time.Time, end time.Time, err error) {
+	if t == nil {
+		return
+	}
+
+	spanContext := t.server.SpanContext
+	spanContext.SpanID = newSpanID()
+	spanContext.Remote = false
+
+	span := SpanData{
+		Name:         name,
+		SpanContext:  spanContext,
+		ParentSpanID: t.server.SpanContext.SpanID,
+		Kind:         SpanKindInternal,
+		StartTime:    start,
+		EndTime:      end,
+		Attributes:   make(map[string]any),
+	}
+
+	if err != nil {
+		span.StatusCode = SpanStatusError
+		span.StatusMessage = err.Error()
+	}
+
+	t.phases = append(t.phases, span)
+}
+
+// finish ends the server span and exports every span if the trace is sampled. Server errors mark the span as failed.
+func (t *requestTrace) finish(exporter SpanExporter, statusCode int, end time.Time) error {
+	if t == nil || !t.server.SpanContext.IsSampled() {
+		return nil
+	}
+
+	t.server.EndTime = end
+	t.server.Attributes["http.response.status_code"] = statusCode
+
+	if statusCode >= 500 {
+		t.server.StatusCode = SpanStatusError
+	}
+
+	return exporter.ExportSpans(append([]SpanData{t.server}, t.phases...))
+}
+
+// newTraceID generates a random trace ID
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+
+	return id
+}
+
+// newSpanID generates a random span ID
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+
+	return id
+}
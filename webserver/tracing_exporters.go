@@ -0,0 +1,208 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// InMemorySpanExporter keeps exported spans in memory, which is useful for tests
+type InMemorySpanExporter struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemorySpanExporter creates an exporter that keeps spans in memory
+func NewInMemorySpanExporter() *InMemorySpanExporter {
+	return &InMemorySpanExporter{}
+}
+
+// ExportSpans stores the spans
+func (e *InMemorySpanExporter) ExportSpans(spans []SpanData) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// Spans returns every span exported so far
+func (e *InMemorySpanExporter) Spans() []SpanData {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset removes every stored span
+func (e *InMemorySpanExporter) Reset() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.spans = nil
+}
+
+// OTLPFileExporter writes spans as OTLP/JSON, one ExportTraceServiceRequest per line, in the format used by the
+// OpenTelemetry Collector's file receiver and exporter
+type OTLPFileExporter struct {
+	serviceName string
+
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// NewOTLPFileExporter creates an exporter that writes spans to the writer, e.g. a file or RotatingFileWriter, tagged
+// with the given service name
+func NewOTLPFileExporter(writer io.Writer, serviceName string) *OTLPFileExporter {
+	return &OTLPFileExporter{
+		serviceName: serviceName,
+		writer:      writer,
+	}
+}
+
+// The otlp types mirror the parts of the OTLP/JSON trace encoding that are used. IDs are hex encoded and 64-bit
+// integers are strings, as the encoding requires.
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	TraceState        string          `json:"traceState,omitempty"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Flags             uint32          `json:"flags"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    SpanStatusCode `json:"code,omitempty"`
+	Message string         `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// ExportSpans writes the spans as a single line of OTLP/JSON
+func (e *OTLPFileExporter) ExportSpans(spans []SpanData) error {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		otlpSpans = append(otlpSpans, newOTLPSpan(span))
+	}
+
+	traceRequest := otlpTraceRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: newOTLPAttributes(map[string]any{"service.name": e.serviceName}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "golang-webserver"},
+				Spans: otlpSpans,
+			}},
+		}},
+	}
+
+	line, err := json.Marshal(traceRequest)
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	_, err = e.writer.Write(append(line, '\n'))
+	return err
+}
+
+// newOTLPSpan converts a span to its OTLP/JSON form
+func newOTLPSpan(span SpanData) otlpSpan {
+	parentSpanID := ""
+	if span.ParentSpanID.IsValid() {
+		parentSpanID = span.ParentSpanID.String()
+	}
+
+	return otlpSpan{
+		TraceID:           span.SpanContext.TraceID.String(),
+		SpanID:            span.SpanContext.SpanID.String(),
+		TraceState:        span.SpanContext.TraceState,
+		ParentSpanID:      parentSpanID,
+		Flags:             uint32(span.SpanContext.TraceFlags),
+		Name:              span.Name,
+		Kind:              span.Kind,
+		StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		Attributes:        newOTLPAttributes(span.Attributes),
+		Status:            otlpStatus{Code: span.StatusCode, Message: span.StatusMessage},
+	}
+}
+
+// newOTLPAttributes converts attributes to their OTLP/JSON form, sorted by key. Values of unsupported types are
+// formatted as strings.
+func newOTLPAttributes(attributes map[string]any) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	otlpAttributes := make([]otlpAttribute, 0, len(keys))
+	for _, key := range keys {
+		var value otlpValue
+		switch v := attributes[key].(type) {
+		case string:
+			value.StringValue = &v
+		case int:
+			intValue := strconv.Itoa(v)
+			value.IntValue = &intValue
+		case int64:
+			intValue := strconv.FormatInt(v, 10)
+			value.IntValue = &intValue
+		case float64:
+			value.DoubleValue = &v
+		case bool:
+			value.BoolValue = &v
+		default:
+			stringValue := fmt.Sprint(v)
+			value.StringValue = &stringValue
+		}
+
+		otlpAttributes = append(otlpAttributes, otlpAttribute{Key: key, Value: value})
+	}
+
+	return otlpAttributes
+}
@@ -0,0 +1,91 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestInMemorySpanExporter_Reset(t *testing.T) {
+	exporter := NewInMemorySpanExporter()
+
+	_ = exporter.ExportSpans([]SpanData{{Name: "first"}, {Name: "second"}})
+	if len(exporter.Spans()) != 2 {
+		t.Fatalf("Expected 2 spans but received %d", len(exporter.Spans()))
+	}
+
+	exporter.Reset()
+	if len(exporter.Spans()) != 0 {
+		t.Fatalf("Expected no spans after a reset but received %d", len(exporter.Spans()))
+	}
+}
+
+func TestOTLPFileExporter_ExportSpans(t *testing.T) {
+	var buffer bytes.Buffer
+	exporter := NewOTLPFileExporter(&buffer, "test-service")
+
+	parent, _ := parseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	spanContext := parent
+	spanContext.SpanID = SpanID{1, 2, 3, 4, 5, 6, 7, 8}
+
+	start := time.Unix(1700000000, 5)
+	span := SpanData{
+		Name:         "GET /",
+		SpanContext:  spanContext,
+		ParentSpanID: parent.SpanID,
+		Kind:         SpanKindServer,
+		StartTime:    start,
+		EndTime:      start.Add(time.Millisecond),
+		Attributes:   map[string]any{"http.response.status_code": 500, "url.path": "/"},
+		StatusCode:   SpanStatusError,
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := exporter.ExportSpans([]SpanData{span}); err != nil {
+			t.Fatalf("Received an error exporting spans: %v", err)
+		}
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(buffer.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("Expected one line per export but received %d", len(lines))
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal(lines[0], &decoded); err != nil {
+		t.Fatalf("Expected valid JSON but received an error: %v", err)
+	}
+
+	resourceSpans := decoded["resourceSpans"].([]any)[0].(map[string]any)
+	serviceName := resourceSpans["resource"].(map[string]any)["attributes"].([]any)[0].(map[string]any)
+	if serviceName["key"] != "service.name" || serviceName["value"].(map[string]any)["stringValue"] != "test-service" {
+		t.Errorf("Expected the service name resource attribute but received %v", serviceName)
+	}
+
+	otlpSpan := resourceSpans["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)[0].(map[string]any)
+
+	var expectedFields = map[string]any{
+		"traceId":           "4bf92f3577b34da6a3ce929d0e0e4736",
+		"spanId":            "0102030405060708",
+		"parentSpanId":      "00f067aa0ba902b7",
+		"name":              "GET /",
+		"kind":              float64(2),
+		"startTimeUnixNano": "1700000000000000005",
+		"endTimeUnixNano":   "1700000000001000005",
+	}
+	for field, expected := range expectedFields {
+		if otlpSpan[field] != expected {
+			t.Errorf("Expected %s to be %v but received %v", field, expected, otlpSpan[field])
+		}
+	}
+
+	statusCode := otlpSpan["attributes"].([]any)[0].(map[string]any)
+	if statusCode["key"] != "http.response.status_code" || statusCode["value"].(map[string]any)["intValue"] != "500" {
+		t.Errorf("Expected the status code attribute as a string intValue but received %v", statusCode)
+	}
+
+	if otlpSpan["status"].(map[string]any)["code"] != float64(2) {
+		t.Errorf("Expected an error status but received %v", otlpSpan["status"])
+	}
+}
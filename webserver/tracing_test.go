@@ -0,0 +1,199 @@
+package webserver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	var tests = []struct {
+		name       string
+		header     string
+		traceID    string
+		spanID     string
+		traceFlags byte
+		err        error
+	}{
+		{"Sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", 0x01, nil},
+		{"Not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", 0x00, nil},
+		{"Future version with extra fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", 0x01, nil},
+		{"Version 00 with extra fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "", "", 0, ErrInvalidTraceParent},
+		{"Invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", "", 0, ErrInvalidTraceParent},
+		{"Upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "", "", 0, ErrInvalidTraceParent},
+		{"Zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", "", 0, ErrInvalidTraceParent},
+		{"Zero span ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", "", 0, ErrInvalidTraceParent},
+		{"Short trace ID", "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", "", "", 0, ErrInvalidTraceParent},
+		{"Missing flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", "", "", 0, ErrInvalidTraceParent},
+		{"Empty", "", "", "", 0, ErrInvalidTraceParent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spanContext, err := parseTraceParent(tt.header)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected error %v but received %v", tt.err, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if spanContext.TraceID.String() != tt.traceID || spanContext.SpanID.String() != tt.spanID || spanContext.TraceFlags != tt.traceFlags || !spanContext.Remote {
+				t.Errorf("Expected trace %s, span %s and flags %02x but received %+v", tt.traceID, tt.spanID, tt.traceFlags, spanContext)
+			}
+		})
+	}
+}
+
+func TestSpanContext_TraceParent(t *testing.T) {
+	header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	spanContext, err := parseTraceParent(header)
+	if err != nil {
+		t.Fatalf("Received an error parsing the traceparent: %v", err)
+	}
+
+	if spanContext.TraceParent() != header {
+		t.Errorf("Expected %q but received %q", header, spanContext.TraceParent())
+	}
+}
+
+func TestRequestTrace_Finish(t *testing.T) {
+	var tests = []struct {
+		name        string
+		traceParent string
+		statusCode  int
+		exported    bool
+		statusError bool
+	}{
+		{"New trace", "", 200, true, false},
+		{"Sampled parent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", 200, true, false},
+		{"Unsampled parent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", 200, false, false},
+		{"Client error", "", 404, true, false},
+		{"Server error", "", 500, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := NewInMemorySpanExporter()
+
+			trace := newRequestTrace(tt.traceParent, "", time.Now())
+			trace.phase("handler", time.Now(), time.Now(), nil)
+			if err := trace.finish(exporter, tt.statusCode, time.Now()); err != nil {
+				t.Fatalf("Received an error finishing the trace: %v", err)
+			}
+
+			spans := exporter.Spans()
+			if !tt.exported {
+				if len(spans) != 0 {
+					t.Fatalf("Expected no spans to be exported but received %d", len(spans))
+				}
+				return
+			}
+
+			if len(spans) != 2 {
+				t.Fatalf("Expected 2 spans but received %d", len(spans))
+			}
+
+			if spans[1].ParentSpanID != spans[0].SpanContext.SpanID || spans[1].SpanContext.TraceID != spans[0].SpanContext.TraceID {
+				t.Errorf("Expected the phase to be a child of the server span")
+			}
+
+			if (spans[0].StatusCode == SpanStatusError) != tt.statusError {
+				t.Errorf("Expected the server span's status error to be %v but received status %d", tt.statusError, spans[0].StatusCode)
+			}
+		})
+	}
+}
+
+func TestRequestTrace_Nil(t *testing.T) {
+	var trace *requestTrace
+
+	trace.phase("parse", time.Now(), time.Now(), nil)
+	trace.setName("GET")
+	trace.setAttribute("url.path", "/")
+
+	if trace.spanContext().IsValid() {
+		t.Errorf("Expected a nil trace to have an invalid span context")
+	}
+
+	if err := trace.finish(NewInMemorySpanExporter(), 200, time.Now()); err != nil {
+		t.Errorf("Expected finishing a nil trace to do nothing but received %v", err)
+	}
+}
+
+func TestWebServer_EnableTracing(t *testing.T) {
+	exporter := NewInMemorySpanExporter()
+
+	var handlerSpanContext SpanContext
+
+	ws := NewWebServer()
+	ws.EnableTracing(exporter)
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/traced"), func(request Request) Response {
+		handlerSpanContext = request.SpanContext()
+		return OkResponse()
+	}))
+
+	rawResponse := serveTestRequest(t, &ws, "GET /traced HTTP/1.1\r\nHost: localhost\r\n"+
+		"traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\ntracestate: vendor=value\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 200 OK\r\n") {
+		t.Fatalf("Expected a 200 response but received %q", rawResponse)
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 5 {
+		t.Fatalf("Expected the server span and 4 phases but received %d spans", len(spans))
+	}
+
+	server := spans[0]
+	if server.Name != "GET /traced" || server.Kind != SpanKindServer {
+		t.Errorf("Expected a server span named \"GET /traced\" but received %q of kind %d", server.Name, server.Kind)
+	}
+
+	if server.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected the server span to continue the client's trace but received %+v", server)
+	}
+
+	if server.SpanContext.TraceState != "vendor=value" {
+		t.Errorf("Expected the tracestate to be passed on but received %q", server.SpanContext.TraceState)
+	}
+
+	if handlerSpanContext != server.SpanContext {
+		t.Errorf("Expected the request's span context to be the server span's but received %+v", handlerSpanContext)
+	}
+
+	if server.Attributes["http.route"] != "/traced" || server.Attributes["http.response.status_code"] != 200 {
+		t.Errorf("Expected the server span to record the route and status but received %v", server.Attributes)
+	}
+
+	for i, name := range []string{"parse", "route", "handler", "write"} {
+		phase := spans[i+1]
+		if phase.Name != name || phase.ParentSpanID != server.SpanContext.SpanID {
+			t.Errorf("Expected phase %q to be a child of the server span but received %q", name, phase.Name)
+		}
+
+		if phase.StartTime.Before(server.StartTime) || phase.EndTime.After(server.EndTime) {
+			t.Errorf("Expected phase %q to be within the server span", name)
+		}
+	}
+}
+
+func TestWebServer_EnableTracingParseFailure(t *testing.T) {
+	exporter := NewInMemorySpanExporter()
+
+	ws := NewWebServer()
+	ws.EnableTracing(exporter)
+
+	_ = serveTestRequest(t, &ws, "NOT A VALID REQUEST LINE\r\n\r\n")
+
+	spans := exporter.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("Expected the server span, parse and write phases but received %d spans", len(spans))
+	}
+
+	if spans[0].StatusCode != SpanStatusError || spans[1].Name != "parse" || spans[1].StatusCode != SpanStatusError {
+		t.Errorf("Expected the server span and parse phase to have failed but received %+v", spans[:2])
+	}
+}
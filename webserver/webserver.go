@@ -1,42 +1,198 @@
 package webserver
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"net/netip"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+	"time"
 )
 
 type WebServer struct {
 	handlers       []*Handler
 	defaultHandler *Handler
+	middleware     []Middleware
+	errorHandler   ErrorHandler
+	logger         *slog.Logger
+	// connectionIDs is a counter used to give each connection an ID for logging
+	connectionIDs *atomic.Uint64
+	metrics       *Metrics
+	spanExporter  SpanExporter
+	state         *serverState
+	// handlerTimeout is how long handlers created without a timeout may run for, or zero for no limit
+	handlerTimeout    time.Duration
+	timeoutStatusCode int
+	limits            *limits
+	// readHeaderTimeout is how long a client has to send its request line and headers, or zero for no limit
+	readHeaderTimeout time.Duration
+	// trustedProxies are the address ranges whose forwarding headers are believed
+	trustedProxies []netip.Prefix
+	// forwardedHeader is the header the trusted proxies forward the client's address in
+	forwardedHeader ForwardedHeader
 }
 
-var statusResponses = map[int]string{
-	200: "200 OK",
-	202: "202 Accepted",
-	400: "400 Bad Request",
-	404: "404 Not Found",
-	500: "500 Internal Server Error",
+// statusTexts are the reason phrases of the status codes the web server knows
+var statusTexts = map[int]string{
+	100: "Continue",
+	101: "Switching Protocols",
+	200: "OK",
+	201: "Created",
+	202: "Accepted",
+	203: "Non-Authoritative Information",
+	204: "No Content",
+	205: "Reset Content",
+	206: "Partial Content",
+	300: "Multiple Choices",
+	301: "Moved Permanently",
+	302: "Found",
+	303: "See Other",
+	304: "Not Modified",
+	307: "Temporary Redirect",
+	308: "Permanent Redirect",
+	400: "Bad Request",
+	401: "Unauthorized",
+	402: "Payment Required",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	406: "Not Acceptable",
+	407: "Proxy Authentication Required",
+	408: "Request Timeout",
+	409: "Conflict",
+	410: "Gone",
+	411: "Length Required",
+	412: "Precondition Failed",
+	413: "Content Too Large",
+	414: "URI Too Long",
+	415: "Unsupported Media Type",
+	416: "Range Not Satisfiable",
+	417: "Expectation Failed",
+	421: "Misdirected Request",
+	422: "Unprocessable Content",
+	425: "Too Early",
+	426: "Upgrade Required",
+	428: "Precondition Required",
+	429: "Too Many Requests",
+	431: "Request Header Fields Too Large",
+	451: "Unavailable For Legal Reasons",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Gateway Timeout",
+	505: "HTTP Version Not Supported",
+	511: "Network Authentication Required",
+}
+
+// statusClassTexts are the reason phrases used for status codes missing from statusTexts, by their first digit
+var statusClassTexts = map[int]string{
+	1: "Informational",
+	2: "Success",
+	3: "Redirection",
+	4: "Client Error",
+	5: "Server Error",
+}
+
+// statusLine returns the status code and reason phrase for a status line, e.g. `404 Not Found`
+func statusLine(statusCode int) string {
+	return strconv.Itoa(statusCode) + " " + statusText(statusCode)
+}
+
+// statusText returns the reason phrase for a status code, e.g. `Not Found`. Codes the web server doesn't know get the
+// name of their class, so the phrase is never empty.
+func statusText(statusCode int) string {
+	if text, found := statusTexts[statusCode]; found {
+		return text
+	}
+
+	if text, found := statusClassTexts[statusCode/100]; found {
+		return text
+	}
+
+	return "Unknown Status"
 }
 
 func (w *WebServer) StaticFiles(www string) {
 	w.defaultHandler = NewStaticFileHandler(www)
 }
 
+func (w *WebServer) StaticFilesWithOptions(www string, options StaticFileOptions) {
+	w.defaultHandler = NewStaticFileHandlerWithOptions(www, options)
+}
+
 func (w *WebServer) Run(port int) error {
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
 	if err != nil {
 		return fmt.Errorf("failed to start the web server: %w", err)
 	}
 
-	fmt.Printf("Running on port %v\n", port)
+	w.logger.Info("Running on port", "port", port)
 
+	return w.Serve(ln)
+}
+
+// Serve accepts connections from the listener and handles each of them until Shutdown is called, at which point
+// ErrServerClosed is returned. The listener is closed when Serve returns.
+func (w *WebServer) Serve(ln net.Listener) error {
+	defer ln.Close()
+
+	if !w.state.addListener(ln) {
+		return ErrServerClosed
+	}
+	defer w.state.removeListener(ln)
+
+	backoff := acceptBackoff(0)
 	for {
+		// When pausing, wait for a connection slot before accepting so that new connections wait in the backlog
+		hasSlot := false
+		if w.limits.options.Overflow == OverflowPause {
+			if !w.limits.acquire(w.limits.connections, w.state.closing) {
+				return ErrServerClosed
+			}
+			hasSlot = true
+		}
+
 		conn, err := ln.Accept()
 		if err != nil {
+			if hasSlot {
+				w.limits.release(w.limits.connections)
+			}
+
+			if w.state.isShuttingDown() {
+				return ErrServerClosed
+			}
+
+			if isTemporaryAcceptError(err) {
+				delay := backoff.next()
+				w.logger.Warn("Failed to accept connection, retrying", "error", err, "delay", delay)
+
+				select {
+				case <-time.After(delay):
+				case <-w.state.closing:
+				}
+				continue
+			}
+
+			w.logger.Error("Failed to accept connection", "error", err)
 			return fmt.Errorf("failed to accept request: %w", err)
 		}
 
-		go w.handle(conn)
+		backoff.reset()
+
+		if !w.state.addConnection() {
+			_ = conn.Close()
+			if hasSlot {
+				w.limits.release(w.limits.connections)
+			}
+			return ErrServerClosed
+		}
+
+		go w.serveConnection(conn, hasSlot)
 	}
 }
 
@@ -44,27 +200,172 @@ func (w *WebServer) AddHandler(handler *Handler) {
 	w.handlers = append(w.handlers, handler)
 }
 
-func (w *WebServer) handle(conn net.Conn) {
-	defer conn.Close()
+// Use adds middleware that runs around every handler, including the static file and not found handlers. Middleware
+// runs in the order it is added.
+func (w *WebServer) Use(middleware ...Middleware) {
+	w.middleware = append(w.middleware, middleware...)
+}
+
+// SetErrorHandler sets the handler that builds the response when a request fails, e.g. because a handler or middleware
+// panicked. By default, the DefaultErrorHandler is used.
+func (w *WebServer) SetErrorHandler(errorHandler ErrorHandler) {
+	w.errorHandler = errorHandler
+}
+
+// SetLogger sets the logger the web server writes its records to. By default, slog.Default() is used. Use the
+// logger's handler to choose the level, e.g. slog.LevelDebug to see every connection and request.
+func (w *WebServer) SetLogger(logger *slog.Logger) {
+	w.logger = logger
+}
+
+// loggerKey is the context key for the logger of the web server handling a request
+type loggerKey struct{}
+
+// RequestLogger returns the web server's logger for the request, which records its method and path, or slog.Default()
+// outside of one, e.g. in tests. Middleware should log through it so its records go where the server's do.
+func RequestLogger(request Request) *slog.Logger {
+	if logger, ok := request.Context().Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+// EnableMetrics starts collecting metrics about the traffic the web server handles and adds a handler that exposes them
+// in the Prometheus text exposition format at the given path
+func (w *WebServer) EnableMetrics(path string) *Metrics {
+	w.metrics = NewMetrics()
+	w.AddHandler(NewMetricsHandler(StringPath(path), w.metrics))
+
+	return w.metrics
+}
+
+// EnableTracing starts recording a span for each request, which is sent to the exporter once the response is written.
+// Requests carrying a W3C traceparent header continue the client's trace, and only sampled traces are exported.
+func (w *WebServer) EnableTracing(exporter SpanExporter) {
+	w.spanExporter = exporter
+}
+
+func (w *WebServer) handle(netConn net.Conn) {
+	conn := newConnection(netConn, w.connectionIDs.Add(1), w.logger)
+	defer func() {
+		// Hijacked connections belong to their handler
+		if !conn.hijacked.Load() {
+			_ = conn.Close()
+		}
+	}()
+
+	// This runs after the response is written so that every byte is counted
+	if w.metrics != nil {
+		w.metrics.connectionOpened()
+		defer w.metrics.connectionClosed(conn)
+	}
+
+	conn.logger.Debug("Connection accepted")
 
 	// By default, return an internal error if something goes wrong
 	response := InternalErrorResponse()
+	aborted := false
+
+	// This stays nil unless tracing is enabled
+	var trace *requestTrace
+
+	// This is set when shutdown stops the connection waiting for a request, as there's no one to respond to
+	closedIdle := false
 
 	// Create a defer function that will write the response
 	defer func() {
+		if closedIdle {
+			return
+		}
+
+		if aborted {
+			_ = conn.abort()
+			w.finishTrace(conn.logger, trace, response.StatusCode())
+			return
+		}
+
+		writeStart := time.Now()
 		err := writeResponse(conn, response)
 		if err != nil {
-			fmt.Printf("Error was returned while processing request: %v", err)
+			conn.logger.Warn("Response could not be written", "status", response.StatusCode(), "error", err)
+
+			// Part of the response may already be out, so cut the connection short rather than let it look complete
+			if conn.hasWritten() {
+				_ = conn.abort()
+			}
+		}
+
+		trace.phase("write", writeStart, time.Now(), err)
+		w.finishTrace(conn.logger, trace, response.StatusCode())
+
+		if err != nil {
+			return
+		}
+
+		// Responses that switch protocols or stream their body keep the connection until they are done with it, while
+		// hijacking responses take it away from the server entirely
+		switch r := response.(type) {
+		case *takeoverResponse:
+			w.takeOver(conn, r)
+		case *hijackResponse:
+			w.hijack(conn, r)
 		}
 	}()
 
-	request, err := parseRequest(conn)
+	parseStart := time.Now()
+	request, err := w.readRequest(conn)
+	if w.spanExporter != nil {
+		traceParent, traceState := "", ""
+		if err == nil {
+			traceParent, _ = request.Headers().GetHeader("traceparent")
+			traceState, _ = request.Headers().GetHeader("tracestate")
+		}
+
+		trace = newRequestTrace(traceParent, traceState, parseStart)
+		trace.phase("parse", parseStart, time.Now(), err)
+		trace.setAttribute("client.address", conn.RemoteAddr().String())
+	}
+
+	if err != nil && w.state.isShuttingDown() {
+		conn.logger.Debug("Closing idle connection as the web server is shutting down")
+		closedIdle = true
+		return
+	}
+
+	if err != nil && conn.readTimedOut.Load() {
+		conn.logger.Info("Timed out waiting for the request headers", "timeout", w.readHeaderTimeout)
+		response = NewResponseWithBody(408, []byte(statusText(408)))
+		response.Headers().SetHeader("Content-Type", "text/plain; charset=utf-8")
+		return
+	}
+
 	if err != nil {
-		fmt.Printf("Request could not be parsed: %v", err)
+		conn.logger.Warn("Request could not be parsed", "error", err)
+		if w.metrics != nil {
+			w.metrics.parseFailed(err)
+		}
 		return
 	}
 
+	setRemoteAddr(request, conn.RemoteAddr().String())
+	setClientIP(request, resolveClientIP(request, w.trustedProxies, w.forwardedHeader))
+	setSpanContext(request, trace.spanContext())
+
+	ctx, cancel := context.WithCancelCause(w.state.ctx)
+	defer cancel(nil)
+	request = request.WithContext(ctx)
+
+	logger := conn.logger.With("method", request.Method(), "path", request.Path())
+
+	// Middleware renders the errors it rejects requests with through RenderError, which finds the error handler here
+	request = request.WithValue(errorHandlerKey{}, ErrorHandler(func(request Request, err error) Response {
+		return handleError(logger, w.errorHandler, request, err)
+	}))
+	request = request.WithValue(loggerKey{}, logger)
+
 	// First look for an appropriate handler
+	routeStart := time.Now()
 	handlerFound := false
 	var handler *Handler
 	for _, h := range w.handlers {
@@ -76,17 +377,146 @@ func (w *WebServer) handle(conn net.Conn) {
 	}
 
 	// If we don't find a specific handler, assign either a 404 or default handler
-	if !handlerFound && w.defaultHandler == nil {
+	route := ""
+	if handlerFound {
+		route = handler.pathPattern.String()
+	} else if w.defaultHandler == nil {
+		route = "not_found"
 		handler = NewHandler(MethodAny, AnyPath(), func(request Request) Response {
-			fmt.Printf("Handler could not be found for %v", request.Path())
+			logger.Debug("Handler could not be found")
 			return NotFoundResponse()
 		})
-	} else if !handlerFound {
+	} else {
+		route = "default"
 		handler = w.defaultHandler
 	}
 
-	// Execute the handler and assign the results
-	response = handler.Execute(request)
+	trace.phase("route", routeStart, time.Now(), nil)
+	trace.setName(string(request.Method()))
+	trace.setAttribute("http.request.method", string(request.Method()))
+	trace.setAttribute("url.path", request.Path())
+	if handlerFound {
+		trace.setName(fmt.Sprintf("%s %s", request.Method(), route))
+		trace.setAttribute("http.route", route)
+	}
+
+	// Execute the handler wrapped in any middleware and assign the results
+	start := time.Now()
+	stopWatching := conn.watchForDisconnect(cancel)
+	if w.limits.acquire(w.limits.requests, ctx.Done()) {
+		if w.metrics != nil {
+			w.metrics.requestStarted()
+		}
+
+		response, aborted = w.execute(logger, conn, handler, request)
+		w.limits.release(w.limits.requests)
+
+		if w.metrics != nil {
+			w.metrics.requestEnded()
+		}
+	} else {
+		// The rejection still goes through the middleware so that it is logged and gets the usual headers
+		logger.Warn("Rejecting request as the web server is at its request limit")
+		response, aborted = w.execute(logger, conn, NewHandler(MethodAny, AnyPath(), w.busyResponse), request)
+	}
+	stopWatching()
+	trace.phase("handler", start, time.Now(), nil)
+
+	if w.metrics != nil {
+		w.metrics.requestFinished(route, request.Method(), response.StatusCode(), time.Since(start))
+	}
+
+	if !aborted {
+		logger.Debug("Request handled", "status", response.StatusCode())
+	}
+}
+
+// readRequest reads the next request from the connection. The read header timeout and shutdown only apply while the
+// request line and headers arrive, so a slow upload isn't cut off once the client has started sending its body.
+func (w *WebServer) readRequest(conn *connection) (Request, error) {
+	// Slow clients mustn't be able to hold a connection open by sending their headers a byte at a time
+	if w.readHeaderTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(w.readHeaderTimeout))
+	}
+
+	requestRead := w.state.waitForRequest(conn)
+	head, err := parseRequestHead(conn.reader)
+	requestRead()
+	if err != nil {
+		return head, err
+	}
+
+	// Shutdown may have expired the deadline just as the headers arrived, which mustn't cut the body short either
+	_ = conn.SetReadDeadline(time.Time{})
+
+	if err := readRequestBody(head, conn.reader); err != nil {
+		return &request{}, err
+	}
+
+	return head, nil
+}
+
+// finishTrace ends the request's trace and exports it, logging any failure to do so
+func (w *WebServer) finishTrace(logger *slog.Logger, trace *requestTrace, statusCode int) {
+	if err := trace.finish(w.spanExporter, statusCode, time.Now()); err != nil {
+		logger.Warn("Spans could not be exported", "error", err)
+	}
+}
+
+// execute runs the handler wrapped in the middleware, turning a panic into a response from the error handler. If the
+// panic happens after part of the response has been sent, there's no way to send a different one, so the returned bool
+// is true to tell the caller to abort the connection instead.
+func (w *WebServer) execute(logger *slog.Logger, conn *connection, handler *Handler, request Request) (response Response, aborted bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		// Handlers that can time out run on their own goroutine, which recovers the panic and passes it on
+		panicErr, ok := r.(*PanicError)
+		if !ok {
+			panicErr = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+		logger.Error("Handler panicked", "panic", panicErr.Value, "stack", string(panicErr.Stack))
+
+		if conn.hasWritten() {
+			logger.Warn("Aborting connection as the response had already started")
+
+			// The response is never sent, but it records the request as failed
+			response = InternalErrorResponse()
+			aborted = true
+			return
+		}
+
+		response = handleError(logger, w.errorHandler, request, panicErr)
+	}()
+
+	// Errors returned by the handler are rendered inside the middleware so that it can see the error response
+	handlerFunc := func(request Request) Response {
+		response, err := w.executeWithTimeout(logger, handler, request)
+		if errors.Is(err, ErrHandlerTimeout) {
+			// The timeout has already been logged
+			return handleError(logger, w.errorHandler, request, err)
+		} else if err != nil {
+			response = handleError(logger, w.errorHandler, request, err)
+			logger.Log(request.Context(), errorLogLevel(response.StatusCode()), "Handler returned an error", "status", response.StatusCode(), "error", err)
+		}
+
+		return response
+	}
+
+	return chainMiddleware(handlerFunc, w.middleware)(request), false
+}
+
+// errorLogLevel returns the level to log a handler's error at based on the status code it was rendered with. Client
+// errors are expected in normal operation, so only server errors are logged as errors.
+func errorLogLevel(statusCode int) slog.Level {
+	if statusCode >= 500 {
+		return slog.LevelError
+	}
+
+	return slog.LevelInfo
 }
 
 func writeResponse(conn net.Conn, response Response) (finalErr error) {
@@ -98,7 +528,7 @@ func writeResponse(conn net.Conn, response Response) (finalErr error) {
 	}()
 
 	// Write the header
-	_ = mustReturn(conn.Write([]byte(fmt.Sprintf("HTTP/1.1 %v\r\n", statusResponses[response.StatusCode()]))))
+	_ = mustReturn(conn.Write([]byte(fmt.Sprintf("HTTP/1.1 %v\r\n", statusLine(response.StatusCode())))))
 
 	// Loop through each of the headers and add them
 	headersMap := response.Headers().GetAsMap()
@@ -106,6 +536,13 @@ func writeResponse(conn net.Conn, response Response) (finalErr error) {
 		_ = mustReturn(conn.Write([]byte(fmt.Sprintf("%v: %v\r\n", k, v))))
 	}
 
+	// Cookies each get their own Set-Cookie header, as they can't be combined into one
+	for _, cookie := range response.Cookies() {
+		if setCookie := cookie.String(); setCookie != "" {
+			_ = mustReturn(conn.Write([]byte(fmt.Sprintf("Set-Cookie: %v\r\n", setCookie))))
+		}
+	}
+
 	// Add the body
 	_ = mustReturn(conn.Write([]byte("\r\n")))
 	_ = mustReturn(conn.Write(response.Body()))
@@ -123,7 +560,15 @@ func mustReturn[T interface{}](x T, err error) T {
 
 func NewWebServer() WebServer {
 	return WebServer{
-		handlers:       make([]*Handler, 0, 10),
-		defaultHandler: nil,
+		handlers:          make([]*Handler, 0, 10),
+		defaultHandler:    nil,
+		middleware:        make([]Middleware, 0),
+		errorHandler:      DefaultErrorHandler,
+		logger:            slog.Default(),
+		connectionIDs:     &atomic.Uint64{},
+		state:             newServerState(),
+		timeoutStatusCode: 503,
+		readHeaderTimeout: 10 * time.Second,
+		limits:            newLimits(DefaultLimitOptions()),
 	}
 }
@@ -0,0 +1,260 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveTestRequest sends a raw request to the web server over an in-memory connection and returns the raw response
+func serveTestRequest(t *testing.T, w *WebServer, rawRequest string) string {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	go w.handle(server)
+	go func() {
+		_, _ = client.Write([]byte(rawRequest))
+	}()
+
+	rawResponse, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("Received an error reading the response: %v", err)
+	}
+
+	return string(rawResponse)
+}
+
+func TestWebServer_HandlePanicInHandler(t *testing.T) {
+	ws := NewWebServer()
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/panic"), func(request Request) Response {
+		panic("something went wrong")
+	}))
+
+	rawResponse := serveTestRequest(t, &ws, "GET /panic HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 500 Internal Server Error\r\n") {
+		t.Fatalf("Expected a 500 response but received %q", rawResponse)
+	}
+}
+
+func TestWebServer_HandlePanicInMiddlewareWithErrorHandler(t *testing.T) {
+	ws := NewWebServer()
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/"), func(request Request) Response {
+		return OkResponse()
+	}))
+	ws.Use(func(next HandlerFunc) HandlerFunc {
+		return func(request Request) Response {
+			panic(errors.New("middleware failed"))
+		}
+	})
+
+	var receivedErr error
+	ws.SetErrorHandler(func(request Request, err error) Response {
+		receivedErr = err
+		return InternalErrorResponseWithBody([]byte("Sorry, " + request.Path() + " failed"))
+	})
+
+	rawResponse := serveTestRequest(t, &ws, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 500 Internal Server Error\r\n") || !strings.HasSuffix(rawResponse, "Sorry, / failed") {
+		t.Fatalf("Expected the error handler's response but received %q", rawResponse)
+	}
+
+	var panicErr *PanicError
+	if !errors.As(receivedErr, &panicErr) {
+		t.Fatalf("Expected the error handler to receive a PanicError but received %v", receivedErr)
+	}
+
+	if receivedErr.Error() != "handler panicked: middleware failed" || len(panicErr.Stack) == 0 {
+		t.Fatalf("Expected the PanicError to describe the panic and have a stack trace but received %q", receivedErr.Error())
+	}
+}
+
+func TestWebServer_HandlePanicInErrorHandler(t *testing.T) {
+	ws := NewWebServer()
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/"), func(request Request) Response {
+		panic("handler failed")
+	}))
+	ws.SetErrorHandler(func(request Request, err error) Response {
+		panic("error handler failed")
+	})
+
+	rawResponse := serveTestRequest(t, &ws, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 500 Internal Server Error\r\n") {
+		t.Fatalf("Expected a 500 response but received %q", rawResponse)
+	}
+}
+
+func TestWebServer_ExecuteAbortsAfterResponseStarted(t *testing.T) {
+	ws := NewWebServer()
+	handler := NewHandler(MethodGet, AnyPath(), func(request Request) Response {
+		panic("handler failed")
+	})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := newConnection(server, 1, ws.logger)
+	conn.written.Store(true)
+
+	if _, aborted := ws.execute(conn.logger, conn, handler, newTestRequest(MethodGet, "/", nil)); !aborted {
+		t.Fatalf("Expected a panic after the response started to abort the connection")
+	}
+}
+
+func TestWebServer_HandleErrorFromHandler(t *testing.T) {
+	ws := NewWebServer()
+	ws.AddHandler(NewHandlerWithError(MethodGet, StringPath("/api/person"), func(request Request) (Response, error) {
+		return nil, NotFoundError("person not found", errors.New("no rows"))
+	}))
+
+	rawResponse := serveTestRequest(t, &ws, "GET /api/person HTTP/1.1\r\nAccept: application/json\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 404 Not Found\r\n") || !strings.Contains(rawResponse, "\"detail\":\"person not found\"") {
+		t.Fatalf("Expected a problem+json 404 but received %q", rawResponse)
+	}
+
+	if strings.Contains(rawResponse, "no rows") {
+		t.Fatalf("Expected the internal cause not to be sent but received %q", rawResponse)
+	}
+}
+
+func TestWebServer_HandleLogsStructuredRecords(t *testing.T) {
+	var buffer bytes.Buffer
+
+	ws := NewWebServer()
+	ws.SetLogger(slog.New(slog.NewJSONHandler(&buffer, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	ws.AddHandler(NewHandlerWithError(MethodGet, StringPath("/fail"), func(request Request) (Response, error) {
+		return nil, errors.New("database unavailable")
+	}))
+
+	serveTestRequest(t, &ws, "GET /fail HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	var record map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		var candidate map[string]any
+		if err := json.Unmarshal([]byte(line), &candidate); err != nil {
+			t.Fatalf("Could not parse log record %q: %v", line, err)
+		}
+
+		if candidate["msg"] == "Handler returned an error" {
+			record = candidate
+		}
+	}
+
+	if record == nil {
+		t.Fatalf("Expected a record for the handler's error but received %q", buffer.String())
+	}
+
+	expected := map[string]any{
+		"level":         "ERROR",
+		"connection_id": float64(1),
+		"remote_addr":   "pipe",
+		"method":        "GET",
+		"path":          "/fail",
+		"status":        float64(500),
+		"error":         "database unavailable",
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("Expected %s to be %v but was %v", key, value, record[key])
+		}
+	}
+}
+
+func TestWebServer_HandleCancelsContextOnDisconnect(t *testing.T) {
+	cause := make(chan error, 1)
+
+	ws := NewWebServer()
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/slow"), func(request Request) Response {
+		select {
+		case <-request.Context().Done():
+			cause <- context.Cause(request.Context())
+		case <-time.After(5 * time.Second):
+			cause <- nil
+		}
+
+		return OkResponse()
+	}))
+
+	client, server := net.Pipe()
+
+	done := make(chan struct{})
+	go func() {
+		ws.handle(server)
+		close(done)
+	}()
+
+	_, _ = client.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	_ = client.Close()
+
+	if err := <-cause; !errors.Is(err, ErrClientDisconnected) {
+		t.Fatalf("Expected the context to be cancelled by the disconnect but received %v", err)
+	}
+
+	<-done
+}
+
+func TestWebServer_HandleKeepsContextWhenClientSendsMore(t *testing.T) {
+	var ctxErr error
+
+	ws := NewWebServer()
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/"), func(request Request) Response {
+		// Give the server time to read the bytes sent after the request
+		time.Sleep(50 * time.Millisecond)
+		ctxErr = request.Context().Err()
+		return OkResponse()
+	}))
+
+	rawResponse := serveTestRequest(t, &ws, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 200 OK\r\n") {
+		t.Fatalf("Expected a 200 response but received %q", rawResponse)
+	}
+
+	if ctxErr != nil {
+		t.Fatalf("Expected the context not to be cancelled but received %v", ctxErr)
+	}
+}
+
+func TestStatusLine(t *testing.T) {
+	var tests = []struct {
+		statusCode int
+		expected   string
+	}{
+		{200, "200 OK"},
+		{405, "405 Method Not Allowed"},
+		{422, "422 Unprocessable Content"},
+		{502, "502 Bad Gateway"},
+		{299, "299 Success"},
+		{499, "499 Client Error"},
+		{599, "599 Server Error"},
+		{999, "999 Unknown Status"},
+	}
+
+	for _, test := range tests {
+		if line := statusLine(test.statusCode); line != test.expected {
+			t.Errorf("Expected %q for %d but received %q", test.expected, test.statusCode, line)
+		}
+	}
+}
+
+func TestWebServer_WritesReasonForUnknownStatus(t *testing.T) {
+	ws := NewWebServer()
+	ws.AddHandler(NewHandler(MethodGet, StringPath("/"), func(request Request) Response {
+		return NewResponse(418)
+	}))
+
+	rawResponse := serveTestRequest(t, &ws, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if !strings.HasPrefix(rawResponse, "HTTP/1.1 418 Client Error\r\n") {
+		t.Fatalf("Expected a status line with a reason phrase but received %q", rawResponse)
+	}
+}
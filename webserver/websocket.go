@@ -0,0 +1,769 @@
+package webserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrWebSocketHandshake is the cause of the error rendered when an upgrade request isn't a valid WebSocket handshake
+var ErrWebSocketHandshake = errors.New("the WebSocket handshake is invalid")
+
+// ErrWebSocketProtocol is returned when the client breaks the WebSocket protocol, which fails the connection
+var ErrWebSocketProtocol = errors.New("the client broke the WebSocket protocol")
+
+// ErrWebSocketMessageTooLarge is returned when the client sends a message larger than the maximum message size
+var ErrWebSocketMessageTooLarge = errors.New("the WebSocket message is too large")
+
+// ErrWebSocketClosed is returned when using a WebSocket connection that has been closed
+var ErrWebSocketClosed = errors.New("the WebSocket connection is closed")
+
+// webSocketGUID is appended to the client's key to compute Sec-WebSocket-Accept, as defined by RFC 6455
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// webSocketCloseTimeout is how long to wait for the client to answer a close frame before dropping the connection
+const webSocketCloseTimeout = 5 * time.Second
+
+// maxControlPayload is the largest payload a ping, pong or close frame may carry
+const maxControlPayload = 125
+
+// WebSocketMessageType is the type of a WebSocket data message
+type WebSocketMessageType int
+
+const (
+	// WebSocketText is a message holding UTF-8 text
+	WebSocketText WebSocketMessageType = 1
+	// WebSocketBinary is a message holding binary data
+	WebSocketBinary WebSocketMessageType = 2
+)
+
+// Close codes sent in close frames, as defined by RFC 6455
+const (
+	WebSocketCloseNormal             = 1000
+	WebSocketCloseGoingAway          = 1001
+	WebSocketCloseProtocolError      = 1002
+	WebSocketCloseUnsupportedData    = 1003
+	WebSocketCloseNoStatus           = 1005
+	WebSocketCloseInvalidPayload     = 1007
+	WebSocketClosePolicyViolation    = 1008
+	WebSocketCloseMessageTooBig      = 1009
+	WebSocketCloseMandatoryExtension = 1010
+	WebSocketCloseInternalError      = 1011
+)
+
+// Frame opcodes
+const (
+	webSocketOpContinuation = 0x0
+	webSocketOpText         = 0x1
+	webSocketOpBinary       = 0x2
+	webSocketOpClose        = 0x8
+	webSocketOpPing         = 0x9
+	webSocketOpPong         = 0xa
+)
+
+// WebSocketCloseError is returned by ReadMessage when the client closes the connection
+type WebSocketCloseError struct {
+	// Code is the close code the client sent, or WebSocketCloseNoStatus if it didn't send one
+	Code int
+	// Reason is the text the client sent with the code
+	Reason string
+}
+
+// Error returns a description of the close
+func (e *WebSocketCloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("the WebSocket was closed with code %d", e.Code)
+	}
+
+	return fmt.Sprintf("the WebSocket was closed with code %d: %s", e.Code, e.Reason)
+}
+
+// webSocketError is a protocol violation by the client, which fails the connection with the close code
+type webSocketError struct {
+	code int
+	err  error
+}
+
+// Error returns the description of the violation
+func (e *webSocketError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the violation
+func (e *webSocketError) Unwrap() error {
+	return e.err
+}
+
+// protocolError creates a violation that fails the connection with WebSocketCloseProtocolError
+func protocolError(format string, args ...any) *webSocketError {
+	return &webSocketError{
+		code: WebSocketCloseProtocolError,
+		err:  fmt.Errorf("%w: %s", ErrWebSocketProtocol, fmt.Sprintf(format, args...)),
+	}
+}
+
+// WebSocketOptions configures WebSocket connections
+type WebSocketOptions struct {
+	// Subprotocols lists the subprotocols the server speaks, in order of preference. The first one the client also
+	// offers is chosen.
+	Subprotocols []string
+	// TrustedOrigins lists other origins whose pages may connect, e.g. `https://app.example.com`. Pages on this host
+	// are always allowed, as are clients that don't send an Origin.
+	TrustedOrigins []string
+	// Compression enables the permessage-deflate extension when the client offers it
+	Compression bool
+	// MaxMessageSize is the largest message the client may send in bytes, after decompression, or zero for no limit
+	MaxMessageSize int64
+	// PingInterval is how often to ping the client to keep the connection alive, or zero to never ping it
+	PingInterval time.Duration
+	// IdleTimeout is how long to wait for a frame from the client before dropping the connection, or zero to wait
+	// forever. Pongs count, so it should be longer than the PingInterval.
+	IdleTimeout time.Duration
+	// WriteTimeout is how long writing a frame may take, or zero for no limit
+	WriteTimeout time.Duration
+}
+
+// DefaultWebSocketOptions returns options that compress messages, accept messages of up to 16 MiB, ping every 30
+// seconds and drop connections that are silent for a minute
+func DefaultWebSocketOptions() WebSocketOptions {
+	return WebSocketOptions{
+		Compression:    true,
+		MaxMessageSize: 16 << 20,
+		PingInterval:   30 * time.Second,
+		IdleTimeout:    time.Minute,
+		WriteTimeout:   10 * time.Second,
+	}
+}
+
+// WebSocketHandlerFunc runs a WebSocket connection once the handshake is complete. The connection is closed when it
+// returns.
+type WebSocketHandlerFunc func(socket *WebSocket)
+
+// NewWebSocketHandler creates a handler that upgrades GET requests on the path to WebSocket connections
+func NewWebSocketHandler(path Path, options WebSocketOptions, handler WebSocketHandlerFunc, handlerOptions ...HandlerOption) *Handler {
+	return NewHandler(MethodGet, path, func(request Request) Response {
+		return UpgradeWebSocket(request, options, handler)
+	}, handlerOptions...)
+}
+
+// UpgradeWebSocket validates the request's WebSocket handshake and returns the 101 response that accepts it, so that
+// handlers can check the request before upgrading it. Once the response has been written the handler takes over the
+// connection. Invalid handshakes receive an error response instead.
+func UpgradeWebSocket(request Request, options WebSocketOptions, handler WebSocketHandlerFunc) Response {
+	headers := request.Headers()
+
+	if request.Method() != MethodGet {
+		return RenderError(request, BadRequestError("WebSocket connections must be opened with GET.", ErrWebSocketHandshake))
+	}
+
+	if !headerHasToken(headers, "Connection", "upgrade") || !headerHasToken(headers, "Upgrade", "websocket") {
+		response := RenderError(request, NewHTTPError(426, "This resource can only be reached over a WebSocket.", ErrWebSocketHandshake))
+		response.Headers().SetHeader("Upgrade", "websocket")
+		response.Headers().SetHeader("Connection", "Upgrade")
+		return response
+	}
+
+	if version, _ := headers.GetHeader("Sec-WebSocket-Version"); version != "13" {
+		response := RenderError(request, NewHTTPError(426, "Only version 13 of the WebSocket protocol is supported.", ErrWebSocketHandshake))
+		response.Headers().SetHeader("Sec-WebSocket-Version", "13")
+		return response
+	}
+
+	key, _ := headers.GetHeader("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return RenderError(request, BadRequestError("The Sec-WebSocket-Key header is missing or invalid.", ErrWebSocketHandshake))
+	}
+
+	if !headers.HasHeader("Host") {
+		return RenderError(request, BadRequestError("The Host header is missing.", ErrWebSocketHandshake))
+	}
+
+	if origin, err := headers.GetHeader("Origin"); err == nil && !originAllowed(request, origin, options.TrustedOrigins) {
+		return RenderError(request, ForbiddenError("Connections from this origin are not allowed.", ErrWebSocketHandshake))
+	}
+
+	response := NewResponse(101)
+	response.Headers().SetHeader("Upgrade", "websocket")
+	response.Headers().SetHeader("Connection", "Upgrade")
+	response.Headers().SetHeader("Sec-WebSocket-Accept", webSocketAccept(key))
+
+	offeredProtocols, _ := headers.GetHeader("Sec-WebSocket-Protocol")
+	subprotocol := negotiateSubprotocol(offeredProtocols, options.Subprotocols)
+	if subprotocol != "" {
+		response.Headers().SetHeader("Sec-WebSocket-Protocol", subprotocol)
+	}
+
+	var deflate *webSocketDeflate
+	if options.Compression {
+		offeredExtensions, _ := headers.GetHeader("Sec-WebSocket-Extensions")
+		var accepted string
+		if deflate, accepted = negotiateWebSocketDeflate(offeredExtensions); deflate != nil {
+			response.Headers().SetHeader("Sec-WebSocket-Extensions", accepted)
+		}
+	}
+
+	return &takeoverResponse{
+		Response: response,
+		takeover: func(ctx context.Context, conn net.Conn, reader *bufio.Reader) {
+			socket := newWebSocket(conn, reader, request, options, subprotocol, deflate)
+			socket.serve(ctx, handler)
+		},
+	}
+}
+
+// WebSocket is a WebSocket connection. ReadMessage must only be called from one goroutine at a time, but the other
+// methods may be called from any goroutine.
+type WebSocket struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	request     Request
+	options     WebSocketOptions
+	subprotocol string
+	// deflate holds the permessage-deflate state, which is nil unless the extension was negotiated
+	deflate *webSocketDeflate
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+
+	// mutex serialises writes and guards the closing handshake
+	mutex         sync.Mutex
+	closeSent     bool
+	closeReceived bool
+	closed        atomic.Bool
+	closeOnce     sync.Once
+}
+
+// newWebSocket creates a connection that has completed its handshake
+func newWebSocket(conn net.Conn, reader *bufio.Reader, request Request, options WebSocketOptions, subprotocol string, deflate *webSocketDeflate) *WebSocket {
+	// The request's context was cancelled when its handler returned, but the values it carries are still useful
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(request.Context()))
+
+	return &WebSocket{
+		conn:        conn,
+		reader:      reader,
+		request:     request.WithContext(ctx),
+		options:     options,
+		subprotocol: subprotocol,
+		deflate:     deflate,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Request returns the request that opened the connection. Its context is the connection's.
+func (s *WebSocket) Request() Request {
+	return s.request
+}
+
+// Context returns a context that is cancelled when the connection closes or the server shuts down
+func (s *WebSocket) Context() context.Context {
+	return s.ctx
+}
+
+// Subprotocol returns the subprotocol chosen during the handshake, or an empty string if there isn't one
+func (s *WebSocket) Subprotocol() string {
+	return s.subprotocol
+}
+
+// ReadMessage waits for the next message from the client, answering pings along the way. It returns a
+// *WebSocketCloseError when the client closes the connection. If the client breaks the protocol the connection is
+// failed with the matching close code and the error wraps ErrWebSocketProtocol or ErrWebSocketMessageTooLarge.
+func (s *WebSocket) ReadMessage() (WebSocketMessageType, []byte, error) {
+	if s.closed.Load() {
+		return 0, nil, ErrWebSocketClosed
+	}
+
+	messageType, message, err := s.readMessage()
+	if err == nil {
+		return messageType, message, nil
+	}
+
+	var violation *webSocketError
+	var closeErr *WebSocketCloseError
+	switch {
+	case errors.As(err, &violation):
+		s.fail(violation.code)
+	case errors.As(err, &closeErr):
+		// The closing handshake is already complete
+	default:
+		s.closeConnection()
+
+		s.mutex.Lock()
+		closing := s.closeSent
+		s.mutex.Unlock()
+		if closing {
+			// The client didn't answer our close frame in time
+			err = ErrWebSocketClosed
+		}
+	}
+
+	return 0, nil, err
+}
+
+// WriteMessage sends a message to the client
+func (s *WebSocket) WriteMessage(messageType WebSocketMessageType, data []byte) error {
+	if messageType != WebSocketText && messageType != WebSocketBinary {
+		return fmt.Errorf("unsupported WebSocket message type %d", messageType)
+	}
+
+	payload, compressed := data, false
+	if s.deflate != nil {
+		payload, compressed = compressWebSocketMessage(data), true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closeSent {
+		return ErrWebSocketClosed
+	}
+
+	return s.writeFrame(byte(messageType), compressed, payload)
+}
+
+// Ping sends a ping to the client, which answers with a pong carrying the same data
+func (s *WebSocket) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("ping data must be at most %d bytes", maxControlPayload)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closeSent {
+		return ErrWebSocketClosed
+	}
+
+	return s.writeFrame(webSocketOpPing, false, data)
+}
+
+// Close starts the closing handshake by sending a close frame with the code and reason. The client's answer is read
+// by ReadMessage, or when the handler returns, after which the connection is closed. Closing a connection more than
+// once does nothing.
+func (s *WebSocket) Close(code int, reason string) error {
+	if !validCloseCode(code) {
+		return fmt.Errorf("invalid WebSocket close code %d", code)
+	}
+
+	if len(reason) > maxControlPayload-2 || !utf8.ValidString(reason) {
+		return fmt.Errorf("the close reason must be UTF-8 of at most %d bytes", maxControlPayload-2)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closeSent {
+		return nil
+	}
+
+	return s.sendClose(code, reason)
+}
+
+// serve runs the handler, then completes the closing handshake. The connection is closed with WebSocketCloseGoingAway
+// if the server shuts down first.
+func (s *WebSocket) serve(serverCtx context.Context, handler WebSocketHandlerFunc) {
+	defer s.closeConnection()
+
+	stop := context.AfterFunc(serverCtx, func() {
+		_ = s.Close(WebSocketCloseGoingAway, "The server is shutting down.")
+		s.cancel(context.Cause(serverCtx))
+	})
+	defer stop()
+
+	if s.options.PingInterval > 0 {
+		go s.keepAlive()
+	}
+
+	handler(s)
+
+	_ = s.Close(WebSocketCloseNormal, "")
+	s.drain()
+}
+
+// keepAlive pings the client at the ping interval until the connection closes
+func (s *WebSocket) keepAlive() {
+	ticker := time.NewTicker(s.options.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Ping(nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// drain discards messages until the client answers our close frame, so that the closing handshake completes even
+// when the handler stopped reading
+func (s *WebSocket) drain() {
+	s.mutex.Lock()
+	closeReceived := s.closeReceived
+	s.mutex.Unlock()
+
+	if closeReceived {
+		return
+	}
+
+	for {
+		if _, _, err := s.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// readMessage reads frames until a whole message has arrived, handling control frames in between
+func (s *WebSocket) readMessage() (WebSocketMessageType, []byte, error) {
+	limit := s.options.MaxMessageSize
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+
+	var messageType WebSocketMessageType
+	var message []byte
+	started, compressed := false, false
+
+	for {
+		s.extendReadDeadline()
+
+		frame, err := s.readFrame(limit - int64(len(message)))
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frame.opcode {
+		case webSocketOpPing:
+			s.mutex.Lock()
+			if !s.closeSent {
+				err = s.writeFrame(webSocketOpPong, false, frame.payload)
+			}
+			s.mutex.Unlock()
+
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case webSocketOpPong:
+			continue
+		case webSocketOpClose:
+			return 0, nil, s.receiveClose(frame.payload)
+		case webSocketOpText, webSocketOpBinary:
+			if started {
+				return 0, nil, protocolError("a new message started before the last one finished")
+			}
+
+			if frame.compressed && s.deflate == nil {
+				return 0, nil, protocolError("a message was compressed without negotiating compression")
+			}
+
+			started, compressed = true, frame.compressed
+			messageType = WebSocketMessageType(frame.opcode)
+			message = frame.payload
+		case webSocketOpContinuation:
+			if !started {
+				return 0, nil, protocolError("a continuation frame arrived without a message to continue")
+			}
+
+			if frame.compressed {
+				return 0, nil, protocolError("a continuation frame had the compression bit set")
+			}
+
+			message = append(message, frame.payload...)
+		default:
+			return 0, nil, protocolError("unknown opcode %d", frame.opcode)
+		}
+
+		if !frame.fin {
+			continue
+		}
+
+		if compressed {
+			if message, err = s.deflate.decompress(message, limit); err != nil {
+				return 0, nil, err
+			}
+		}
+
+		if messageType == WebSocketText && !utf8.Valid(message) {
+			return 0, nil, &webSocketError{
+				code: WebSocketCloseInvalidPayload,
+				err:  fmt.Errorf("%w: a text message was not valid UTF-8", ErrWebSocketProtocol),
+			}
+		}
+
+		if message == nil {
+			message = make([]byte, 0)
+		}
+
+		return messageType, message, nil
+	}
+}
+
+// webSocketFrame is a single frame read from the client, with its payload unmasked
+type webSocketFrame struct {
+	fin        bool
+	compressed bool
+	opcode     byte
+	payload    []byte
+}
+
+// readFrame reads the next frame from the client, rejecting it if its payload is longer than the limit
+func (s *WebSocket) readFrame(limit int64) (webSocketFrame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(s.reader, header[:]); err != nil {
+		return webSocketFrame{}, err
+	}
+
+	frame := webSocketFrame{
+		fin:        header[0]&0x80 != 0,
+		compressed: header[0]&0x40 != 0,
+		opcode:     header[0] & 0x0f,
+	}
+
+	if header[0]&0x30 != 0 {
+		return frame, protocolError("reserved bits were set")
+	}
+
+	if header[1]&0x80 == 0 {
+		return frame, protocolError("frames from the client must be masked")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(s.reader, extended[:]); err != nil {
+			return frame, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(s.reader, extended[:]); err != nil {
+			return frame, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+
+		if length > math.MaxInt64 {
+			return frame, protocolError("the payload length was too large")
+		}
+	}
+
+	if frame.opcode >= webSocketOpClose {
+		if !frame.fin {
+			return frame, protocolError("a control frame was fragmented")
+		}
+
+		if length > maxControlPayload {
+			return frame, protocolError("a control frame's payload was longer than %d bytes", maxControlPayload)
+		}
+
+		if frame.compressed {
+			return frame, protocolError("a control frame had the compression bit set")
+		}
+	} else if length > uint64(limit) {
+		return frame, &webSocketError{code: WebSocketCloseMessageTooBig, err: ErrWebSocketMessageTooLarge}
+	}
+
+	var key [4]byte
+	if _, err := io.ReadFull(s.reader, key[:]); err != nil {
+		return frame, err
+	}
+
+	// The payload is read as it arrives rather than allocated up front, so a large length costs nothing until it is sent
+	payload, err := io.ReadAll(io.LimitReader(s.reader, int64(length)))
+	if err != nil {
+		return frame, err
+	} else if uint64(len(payload)) != length {
+		return frame, io.ErrUnexpectedEOF
+	}
+
+	maskWebSocketBytes(key, payload)
+	frame.payload = payload
+
+	return frame, nil
+}
+
+// receiveClose handles a close frame from the client, answering it if we haven't already sent one and closing the
+// connection
+func (s *WebSocket) receiveClose(payload []byte) error {
+	closeErr := &WebSocketCloseError{Code: WebSocketCloseNoStatus}
+
+	if len(payload) == 1 {
+		return protocolError("a close frame's payload was a single byte")
+	}
+
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+
+		if !validCloseCode(closeErr.Code) {
+			return protocolError("invalid close code %d", closeErr.Code)
+		}
+
+		if !utf8.ValidString(closeErr.Reason) {
+			return &webSocketError{
+				code: WebSocketCloseInvalidPayload,
+				err:  fmt.Errorf("%w: a close reason was not valid UTF-8", ErrWebSocketProtocol),
+			}
+		}
+	}
+
+	s.mutex.Lock()
+	s.closeReceived = true
+	if !s.closeSent {
+		if closeErr.Code == WebSocketCloseNoStatus {
+			s.closeSent = true
+			_ = s.writeFrame(webSocketOpClose, false, nil)
+		} else {
+			_ = s.sendClose(closeErr.Code, "")
+		}
+	}
+	s.mutex.Unlock()
+
+	s.closeConnection()
+	return closeErr
+}
+
+// fail sends a close frame with the code, if one hasn't been sent yet, and closes the connection
+func (s *WebSocket) fail(code int) {
+	s.mutex.Lock()
+	if !s.closeSent {
+		_ = s.sendClose(code, "")
+	}
+	s.mutex.Unlock()
+
+	s.closeConnection()
+}
+
+// sendClose writes a close frame and gives the client a little while to answer it. The mutex must be held.
+func (s *WebSocket) sendClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+
+	s.closeSent = true
+	err := s.writeFrame(webSocketOpClose, false, payload)
+
+	if !s.closeReceived {
+		_ = s.conn.SetReadDeadline(time.Now().Add(webSocketCloseTimeout))
+	}
+
+	return err
+}
+
+// writeFrame writes a single unmasked frame, as frames from the server are never masked. The mutex must be held.
+func (s *WebSocket) writeFrame(opcode byte, compressed bool, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+
+	first := 0x80 | opcode
+	if compressed {
+		first |= 0x40
+	}
+	frame = append(frame, first)
+
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= math.MaxUint16:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	frame = append(frame, payload...)
+
+	if s.options.WriteTimeout > 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(s.options.WriteTimeout))
+	}
+
+	if _, err := s.conn.Write(frame); err != nil {
+		// A partly written frame leaves the connection unusable
+		s.closeConnection()
+		return fmt.Errorf("failed to write WebSocket frame: %w", err)
+	}
+
+	return nil
+}
+
+// extendReadDeadline gives the client another idle timeout to send its next frame. Once the closing handshake has
+// started, the close timeout applies instead.
+func (s *WebSocket) extendReadDeadline() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.options.IdleTimeout > 0 && !s.closeSent {
+		_ = s.conn.SetReadDeadline(time.Now().Add(s.options.IdleTimeout))
+	}
+}
+
+// closeConnection closes the underlying connection and cancels the connection's context
+func (s *WebSocket) closeConnection() {
+	s.closeOnce.Do(func() {
+		s.closed.Store(true)
+		_ = s.conn.Close()
+		s.cancel(ErrWebSocketClosed)
+	})
+}
+
+// validCloseCode returns whether a close code may be sent in a close frame. Codes 1005, 1006 and 1015 are reserved for
+// reporting closes that didn't have a code, and the rest of 1000-2999 is reserved for future versions of the protocol.
+func validCloseCode(code int) bool {
+	return (code >= 1000 && code <= 1003) || (code >= 1007 && code <= 1014) || (code >= 3000 && code <= 4999)
+}
+
+// maskWebSocketBytes applies a frame's masking key to its payload, which both masks and unmasks it
+func maskWebSocketBytes(key [4]byte, payload []byte) {
+	for i := range payload {
+		payload[i] ^= key[i&3]
+	}
+}
+
+// webSocketAccept computes the Sec-WebSocket-Accept value that proves the server understood the client's key
+func webSocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// negotiateSubprotocol returns the first of the server's subprotocols that the client offered, or an empty string if
+// there isn't one
+func negotiateSubprotocol(offered string, supported []string) string {
+	for _, subprotocol := range supported {
+		for _, offer := range strings.Split(offered, ",") {
+			if strings.TrimSpace(offer) == subprotocol {
+				return subprotocol
+			}
+		}
+	}
+
+	return ""
+}
+
+// headerHasToken returns whether a comma-separated header contains the token, ignoring case
+func headerHasToken(headers RequestHeaders, header string, token string) bool {
+	value, err := headers.GetHeader(header)
+	if err != nil {
+		return false
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,144 @@
+package webserver
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// webSocketDeflateWindow is the size of the sliding window deflate refers back into
+const webSocketDeflateWindow = 1 << 15
+
+// webSocketDeflateTail is appended to a compressed message before inflating it. The first four bytes are the end of
+// the flush that senders strip from each message, and the rest is an empty final block that ends the stream cleanly.
+var webSocketDeflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// webSocketFlateWriters reuses compressors between messages, as each one allocates several hundred kilobytes. This is
+// possible because the server never keeps its compression context between messages.
+var webSocketFlateWriters sync.Pool
+
+// webSocketDeflate holds a connection's permessage-deflate state, as defined by RFC 7692
+type webSocketDeflate struct {
+	// contextTakeover is set when the client compresses each message using the ones before it, so they are kept in
+	// window to inflate the next one
+	contextTakeover bool
+	window          []byte
+}
+
+// negotiateWebSocketDeflate picks the first permessage-deflate offer in a Sec-WebSocket-Extensions header that the
+// server can accept, returning its state and the value to answer with. The state is nil if no offer was acceptable.
+func negotiateWebSocketDeflate(header string) (*webSocketDeflate, string) {
+offers:
+	for _, offer := range strings.Split(header, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+
+		deflate := &webSocketDeflate{contextTakeover: true}
+		seen := make(map[string]bool)
+
+		for _, param := range params[1:] {
+			name, value, hasValue := strings.Cut(strings.TrimSpace(param), "=")
+			name = strings.TrimSpace(name)
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+
+			if seen[name] {
+				continue offers
+			}
+			seen[name] = true
+
+			switch name {
+			case "server_no_context_takeover":
+				if hasValue {
+					continue offers
+				}
+			case "client_no_context_takeover":
+				if hasValue {
+					continue offers
+				}
+				deflate.contextTakeover = false
+			case "server_max_window_bits":
+				// Go's compressor always uses the full window, so a smaller one can't be honoured
+				if bits, err := strconv.Atoi(value); err != nil || bits != 15 {
+					continue offers
+				}
+			case "client_max_window_bits":
+				// Any window the client uses can be inflated, so there is no need to limit it
+				if bits, err := strconv.Atoi(value); hasValue && (err != nil || bits < 8 || bits > 15) {
+					continue offers
+				}
+			default:
+				continue offers
+			}
+		}
+
+		accepted := "permessage-deflate; server_no_context_takeover"
+		if !deflate.contextTakeover {
+			accepted += "; client_no_context_takeover"
+		}
+
+		return deflate, accepted
+	}
+
+	return nil, ""
+}
+
+// decompress inflates a message from the client, failing the connection if it is invalid or inflates to more than the
+// limit
+func (d *webSocketDeflate) decompress(compressed []byte, limit int64) ([]byte, error) {
+	source := io.MultiReader(bytes.NewReader(compressed), bytes.NewReader(webSocketDeflateTail))
+	reader := flate.NewReaderDict(source, d.window)
+	defer reader.Close()
+
+	// Reading one byte past the limit shows whether it was exceeded, unless there is no limit and that would overflow
+	limited := io.Reader(reader)
+	if limit < math.MaxInt64 {
+		limited = io.LimitReader(reader, limit+1)
+	}
+
+	message, err := io.ReadAll(limited)
+	if err != nil {
+		return nil, &webSocketError{
+			code: WebSocketCloseInvalidPayload,
+			err:  fmt.Errorf("%w: a compressed message could not be inflated", ErrWebSocketProtocol),
+		}
+	}
+
+	if int64(len(message)) > limit {
+		return nil, &webSocketError{code: WebSocketCloseMessageTooBig, err: ErrWebSocketMessageTooLarge}
+	}
+
+	if d.contextTakeover {
+		d.window = append(d.window, message...)
+		if excess := len(d.window) - webSocketDeflateWindow; excess > 0 {
+			d.window = append(d.window[:0], d.window[excess:]...)
+		}
+	}
+
+	return message, nil
+}
+
+// compressWebSocketMessage deflates a message to send to the client, starting afresh for every message
+func compressWebSocketMessage(message []byte) []byte {
+	var buffer bytes.Buffer
+
+	writer, _ := webSocketFlateWriters.Get().(*flate.Writer)
+	if writer == nil {
+		writer, _ = flate.NewWriter(&buffer, flate.BestSpeed)
+	} else {
+		writer.Reset(&buffer)
+	}
+	defer webSocketFlateWriters.Put(writer)
+
+	// Writing to a bytes.Buffer can't fail
+	_, _ = writer.Write(message)
+	_ = writer.Flush()
+
+	return bytes.TrimSuffix(buffer.Bytes(), webSocketDeflateTail[:4])
+}
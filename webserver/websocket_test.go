@@ -0,0 +1,631 @@
+package webserver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webSocketTestClient speaks the client side of the WebSocket protocol to a test server
+type webSocketTestClient struct {
+	t        *testing.T
+	conn     net.Conn
+	reader   *bufio.Reader
+	response string
+}
+
+// dialWebSocket opens a connection and sends a handshake with the extra headers, returning once the response head has
+// been read
+func dialWebSocket(t *testing.T, addr string, extraHeaders string) *webSocketTestClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	handshake := "GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n" + extraHeaders + "\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		t.Fatalf("Could not send the handshake: %v", err)
+	}
+
+	client := &webSocketTestClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	for {
+		line, err := client.reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Could not read the handshake response: %v", err)
+		}
+
+		client.response += line
+		if line == "\r\n" {
+			return client
+		}
+	}
+}
+
+// writeFrame sends a masked frame
+func (c *webSocketTestClient) writeFrame(fin bool, rsv1 bool, opcode byte, payload []byte) {
+	c.writeRawFrame(fin, rsv1, opcode, payload, true)
+}
+
+// writeRawFrame sends a frame, masking it if asked to
+func (c *webSocketTestClient) writeRawFrame(fin bool, rsv1 bool, opcode byte, payload []byte, masked bool) {
+	c.t.Helper()
+
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	if rsv1 {
+		first |= 0x40
+	}
+
+	frame := []byte{first}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 65535:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	body := append([]byte(nil), payload...)
+	if masked {
+		key := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+		frame = append(frame, key[:]...)
+		maskWebSocketBytes(key, body)
+	}
+
+	if _, err := c.conn.Write(append(frame, body...)); err != nil {
+		c.t.Fatalf("Could not send a frame: %v", err)
+	}
+}
+
+// readFrame reads a frame from the server, which must not be masked
+func (c *webSocketTestClient) readFrame() (fin bool, rsv1 bool, opcode byte, payload []byte) {
+	c.t.Helper()
+
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		c.t.Fatalf("Could not read a frame: %v", err)
+	}
+
+	if header[1]&0x80 != 0 {
+		c.t.Fatalf("Expected frames from the server to be unmasked")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		_, _ = io.ReadFull(c.reader, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		_, _ = io.ReadFull(c.reader, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		c.t.Fatalf("Could not read a frame's payload: %v", err)
+	}
+
+	return header[0]&0x80 != 0, header[0]&0x40 != 0, header[0] & 0x0f, payload
+}
+
+// expectFrame reads a frame and checks its opcode and payload
+func (c *webSocketTestClient) expectFrame(opcode byte, payload string) {
+	c.t.Helper()
+
+	_, _, receivedOpcode, receivedPayload := c.readFrame()
+	if receivedOpcode != opcode || string(receivedPayload) != payload {
+		c.t.Fatalf("Expected opcode %d with %q but received opcode %d with %q", opcode, payload, receivedOpcode, receivedPayload)
+	}
+}
+
+// expectClose reads a close frame and checks its code, then checks the server closes the connection
+func (c *webSocketTestClient) expectClose(code int) {
+	c.t.Helper()
+
+	_, _, opcode, payload := c.readFrame()
+	if opcode != webSocketOpClose || len(payload) < 2 {
+		c.t.Fatalf("Expected a close frame but received opcode %d with %q", opcode, payload)
+	}
+
+	if received := int(binary.BigEndian.Uint16(payload)); received != code {
+		c.t.Fatalf("Expected close code %d but received %d", code, received)
+	}
+
+	if _, err := c.reader.ReadByte(); err == nil {
+		c.t.Fatalf("Expected the server to close the connection after the close frame")
+	}
+}
+
+// closePayload builds the payload of a close frame
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+// startWebSocketTestServer serves a WebSocket handler on /ws and returns its address
+func startWebSocketTestServer(t *testing.T, options WebSocketOptions, handler WebSocketHandlerFunc) (*WebServer, string) {
+	t.Helper()
+
+	ws := NewWebServer()
+	ws.AddHandler(NewWebSocketHandler(StringPath("/ws"), options, handler))
+
+	addr, _ := startTestServer(t, &ws)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = ws.Shutdown(ctx)
+	})
+
+	return &ws, addr
+}
+
+// echoWebSocket sends every message back to the client
+func echoWebSocket(socket *WebSocket) {
+	for {
+		messageType, message, err := socket.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if err := socket.WriteMessage(messageType, message); err != nil {
+			return
+		}
+	}
+}
+
+func TestWebSocketAccept(t *testing.T) {
+	// The example from RFC 6455
+	if accept := webSocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Expected the accept value from the RFC but received %q", accept)
+	}
+}
+
+func TestUpgradeWebSocket_InvalidHandshakes(t *testing.T) {
+	valid := map[string]string{
+		"Host":                  "localhost",
+		"Upgrade":               "websocket",
+		"Connection":            "keep-alive, Upgrade",
+		"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+		"Sec-WebSocket-Version": "13",
+	}
+
+	with := func(header string, value string) map[string]string {
+		headers := make(map[string]string)
+		for k, v := range valid {
+			headers[k] = v
+		}
+
+		if value == "" {
+			delete(headers, header)
+		} else {
+			headers[header] = value
+		}
+
+		return headers
+	}
+
+	var tests = []struct {
+		name           string
+		method         Method
+		headers        map[string]string
+		expectedStatus int
+		expectedHeader string
+	}{
+		{"Valid handshake", MethodGet, valid, 101, "Sec-WebSocket-Accept"},
+		{"Wrong method", MethodPost, valid, 400, ""},
+		{"Missing upgrade", MethodGet, with("Upgrade", ""), 426, "Upgrade"},
+		{"Missing connection upgrade", MethodGet, with("Connection", "keep-alive"), 426, "Upgrade"},
+		{"Unsupported version", MethodGet, with("Sec-WebSocket-Version", "8"), 426, "Sec-WebSocket-Version"},
+		{"Missing key", MethodGet, with("Sec-WebSocket-Key", ""), 400, ""},
+		{"Short key", MethodGet, with("Sec-WebSocket-Key", "c2hvcnQ="), 400, ""},
+		{"Missing host", MethodGet, with("Host", ""), 400, ""},
+		{"Same origin", MethodGet, with("Origin", "https://localhost"), 101, ""},
+		{"Cross origin", MethodGet, with("Origin", "https://evil.example"), 403, ""},
+		{"Trusted origin", MethodGet, with("Origin", "https://app.example.com"), 101, ""},
+	}
+
+	options := DefaultWebSocketOptions()
+	options.TrustedOrigins = []string{"https://app.example.com"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := UpgradeWebSocket(newTestRequest(tt.method, "/ws", tt.headers), options, echoWebSocket)
+			if response.StatusCode() != tt.expectedStatus {
+				t.Fatalf("Expected %d but received %d", tt.expectedStatus, response.StatusCode())
+			}
+
+			if tt.expectedHeader != "" && !response.Headers().HasHeader(tt.expectedHeader) {
+				t.Errorf("Expected the %s header to be set", tt.expectedHeader)
+			}
+
+			if _, isUpgrade := response.(*takeoverResponse); isUpgrade != (tt.expectedStatus == 101) {
+				t.Errorf("Expected only accepted handshakes to take over the connection")
+			}
+		})
+	}
+}
+
+func TestNegotiateSubprotocol(t *testing.T) {
+	var tests = []struct {
+		offered   string
+		supported []string
+		expected  string
+	}{
+		{"chat, superchat", []string{"superchat", "chat"}, "superchat"},
+		{"chat", []string{"superchat"}, ""},
+		{"", []string{"chat"}, ""},
+		{"chat", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.offered, func(t *testing.T) {
+			if subprotocol := negotiateSubprotocol(tt.offered, tt.supported); subprotocol != tt.expected {
+				t.Errorf("Expected %q but received %q", tt.expected, subprotocol)
+			}
+		})
+	}
+}
+
+func TestNegotiateWebSocketDeflate(t *testing.T) {
+	var tests = []struct {
+		name            string
+		offered         string
+		expected        string
+		contextTakeover bool
+	}{
+		{"Plain offer", "permessage-deflate", "permessage-deflate; server_no_context_takeover", true},
+		{"Client window bits", "permessage-deflate; client_max_window_bits", "permessage-deflate; server_no_context_takeover", true},
+		{"Client no context takeover", "permessage-deflate; client_no_context_takeover", "permessage-deflate; server_no_context_takeover; client_no_context_takeover", false},
+		{"Full server window", "permessage-deflate; server_max_window_bits=15", "permessage-deflate; server_no_context_takeover", true},
+		{"Small server window falls back", "permessage-deflate; server_max_window_bits=10, permessage-deflate", "permessage-deflate; server_no_context_takeover", true},
+		{"Small server window", "permessage-deflate; server_max_window_bits=10", "", false},
+		{"Invalid client window", "permessage-deflate; client_max_window_bits=16", "", false},
+		{"Unknown parameter", "permessage-deflate; mystery", "", false},
+		{"Duplicate parameter", "permessage-deflate; client_no_context_takeover; client_no_context_takeover", "", false},
+		{"Other extension", "x-webkit-deflate-frame", "", false},
+		{"No offer", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deflate, accepted := negotiateWebSocketDeflate(tt.offered)
+			if accepted != tt.expected {
+				t.Fatalf("Expected %q but received %q", tt.expected, accepted)
+			}
+
+			if deflate != nil && deflate.contextTakeover != tt.contextTakeover {
+				t.Errorf("Expected context takeover to be %v", tt.contextTakeover)
+			}
+		})
+	}
+}
+
+func TestWebSocket_Echo(t *testing.T) {
+	options := DefaultWebSocketOptions()
+	options.Compression = false
+	options.Subprotocols = []string{"echo"}
+	_, addr := startWebSocketTestServer(t, options, echoWebSocket)
+
+	client := dialWebSocket(t, addr, "Sec-WebSocket-Protocol: chat, echo\r\n")
+	if !strings.HasPrefix(client.response, "HTTP/1.1 101 Switching Protocols\r\n") {
+		t.Fatalf("Expected the handshake to be accepted but received %q", client.response)
+	}
+
+	if !strings.Contains(client.response, "Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n") {
+		t.Errorf("Expected the accept header in %q", client.response)
+	}
+
+	if !strings.Contains(client.response, "Sec-WebSocket-Protocol: echo\r\n") {
+		t.Errorf("Expected the echo subprotocol to be chosen in %q", client.response)
+	}
+
+	t.Run("Text message", func(t *testing.T) {
+		client.writeFrame(true, false, webSocketOpText, []byte("Hello, world"))
+		client.expectFrame(webSocketOpText, "Hello, world")
+	})
+
+	t.Run("Binary message", func(t *testing.T) {
+		client.writeFrame(true, false, webSocketOpBinary, []byte{0x00, 0xff, 0x10})
+		client.expectFrame(webSocketOpBinary, "\x00\xff\x10")
+	})
+
+	t.Run("Empty message", func(t *testing.T) {
+		client.writeFrame(true, false, webSocketOpText, nil)
+		client.expectFrame(webSocketOpText, "")
+	})
+
+	t.Run("Large message", func(t *testing.T) {
+		message := strings.Repeat("0123456789", 7000)
+		client.writeFrame(true, false, webSocketOpText, []byte(message))
+		client.expectFrame(webSocketOpText, message)
+	})
+
+	t.Run("Fragmented message with interleaved ping", func(t *testing.T) {
+		client.writeFrame(false, false, webSocketOpText, []byte("Hel"))
+		client.writeFrame(true, false, webSocketOpPing, []byte("are you there"))
+		client.writeFrame(false, false, webSocketOpContinuation, []byte("lo, "))
+		client.writeFrame(true, false, webSocketOpContinuation, []byte("wörld"))
+
+		client.expectFrame(webSocketOpPong, "are you there")
+		client.expectFrame(webSocketOpText, "Hello, wörld")
+	})
+
+	t.Run("UTF-8 split across fragments", func(t *testing.T) {
+		euro := []byte("€")
+		client.writeFrame(false, false, webSocketOpText, euro[:1])
+		client.writeFrame(true, false, webSocketOpContinuation, euro[1:])
+		client.expectFrame(webSocketOpText, "€")
+	})
+
+	t.Run("Unsolicited pong", func(t *testing.T) {
+		client.writeFrame(true, false, webSocketOpPong, []byte("ignored"))
+		client.writeFrame(true, false, webSocketOpText, []byte("still here"))
+		client.expectFrame(webSocketOpText, "still here")
+	})
+
+	t.Run("Close handshake", func(t *testing.T) {
+		client.writeFrame(true, false, webSocketOpClose, closePayload(WebSocketCloseNormal, "bye"))
+		client.expectClose(WebSocketCloseNormal)
+	})
+}
+
+func TestWebSocket_ProtocolViolations(t *testing.T) {
+	var tests = []struct {
+		name         string
+		send         func(client *webSocketTestClient)
+		expectedCode int
+	}{
+		{"Unmasked frame", func(c *webSocketTestClient) {
+			c.writeRawFrame(true, false, webSocketOpText, []byte("hi"), false)
+		}, WebSocketCloseProtocolError},
+		{"Reserved bits", func(c *webSocketTestClient) {
+			c.writeFrame(true, true, webSocketOpText, []byte("hi"))
+		}, WebSocketCloseProtocolError},
+		{"Reserved data opcode", func(c *webSocketTestClient) {
+			c.writeFrame(true, false, 0x3, nil)
+		}, WebSocketCloseProtocolError},
+		{"Reserved control opcode", func(c *webSocketTestClient) {
+			c.writeFrame(true, false, 0xb, nil)
+		}, WebSocketCloseProtocolError},
+		{"Fragmented ping", func(c *webSocketTestClient) {
+			c.writeFrame(false, false, webSocketOpPing, []byte("hi"))
+		}, WebSocketCloseProtocolError},
+		{"Ping too long", func(c *webSocketTestClient) {
+			c.writeFrame(true, false, webSocketOpPing, bytes.Repeat([]byte("x"), 126))
+		}, WebSocketCloseProtocolError},
+		{"Continuation without message", func(c *webSocketTestClient) {
+			c.writeFrame(true, false, webSocketOpContinuation, []byte("hi"))
+		}, WebSocketCloseProtocolError},
+		{"New message during fragments", func(c *webSocketTestClient) {
+			c.writeFrame(false, false, webSocketOpText, []byte("one"))
+			c.writeFrame(true, false, webSocketOpText, []byte("two"))
+		}, WebSocketCloseProtocolError},
+		{"Invalid UTF-8", func(c *webSocketTestClient) {
+			c.writeFrame(true, false, webSocketOpText, []byte{0xce, 0xba, 0xe1, 0xbd, 0xb9, 0xcf, 0x83, 0xce, 0xbc, 0xce, 0xb5, 0xed, 0xa0, 0x80})
+		}, WebSocketCloseInvalidPayload},
+		{"Truncated UTF-8 in fragments", func(c *webSocketTestClient) {
+			c.writeFrame(false, false, webSocketOpText, []byte("ok"))
+			c.writeFrame(true, false, webSocketOpContinuation, []byte{0xe2, 0x82})
+		}, WebSocketCloseInvalidPayload},
+		{"Reserved close code", func(c *webSocketTestClient) {
+			c.writeFrame(true, false, webSocketOpClose, closePayload(1005, ""))
+		}, WebSocketCloseProtocolError},
+		{"Unassigned close code", func(c *webSocketTestClient) {
+			c.writeFrame(true, false, webSocketOpClose, closePayload(2000, ""))
+		}, WebSocketCloseProtocolError},
+		{"Single byte close payload", func(c *webSocketTestClient) {
+			c.writeFrame(true, false, webSocketOpClose, []byte{0x03})
+		}, WebSocketCloseProtocolError},
+		{"Invalid UTF-8 close reason", func(c *webSocketTestClient) {
+			c.writeFrame(true, false, webSocketOpClose, closePayload(WebSocketCloseNormal, "\xff"))
+		}, WebSocketCloseInvalidPayload},
+		{"Compressed without negotiating", func(c *webSocketTestClient) {
+			c.writeFrame(true, true, webSocketOpText, []byte("hi"))
+		}, WebSocketCloseProtocolError},
+		{"Message too large", func(c *webSocketTestClient) {
+			c.writeFrame(true, false, webSocketOpBinary, make([]byte, 2048))
+		}, WebSocketCloseMessageTooBig},
+		{"Fragments too large", func(c *webSocketTestClient) {
+			c.writeFrame(false, false, webSocketOpBinary, make([]byte, 1000))
+			c.writeFrame(true, false, webSocketOpContinuation, make([]byte, 1000))
+		}, WebSocketCloseMessageTooBig},
+	}
+
+	options := DefaultWebSocketOptions()
+	options.Compression = false
+	options.MaxMessageSize = 1024
+
+	errs := make(chan error, 1)
+	_, addr := startWebSocketTestServer(t, options, func(socket *WebSocket) {
+		for {
+			if _, _, err := socket.ReadMessage(); err != nil {
+				errs <- err
+				return
+			}
+		}
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := dialWebSocket(t, addr, "")
+			tt.send(client)
+			client.expectClose(tt.expectedCode)
+
+			err := <-errs
+			if !errors.Is(err, ErrWebSocketProtocol) && !errors.Is(err, ErrWebSocketMessageTooLarge) {
+				t.Errorf("Expected ReadMessage to report the violation but received %v", err)
+			}
+		})
+	}
+}
+
+func TestWebSocket_ClientClose(t *testing.T) {
+	closeErrs := make(chan error, 1)
+	_, addr := startWebSocketTestServer(t, DefaultWebSocketOptions(), func(socket *WebSocket) {
+		_, _, err := socket.ReadMessage()
+		closeErrs <- err
+	})
+
+	client := dialWebSocket(t, addr, "")
+	client.writeFrame(true, false, webSocketOpClose, closePayload(4000, "done"))
+	client.expectClose(4000)
+
+	var closeErr *WebSocketCloseError
+	if err := <-closeErrs; !errors.As(err, &closeErr) || closeErr.Code != 4000 || closeErr.Reason != "done" {
+		t.Fatalf("Expected a close error with the client's code and reason but received %v", err)
+	}
+}
+
+func TestWebSocket_ServerClose(t *testing.T) {
+	done := make(chan error, 1)
+	_, addr := startWebSocketTestServer(t, DefaultWebSocketOptions(), func(socket *WebSocket) {
+		_ = socket.WriteMessage(WebSocketText, []byte("goodbye"))
+		_ = socket.Close(WebSocketClosePolicyViolation, "not allowed")
+		done <- socket.WriteMessage(WebSocketText, []byte("too late"))
+	})
+
+	client := dialWebSocket(t, addr, "")
+	client.expectFrame(webSocketOpText, "goodbye")
+	client.expectFrame(webSocketOpClose, string(closePayload(WebSocketClosePolicyViolation, "not allowed")))
+
+	if err := <-done; !errors.Is(err, ErrWebSocketClosed) {
+		t.Errorf("Expected writing after closing to fail but received %v", err)
+	}
+
+	// The server waits for our answer before closing the connection
+	client.writeFrame(true, false, webSocketOpClose, closePayload(WebSocketClosePolicyViolation, ""))
+	if _, err := client.reader.ReadByte(); err == nil {
+		t.Fatalf("Expected the server to close the connection after the closing handshake")
+	}
+}
+
+func TestWebSocket_Compression(t *testing.T) {
+	_, addr := startWebSocketTestServer(t, DefaultWebSocketOptions(), echoWebSocket)
+
+	client := dialWebSocket(t, addr, "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+	if !strings.Contains(client.response, "Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover\r\n") {
+		t.Fatalf("Expected compression to be negotiated in %q", client.response)
+	}
+
+	// The client keeps its compression context, so later messages refer back to earlier ones
+	var compressed bytes.Buffer
+	compressor, _ := flate.NewWriter(&compressed, flate.BestCompression)
+
+	for _, message := range []string{strings.Repeat("compress me ", 50), strings.Repeat("compress me ", 50) + "again"} {
+		compressed.Reset()
+		_, _ = compressor.Write([]byte(message))
+		_ = compressor.Flush()
+		payload := bytes.TrimSuffix(compressed.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+
+		// Send it in two fragments, with the compression bit only on the first
+		client.writeFrame(false, true, webSocketOpText, payload[:len(payload)/2])
+		client.writeFrame(true, false, webSocketOpContinuation, payload[len(payload)/2:])
+
+		_, rsv1, opcode, reply := client.readFrame()
+		if !rsv1 || opcode != webSocketOpText {
+			t.Fatalf("Expected a compressed text message but received opcode %d with compression %v", opcode, rsv1)
+		}
+
+		inflated, err := io.ReadAll(flate.NewReader(io.MultiReader(bytes.NewReader(reply), bytes.NewReader(webSocketDeflateTail))))
+		if err != nil {
+			t.Fatalf("Could not inflate the reply: %v", err)
+		}
+
+		if string(inflated) != message {
+			t.Fatalf("Expected the message to be echoed but received %q", inflated)
+		}
+	}
+}
+
+func TestWebSocket_CompressionWithoutLimit(t *testing.T) {
+	options := DefaultWebSocketOptions()
+	options.MaxMessageSize = 0
+	_, addr := startWebSocketTestServer(t, options, echoWebSocket)
+
+	client := dialWebSocket(t, addr, "Sec-WebSocket-Extensions: permessage-deflate\r\n")
+
+	message := strings.Repeat("no limit ", 100)
+	var compressed bytes.Buffer
+	compressor, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	_, _ = compressor.Write([]byte(message))
+	_ = compressor.Flush()
+	client.writeFrame(true, true, webSocketOpText, bytes.TrimSuffix(compressed.Bytes(), []byte{0x00, 0x00, 0xff, 0xff}))
+
+	_, _, _, reply := client.readFrame()
+	inflated, err := io.ReadAll(flate.NewReader(io.MultiReader(bytes.NewReader(reply), bytes.NewReader(webSocketDeflateTail))))
+	if err != nil {
+		t.Fatalf("Could not inflate the reply: %v", err)
+	}
+
+	if string(inflated) != message {
+		t.Fatalf("Expected the message to be echoed but received %q", inflated)
+	}
+}
+
+func TestWebSocket_ShutdownClosesConnections(t *testing.T) {
+	readErrs := make(chan error, 1)
+	ws, addr := startWebSocketTestServer(t, DefaultWebSocketOptions(), func(socket *WebSocket) {
+		_, _, err := socket.ReadMessage()
+		readErrs <- err
+	})
+
+	client := dialWebSocket(t, addr, "")
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- ws.Shutdown(ctx)
+	}()
+
+	_, _, opcode, payload := client.readFrame()
+	if opcode != webSocketOpClose || binary.BigEndian.Uint16(payload) != WebSocketCloseGoingAway {
+		t.Fatalf("Expected a going away close frame but received opcode %d with %q", opcode, payload)
+	}
+	client.writeFrame(true, false, webSocketOpClose, closePayload(WebSocketCloseGoingAway, ""))
+
+	var closeErr *WebSocketCloseError
+	if err := <-readErrs; !errors.As(err, &closeErr) {
+		t.Errorf("Expected the handler to see the close but received %v", err)
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Expected the shutdown to complete but received %v", err)
+	}
+}
+
+func TestWebSocket_Ping(t *testing.T) {
+	options := DefaultWebSocketOptions()
+	options.PingInterval = 20 * time.Millisecond
+
+	_, addr := startWebSocketTestServer(t, options, echoWebSocket)
+	client := dialWebSocket(t, addr, "")
+
+	client.expectFrame(webSocketOpPing, "")
+	client.writeFrame(true, false, webSocketOpPong, nil)
+	client.expectFrame(webSocketOpPing, "")
+}